@@ -0,0 +1,466 @@
+// Package audit keeps a tamper-evident, append-only log of administrative and
+// moderation actions as JSON Lines. Every entry carries the hash of the entry before
+// it, so editing, reordering or removing a line breaks the chain and Verify reports it.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions recorded by Chirpy
+const (
+	ActionAdminRequest = "admin.request"
+	ActionChirpDelete  = "chirp.delete"
+	ActionChirpCleaned = "chirp.cleaned"
+)
+
+// Defaults for a Log unless WithMaxSize or WithMaxFiles say otherwise
+const (
+	DefaultMaxSize  = 10 << 20
+	DefaultMaxFiles = 10
+)
+
+// Entry is one line of the log. Seq, PrevHash and Hash are filled in by Record.
+type Entry struct {
+	Seq       int64             `json:"seq"`
+	Time      time.Time         `json:"time"`
+	Actor     string            `json:"actor"`
+	Action    string            `json:"action"`
+	Target    string            `json:"target,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	PrevHash  string            `json:"prev_hash"`
+	Hash      string            `json:"hash"`
+}
+
+// computeHash returns the SHA-256 of e's JSON encoding without its Hash
+func (e Entry) computeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Filter selects entries in Query. Zero fields match everything.
+type Filter struct {
+	Actor  string
+	Action string
+	Since  time.Time
+	Until  time.Time
+	// Limit keeps only the newest Limit matches
+	Limit int
+}
+
+func (f Filter) match(e Entry) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
+
+// Log appends entries to a file, rotating it to path.1, path.2, ... once it would
+// grow past the maximum size. The chain continues across rotated files, of which only
+// the newest are kept.
+type Log struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+	seq      int64
+	lastHash string
+}
+
+// Option configures a Log
+type Option func(*Log)
+
+// WithMaxSize rotates the log before it grows past n bytes
+func WithMaxSize(n int64) Option {
+	return func(l *Log) {
+		l.maxSize = n
+	}
+}
+
+// WithMaxFiles keeps the n newest rotated files, deleting older ones as the log is
+// rotated; 0 keeps them all
+func WithMaxFiles(n int) Option {
+	return func(l *Log) {
+		l.maxFiles = n
+	}
+}
+
+// Open opens the log at path for appending, creating it if needed, and picks up the
+// chain from its last entry. A last line cut short by a crash or a full disk is ended
+// so new entries start on a line of their own; Open and Query skip it, and Verify
+// reports it.
+func Open(path string, opts ...Option) (*Log, error) {
+	l := &Log{path: path, maxSize: DefaultMaxSize, maxFiles: DefaultMaxFiles}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	files, err := logFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		last, ok, err := lastEntry(files[i])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			l.seq, l.lastHash = last.Seq, last.Hash
+			break
+		}
+	}
+
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	if err := l.endTornLine(); err != nil {
+		l.file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// endTornLine appends a newline to the file if its last line doesn't end with one
+func (l *Log) endTornLine() error {
+	if l.size == 0 {
+		return nil
+	}
+	f, err := os.Open(l.path)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	defer f.Close()
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, l.size-1); err != nil {
+		return fmt.Errorf("audit: read %s: %w", l.path, err)
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	if _, err := l.file.Write([]byte{'\n'}); err != nil {
+		return fmt.Errorf("audit: write %s: %w", l.path, err)
+	}
+	l.size++
+	return nil
+}
+
+func (l *Log) openFile() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("audit: %w", err)
+	}
+	l.file, l.size = f, info.Size()
+	return nil
+}
+
+// Record completes e with its sequence number, time (if unset) and hashes, appends
+// it to the log and returns it
+func (l *Log) Record(e Entry) (Entry, error) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return Entry{}, errors.New("audit: log is closed")
+	}
+
+	e.Seq = l.seq + 1
+	e.PrevHash = l.lastHash
+	e.Hash = e.computeHash()
+	line, err := json.Marshal(e)
+	if err != nil {
+		return Entry{}, fmt.Errorf("audit: encode entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return Entry{}, err
+		}
+	}
+	if _, err := l.file.Write(line); err != nil {
+		return Entry{}, fmt.Errorf("audit: write %s: %w", l.path, err)
+	}
+	l.size += int64(len(line))
+	l.seq, l.lastHash = e.Seq, e.Hash
+	return e, nil
+}
+
+// rotate renames the current file to the path.N after the newest rotated file, starts
+// a new one and deletes the rotated files past maxFiles, oldest first
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	l.file = nil
+
+	files, err := logFiles(l.path)
+	if err != nil {
+		return err
+	}
+	rotated := files[:len(files)-1]
+	next := 1
+	if len(rotated) > 0 {
+		next = rotatedNumber(l.path, rotated[len(rotated)-1]) + 1
+	}
+	name := l.path + "." + strconv.Itoa(next)
+	if err := os.Rename(l.path, name); err != nil {
+		return fmt.Errorf("audit: rotate %s: %w", l.path, err)
+	}
+	if err := l.openFile(); err != nil {
+		return err
+	}
+
+	rotated = append(rotated, name)
+	for l.maxFiles > 0 && len(rotated) > l.maxFiles {
+		if err := os.Remove(rotated[0]); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("audit: %w", err)
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// Close closes the log file; Record fails afterwards
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Query returns the entries matching f, oldest first, across rotated files. It reads
+// the log as it was when called, without holding up Record while it reads. Files are
+// read newest first, so once f.Limit matches are found the older ones are left unread.
+func (l *Log) Query(f Filter) ([]Entry, error) {
+	rotated, current, size, err := l.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer current.Close()
+
+	collect := func(matches *[]Entry) func(string, int, Entry) error {
+		return func(_ string, _ int, e Entry) error {
+			if f.match(e) {
+				*matches = append(*matches, e)
+				if f.Limit > 0 && len(*matches) > f.Limit {
+					*matches = (*matches)[1:]
+				}
+			}
+			return nil
+		}
+	}
+	var matches []Entry
+	if err := readEntries(l.path, io.LimitReader(current, size), true, collect(&matches)); err != nil {
+		return nil, err
+	}
+	for i := len(rotated) - 1; i >= 0 && (f.Limit == 0 || len(matches) < f.Limit); i-- {
+		var older []Entry
+		if err := readFile(rotated[i], true, collect(&older)); err != nil {
+			return nil, err
+		}
+		matches = append(older, matches...)
+	}
+	if f.Limit > 0 && len(matches) > f.Limit {
+		matches = matches[len(matches)-f.Limit:]
+	}
+	return matches, nil
+}
+
+// snapshot returns the rotated files and opens the current one, with the size it has
+// now. Rotated files never change, and the open file stays readable if it is rotated
+// afterwards, so reading these up to size sees exactly the entries recorded so far.
+func (l *Log) snapshot() (rotated []string, current *os.File, size int64, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	files, err := logFiles(l.path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	current, err = os.Open(l.path)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("audit: %w", err)
+	}
+	return files[:len(files)-1], current, l.size, nil
+}
+
+// ChainError reports where Verify found the chain broken
+type ChainError struct {
+	File   string
+	Line   int
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit: %s:%d: %s", e.File, e.Line, e.Reason)
+}
+
+// Verify checks every entry of the log at path and its rotated files and returns how
+// many there are. A broken chain is reported as a *ChainError. The first entry that
+// is still on disk is trusted, since the files before it may have been deleted.
+func Verify(path string) (int, error) {
+	count := 0
+	var prev *Entry
+	err := readAll(path, func(file string, line int, e Entry) error {
+		broken := func(format string, args ...any) error {
+			return &ChainError{File: file, Line: line, Reason: fmt.Sprintf(format, args...)}
+		}
+		if got := e.computeHash(); got != e.Hash {
+			return broken("entry %d has been modified", e.Seq)
+		}
+		if prev != nil {
+			if e.Seq != prev.Seq+1 {
+				return broken("entry %d follows entry %d", e.Seq, prev.Seq)
+			}
+			if e.PrevHash != prev.Hash {
+				return broken("entry %d does not chain to entry %d", e.Seq, prev.Seq)
+			}
+		}
+		count++
+		prev = &e
+		return nil
+	})
+	return count, err
+}
+
+// readAll calls fn for every entry in the rotated files of path, oldest first, then path itself
+func readAll(path string, fn func(file string, line int, e Entry) error) error {
+	files, err := logFiles(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := readFile(file, false, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(file string, skipInvalid bool, fn func(file string, line int, e Entry) error) error {
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	defer f.Close()
+	return readEntries(file, f, skipInvalid, fn)
+}
+
+// readEntries calls fn for every entry read from r, which holds the contents of file.
+// A line that isn't an entry, such as one cut short by a crash, is a *ChainError
+// unless skipInvalid is set.
+func readEntries(file string, r io.Reader, skipInvalid bool, fn func(file string, line int, e Entry) error) error {
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			var e Entry
+			if jsonErr := json.Unmarshal(data, &e); jsonErr != nil {
+				if !skipInvalid {
+					return &ChainError{File: file, Line: line, Reason: "not a valid entry, or one cut short by a crash"}
+				}
+			} else if err := fn(file, line, e); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("audit: read %s: %w", file, err)
+		}
+	}
+}
+
+// lastEntry returns the final entry of file, if it has any
+func lastEntry(file string) (Entry, bool, error) {
+	var last Entry
+	found := false
+	err := readFile(file, true, func(_ string, _ int, e Entry) error {
+		last, found = e, true
+		return nil
+	})
+	return last, found, err
+}
+
+// logFiles returns the rotated files of path in the order they were written, followed by path
+func logFiles(path string) ([]string, error) {
+	matches, err := filepath.Glob(globEscape(path) + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	type rotated struct {
+		file string
+		n    int
+	}
+	var files []rotated
+	for _, match := range matches {
+		if n := rotatedNumber(path, match); n > 0 {
+			files = append(files, rotated{match, n})
+		}
+	}
+	slices.SortFunc(files, func(a, b rotated) int { return a.n - b.n })
+
+	paths := make([]string, 0, len(files)+1)
+	for _, f := range files {
+		paths = append(paths, f.file)
+	}
+	return append(paths, path), nil
+}
+
+// rotatedNumber returns N for the rotated file path.N, or 0 if file isn't one
+func rotatedNumber(path, file string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(file, path+"."))
+	if err != nil || n < 1 {
+		return 0
+	}
+	return n
+}
+
+// globEscape quotes the glob metacharacters in path
+func globEscape(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,353 @@
+package audit_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShepBook/chirpy/internal/audit"
+)
+
+// openLog opens a log in a temporary directory and returns it with its path
+func openLog(t *testing.T, opts ...audit.Option) (*audit.Log, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := audit.Open(path, opts...)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	t.Cleanup(func() { log.Close() })
+	return log, path
+}
+
+// record appends n chirp deletions by actor
+func record(t *testing.T, log *audit.Log, actor string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := log.Record(audit.Entry{Actor: actor, Action: audit.ActionChirpDelete, Target: "chirp"}); err != nil {
+			t.Fatalf("Record error: %v", err)
+		}
+	}
+}
+
+// Phase 1: Hash Chain Testing
+
+func Test_Record_ChainsEntries(t *testing.T) {
+	log, _ := openLog(t)
+
+	first, err := log.Record(audit.Entry{Actor: "admin", Action: audit.ActionAdminRequest})
+	if err != nil {
+		t.Fatalf("Record error: %v", err)
+	}
+	second, err := log.Record(audit.Entry{Actor: "admin", Action: audit.ActionAdminRequest})
+	if err != nil {
+		t.Fatalf("Record error: %v", err)
+	}
+
+	if first.Seq != 1 || first.PrevHash != "" || first.Hash == "" || first.Time.IsZero() {
+		t.Errorf("First entry = %+v, want seq 1, no previous hash, a hash and a time", first)
+	}
+	if second.Seq != 2 || second.PrevHash != first.Hash || second.Hash == first.Hash {
+		t.Errorf("Second entry = %+v, want seq 2 chained to %s", second, first.Hash)
+	}
+}
+
+func Test_Open_ExistingLog_ContinuesChain(t *testing.T) {
+	log, path := openLog(t)
+	record(t, log, "admin", 3)
+	log.Close()
+
+	reopened, err := audit.Open(path)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	defer reopened.Close()
+	record(t, reopened, "admin", 1)
+
+	if n, err := audit.Verify(path); err != nil || n != 4 {
+		t.Errorf("Verify = %d, %v; want 4 entries and no error", n, err)
+	}
+}
+
+func Test_Verify_TamperedLog_ReportsBrokenChain(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+	}{
+		{"edited entry", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"actor":"admin"`, `"actor":"someone"`, 1)
+			return lines
+		}},
+		{"removed entry", func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}},
+		{"reordered entries", func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}},
+		{"garbage line", func(lines []string) []string {
+			lines[2] = "not json"
+			return lines
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, path := openLog(t)
+			record(t, log, "admin", 4)
+			log.Close()
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("ReadFile error: %v", err)
+			}
+			lines := tt.tamper(strings.Split(strings.TrimSpace(string(data)), "\n"))
+			if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+				t.Fatalf("WriteFile error: %v", err)
+			}
+
+			_, err = audit.Verify(path)
+			var chainErr *audit.ChainError
+			if !errors.As(err, &chainErr) {
+				t.Fatalf("Verify error = %v, want a *ChainError", err)
+			}
+			if chainErr.Line < 2 || chainErr.Line > 3 {
+				t.Errorf("Broken chain reported at line %d, want line 2 or 3", chainErr.Line)
+			}
+		})
+	}
+}
+
+func Test_Verify_MissingLog_HasNoEntries(t *testing.T) {
+	n, err := audit.Verify(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil || n != 0 {
+		t.Errorf("Verify = %d, %v; want 0 entries and no error", n, err)
+	}
+}
+
+func Test_Open_TornLastLine_ContinuesAndVerifyReportsIt(t *testing.T) {
+	log, path := openLog(t)
+	record(t, log, "admin", 3)
+	log.Close()
+
+	// As if the process crashed halfway through writing the fourth entry
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("OpenFile error: %v", err)
+	}
+	f.WriteString(`{"seq":4,"time":"2026-`)
+	f.Close()
+
+	reopened, err := audit.Open(path)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	defer reopened.Close()
+	entry, err := reopened.Record(audit.Entry{Actor: "admin", Action: audit.ActionChirpDelete})
+	if err != nil {
+		t.Fatalf("Record error: %v", err)
+	}
+	if entry.Seq != 4 {
+		t.Errorf("Recorded seq %d, want 4", entry.Seq)
+	}
+
+	entries, err := reopened.Query(audit.Filter{})
+	if err != nil || len(entries) != 4 || entries[3].Seq != 4 {
+		t.Errorf("Query = %d entries, %v; want 4 ending with seq 4", len(entries), err)
+	}
+	n, err := audit.Verify(path)
+	var chainErr *audit.ChainError
+	if !errors.As(err, &chainErr) || chainErr.Line != 4 || n != 3 {
+		t.Errorf("Verify = %d, %v; want 3 entries and a *ChainError at line 4", n, err)
+	}
+}
+
+// Phase 2: Rotation Testing
+
+func Test_Record_PastMaxSize_RotatesAndKeepsChain(t *testing.T) {
+	log, path := openLog(t, audit.WithMaxSize(1024))
+	record(t, log, "admin", 20)
+
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatalf("Glob error: %v", err)
+	}
+	if len(rotated) < 2 {
+		t.Fatalf("Got rotated files %v, want at least 2", rotated)
+	}
+	for _, file := range append(rotated, path) {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatalf("Stat error: %v", err)
+		}
+		if info.Size() > 1024 {
+			t.Errorf("%s is %d bytes, want at most 1024", file, info.Size())
+		}
+	}
+
+	if n, err := audit.Verify(path); err != nil || n != 20 {
+		t.Errorf("Verify = %d, %v; want 20 entries and no error", n, err)
+	}
+	entries, err := log.Query(audit.Filter{})
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	if len(entries) != 20 || entries[0].Seq != 1 || entries[19].Seq != 20 {
+		t.Errorf("Query returned %d entries, want seq 1 to 20 in order", len(entries))
+	}
+}
+
+func Test_Open_OnlyRotatedFiles_ContinuesChain(t *testing.T) {
+	log, path := openLog(t, audit.WithMaxSize(1024))
+	record(t, log, "admin", 8)
+	log.Close()
+
+	// As if the process stopped right after rotating, before writing to the new file
+	rotated, _ := filepath.Glob(path + ".*")
+	if err := os.Rename(path, path+"."+strconv.Itoa(len(rotated)+1)); err != nil {
+		t.Fatalf("Rename error: %v", err)
+	}
+
+	reopened, err := audit.Open(path, audit.WithMaxSize(1024))
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	defer reopened.Close()
+	record(t, reopened, "admin", 1)
+
+	if n, err := audit.Verify(path); err != nil || n != 9 {
+		t.Errorf("Verify = %d, %v; want 9 entries and no error", n, err)
+	}
+}
+
+func Test_Record_PastMaxFiles_DeletesOldestRotated(t *testing.T) {
+	log, path := openLog(t, audit.WithMaxSize(1024), audit.WithMaxFiles(2))
+	record(t, log, "admin", 40)
+
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatalf("Glob error: %v", err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("Got rotated files %v, want 2", rotated)
+	}
+	if _, err := os.Stat(path + ".1"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the oldest rotated file to be deleted, got %v", err)
+	}
+
+	// What is left still verifies, starting from the oldest entry kept
+	entries, err := log.Query(audit.Filter{})
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	if n, err := audit.Verify(path); err != nil || n != len(entries) || entries[len(entries)-1].Seq != 40 {
+		t.Errorf("Verify = %d, %v; want the %d entries kept, ending with seq 40", n, err, len(entries))
+	}
+}
+
+// Phase 3: Query Testing
+
+func Test_Query_Filter_SelectsEntries(t *testing.T) {
+	log, _ := openLog(t)
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, e := range []audit.Entry{
+		{Actor: "alice", Action: audit.ActionChirpDelete},
+		{Actor: "bob", Action: audit.ActionChirpCleaned},
+		{Actor: "alice", Action: audit.ActionChirpCleaned},
+		{Actor: "alice", Action: audit.ActionChirpDelete},
+	} {
+		e.Time = base.Add(time.Duration(i) * time.Minute)
+		if _, err := log.Record(e); err != nil {
+			t.Fatalf("Record error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter audit.Filter
+		want   []int64
+	}{
+		{"everything", audit.Filter{}, []int64{1, 2, 3, 4}},
+		{"actor", audit.Filter{Actor: "alice"}, []int64{1, 3, 4}},
+		{"action", audit.Filter{Action: audit.ActionChirpCleaned}, []int64{2, 3}},
+		{"since is inclusive", audit.Filter{Since: base.Add(2 * time.Minute)}, []int64{3, 4}},
+		{"until is exclusive", audit.Filter{Until: base.Add(2 * time.Minute)}, []int64{1, 2}},
+		{"limit keeps the newest", audit.Filter{Actor: "alice", Limit: 2}, []int64{3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := log.Query(tt.filter)
+			if err != nil {
+				t.Fatalf("Query error: %v", err)
+			}
+			var got []int64
+			for _, e := range entries {
+				got = append(got, e.Seq)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Got entries %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Query_DuringRecordAndRotation_SeesConsistentLog(t *testing.T) {
+	log, _ := openLog(t, audit.WithMaxSize(2048), audit.WithMaxFiles(0))
+	record(t, log, "admin", 5)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			if _, err := log.Record(audit.Entry{Actor: "admin", Action: audit.ActionChirpDelete}); err != nil {
+				t.Errorf("Record error: %v", err)
+				return
+			}
+		}
+	}()
+
+	for queries := 0; ; queries++ {
+		entries, err := log.Query(audit.Filter{})
+		if err != nil {
+			t.Fatalf("Query error: %v", err)
+		}
+		for i, e := range entries {
+			if e.Seq != int64(i+1) {
+				t.Fatalf("Query %d: entry %d has seq %d, want every entry up to the newest", queries, i, e.Seq)
+			}
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
+
+func Test_Query_Limit_LeavesOlderFilesUnread(t *testing.T) {
+	log, path := openLog(t, audit.WithMaxSize(1024))
+	record(t, log, "admin", 20)
+
+	// An unreadable oldest file only matters to queries that get that far
+	if err := os.Remove(path + ".1"); err != nil {
+		t.Fatalf("Remove error: %v", err)
+	}
+	if err := os.Mkdir(path+".1", 0o700); err != nil {
+		t.Fatalf("Mkdir error: %v", err)
+	}
+
+	entries, err := log.Query(audit.Filter{Limit: 2})
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Seq != 19 || entries[1].Seq != 20 {
+		t.Errorf("Query returned %+v, want seq 19 and 20", entries)
+	}
+	if _, err := log.Query(audit.Filter{}); err == nil {
+		t.Error("Expected a query of the whole log to reach the unreadable file")
+	}
+}
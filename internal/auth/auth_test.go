@@ -0,0 +1,259 @@
+package auth_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShepBook/chirpy/internal/auth"
+)
+
+// fastParams keeps key derivation cheap so the tests stay quick
+var fastParams = auth.PasswordParams{Iterations: 1000, SaltLength: 16, KeyLength: 32}
+
+// Phase 1: Password Hashing Tests
+
+func Test_PasswordParams_Hash_MatchesOriginalPassword(t *testing.T) {
+	hash, err := fastParams.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash error: %v", err)
+	}
+
+	if strings.Contains(hash, "correct horse") {
+		t.Error("Hash must not contain the plaintext password")
+	}
+	if err := auth.CheckPasswordHash("correct horse", hash); err != nil {
+		t.Errorf("CheckPasswordHash error = %v, want nil", err)
+	}
+}
+
+func Test_PasswordParams_Hash_WrongPassword_ReturnsMismatch(t *testing.T) {
+	hash, err := fastParams.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash error: %v", err)
+	}
+
+	if err := auth.CheckPasswordHash("battery staple", hash); !errors.Is(err, auth.ErrPasswordMismatch) {
+		t.Errorf("CheckPasswordHash error = %v, want %v", err, auth.ErrPasswordMismatch)
+	}
+}
+
+func Test_PasswordParams_Hash_SaltsEveryHash(t *testing.T) {
+	first, err := fastParams.Hash("same password")
+	if err != nil {
+		t.Fatalf("Hash error: %v", err)
+	}
+	second, err := fastParams.Hash("same password")
+	if err != nil {
+		t.Fatalf("Hash error: %v", err)
+	}
+
+	if first == second {
+		t.Error("Expected hashes of the same password to differ")
+	}
+}
+
+func Test_PasswordParams_Hash_EncodesIterations(t *testing.T) {
+	hash, err := fastParams.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash error: %v", err)
+	}
+
+	if !strings.HasPrefix(hash, "$pbkdf2-sha256$i=1000$") {
+		t.Errorf("Hash = %q, want $pbkdf2-sha256$i=1000$ prefix", hash)
+	}
+}
+
+func Test_PasswordParams_Hash_InvalidParams_ReturnsError(t *testing.T) {
+	if _, err := (auth.PasswordParams{}).Hash("password"); err == nil {
+		t.Error("Expected error for zero params, got nil")
+	}
+}
+
+func Test_CheckPasswordHash_MalformedHash_ReturnsError(t *testing.T) {
+	tests := []string{
+		"",
+		"plaintext",
+		"$pbkdf2-sha256$i=abc$c2FsdA$a2V5",
+		"$pbkdf2-sha256$i=1000$c2FsdA",
+		"$pbkdf2-sha256$i=1000$!!!$a2V5",
+	}
+
+	for _, hash := range tests {
+		err := auth.CheckPasswordHash("password", hash)
+		if err == nil || errors.Is(err, auth.ErrPasswordMismatch) {
+			t.Errorf("CheckPasswordHash(%q) error = %v, want malformed hash error", hash, err)
+		}
+	}
+}
+
+// Phase 2: JWT Tests
+
+func Test_MakeJWT_ValidateJWT_RoundTrip(t *testing.T) {
+	token, err := auth.MakeJWT("user-1", "secret", time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT error: %v", err)
+	}
+
+	userID, err := auth.ValidateJWT(token, "secret")
+	if err != nil {
+		t.Fatalf("ValidateJWT error: %v", err)
+	}
+	if userID != "user-1" {
+		t.Errorf("userID = %q, want %q", userID, "user-1")
+	}
+}
+
+func Test_ValidateJWT_WrongSecret_ReturnsErrInvalidToken(t *testing.T) {
+	token, err := auth.MakeJWT("user-1", "secret", time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT error: %v", err)
+	}
+
+	if _, err := auth.ValidateJWT(token, "other"); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("ValidateJWT error = %v, want %v", err, auth.ErrInvalidToken)
+	}
+}
+
+func Test_ValidateJWT_Expired_ReturnsErrExpiredToken(t *testing.T) {
+	token, err := auth.MakeJWT("user-1", "secret", -time.Second)
+	if err != nil {
+		t.Fatalf("MakeJWT error: %v", err)
+	}
+
+	if _, err := auth.ValidateJWT(token, "secret"); !errors.Is(err, auth.ErrExpiredToken) {
+		t.Errorf("ValidateJWT error = %v, want %v", err, auth.ErrExpiredToken)
+	}
+}
+
+func Test_ValidateJWT_TamperedToken_ReturnsErrInvalidToken(t *testing.T) {
+	token, err := auth.MakeJWT("user-1", "secret", time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT error: %v", err)
+	}
+	header, rest, _ := strings.Cut(token, ".")
+	_, signature, _ := strings.Cut(rest, ".")
+
+	tests := map[string]string{
+		"empty":           "",
+		"garbage":         "a.b.c",
+		"alg none":        base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + strings.Split(token, ".")[1] + ".",
+		"swapped payload": header + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"chirpy","sub":"admin","exp":9999999999}`)) + "." + signature,
+	}
+
+	for name, tampered := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := auth.ValidateJWT(tampered, "secret"); !errors.Is(err, auth.ErrInvalidToken) {
+				t.Errorf("ValidateJWT error = %v, want %v", err, auth.ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func Test_GetBearerToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{"valid", "Bearer abc", "abc", false},
+		{"lowercase scheme", "bearer abc", "abc", false},
+		{"missing", "", "", true},
+		{"wrong scheme", "Basic abc", "", true},
+		{"empty token", "Bearer ", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			if tt.header != "" {
+				headers.Set("Authorization", tt.header)
+			}
+
+			got, err := auth.GetBearerToken(headers)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetBearerToken error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetBearerToken = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_MakeJWTWithRole_ValidateJWTClaims_ReturnsRole(t *testing.T) {
+	token, err := auth.MakeJWTWithRole("user-1", auth.RoleAdmin, "secret", time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWTWithRole error: %v", err)
+	}
+
+	claims, err := auth.ValidateJWTClaims(token, "secret")
+	if err != nil {
+		t.Fatalf("ValidateJWTClaims error: %v", err)
+	}
+	if claims != (auth.Claims{UserID: "user-1", Role: auth.RoleAdmin}) {
+		t.Errorf("Claims = %+v, want user-1 with the admin role", claims)
+	}
+
+	plain, err := auth.MakeJWT("user-2", "secret", time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT error: %v", err)
+	}
+	if claims, err := auth.ValidateJWTClaims(plain, "secret"); err != nil || claims.Role != "" {
+		t.Errorf("ValidateJWTClaims = %+v, %v; want no role", claims, err)
+	}
+}
+
+func Test_GetAPIKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{"valid", "ApiKey abc", "abc", false},
+		{"lowercase scheme", "apikey abc", "abc", false},
+		{"missing", "", "", true},
+		{"bearer", "Bearer abc", "", true},
+		{"empty key", "ApiKey ", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			if tt.header != "" {
+				headers.Set("Authorization", tt.header)
+			}
+
+			got, err := auth.GetAPIKey(headers)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetAPIKey error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetAPIKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_MakeRefreshToken_ReturnsUniqueHex(t *testing.T) {
+	first, err := auth.MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("MakeRefreshToken error: %v", err)
+	}
+	second, err := auth.MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("MakeRefreshToken error: %v", err)
+	}
+
+	if len(first) != 64 {
+		t.Errorf("Token length = %d, want 64", len(first))
+	}
+	if first == second {
+		t.Error("Expected refresh tokens to differ")
+	}
+}
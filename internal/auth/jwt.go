@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TokenIssuer is the iss claim written into and required from every access token
+const TokenIssuer = "chirpy"
+
+// RoleAdmin is the role claim of access tokens that may use the /admin routes
+const RoleAdmin = "admin"
+
+var (
+	// ErrInvalidToken is returned when a token is malformed, badly signed or not issued by Chirpy
+	ErrInvalidToken = errors.New("auth: invalid token")
+	// ErrExpiredToken is returned when a correctly signed token is past its expiry
+	ErrExpiredToken = errors.New("auth: token expired")
+	// ErrNoBearerToken is returned when a request has no usable Authorization header
+	ErrNoBearerToken = errors.New("auth: no bearer token")
+	// ErrNoAPIKey is returned when a request has no "Authorization: ApiKey" header
+	ErrNoAPIKey = errors.New("auth: no api key")
+)
+
+// jwtHeader is the only header Chirpy issues or accepts; pinning alg prevents
+// "none" and algorithm-confusion attacks
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type jwtClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Role      string `json:"role,omitempty"`
+}
+
+// Claims are the checked contents of an access token
+type Claims struct {
+	UserID string
+	// Role is RoleAdmin for admins and empty for everyone else
+	Role string
+}
+
+// MakeJWT issues an HS256 access token for userID that expires after expiresIn
+func MakeJWT(userID, secret string, expiresIn time.Duration) (string, error) {
+	return MakeJWTWithRole(userID, "", secret, expiresIn)
+}
+
+// MakeJWTWithRole is MakeJWT with a role claim; an empty role is left out
+func MakeJWTWithRole(userID, role, secret string, expiresIn time.Duration) (string, error) {
+	issuedAt := time.Now().UTC()
+	claims, err := json.Marshal(jwtClaims{
+		Issuer:    TokenIssuer,
+		Subject:   userID,
+		IssuedAt:  issuedAt.Unix(),
+		ExpiresAt: issuedAt.Add(expiresIn).Unix(),
+		Role:      role,
+	})
+	if err != nil {
+		return "", fmt.Errorf("auth: encode claims: %w", err)
+	}
+
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signingInput + "." + signJWT(signingInput, secret), nil
+}
+
+// ValidateJWT checks the signature, issuer and expiry of token and returns its subject user ID
+func ValidateJWT(token, secret string) (string, error) {
+	claims, err := ValidateJWTClaims(token, secret)
+	return claims.UserID, err
+}
+
+// ValidateJWTClaims checks token like ValidateJWT and returns its user ID and role
+func ValidateJWTClaims(token, secret string) (Claims, error) {
+	header, rest, ok := strings.Cut(token, ".")
+	if !ok || header != jwtHeader {
+		return Claims{}, ErrInvalidToken
+	}
+	payload, signature, ok := strings.Cut(rest, ".")
+	if !ok {
+		return Claims{}, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(signature), []byte(signJWT(header+"."+payload, secret))) {
+		return Claims{}, ErrInvalidToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	if claims.Issuer != TokenIssuer || claims.Subject == "" {
+		return Claims{}, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return Claims{}, ErrExpiredToken
+	}
+	return Claims{UserID: claims.Subject, Role: claims.Role}, nil
+}
+
+// signJWT returns the base64url HMAC-SHA256 signature of signingInput
+func signJWT(signingInput, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// GetBearerToken extracts the token from an "Authorization: Bearer <token>" header
+func GetBearerToken(headers http.Header) (string, error) {
+	scheme, token, ok := strings.Cut(headers.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", ErrNoBearerToken
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", ErrNoBearerToken
+	}
+	return token, nil
+}
+
+// GetAPIKey extracts the key from an "Authorization: ApiKey <key>" header
+func GetAPIKey(headers http.Header) (string, error) {
+	scheme, key, ok := strings.Cut(headers.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "ApiKey") {
+		return "", ErrNoAPIKey
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", ErrNoAPIKey
+	}
+	return key, nil
+}
+
+// MakeRefreshToken returns a random opaque 256-bit token encoded as hex
+func MakeRefreshToken() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("auth: generate refresh token: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
@@ -0,0 +1,108 @@
+// Package auth hashes passwords and issues tokens for Chirpy users
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrPasswordMismatch is returned when a password does not match its hash
+var ErrPasswordMismatch = errors.New("auth: password does not match")
+
+// PasswordParams tunes the PBKDF2-HMAC-SHA256 key derivation used for password hashes.
+// Raising Iterations makes every guess more expensive for an attacker holding the hashes.
+type PasswordParams struct {
+	Iterations int
+	SaltLength int
+	KeyLength  int
+}
+
+// DefaultPasswordParams follows the OWASP recommendation for PBKDF2-HMAC-SHA256
+var DefaultPasswordParams = PasswordParams{
+	Iterations: 600_000,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+const passwordHashPrefix = "$pbkdf2-sha256$"
+
+// HashPassword hashes password with DefaultPasswordParams
+func HashPassword(password string) (string, error) {
+	return DefaultPasswordParams.Hash(password)
+}
+
+// Hash derives a key from password and a fresh random salt and encodes both,
+// together with the iteration count, as
+//
+//	$pbkdf2-sha256$i=<iterations>$<salt>$<key>
+//
+// so the hash can still be checked after the defaults change
+func (p PasswordParams) Hash(password string) (string, error) {
+	if p.Iterations < 1 || p.SaltLength < 1 || p.KeyLength < 1 {
+		return "", fmt.Errorf("auth: invalid password params %+v", p)
+	}
+
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("auth: generate salt: %w", err)
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, p.Iterations, p.KeyLength)
+	if err != nil {
+		return "", fmt.Errorf("auth: derive key: %w", err)
+	}
+
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%si=%d$%s$%s", passwordHashPrefix, p.Iterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// CheckPasswordHash returns nil if password matches hash and ErrPasswordMismatch if it doesn't
+func CheckPasswordHash(password, hash string) error {
+	iterations, salt, want, err := parsePasswordHash(hash)
+	if err != nil {
+		return err
+	}
+
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return fmt.Errorf("auth: derive key: %w", err)
+	}
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// parsePasswordHash splits a hash produced by PasswordParams.Hash into its parts
+func parsePasswordHash(hash string) (iterations int, salt, key []byte, err error) {
+	rest, ok := strings.CutPrefix(hash, passwordHashPrefix)
+	if !ok {
+		return 0, nil, nil, errors.New("auth: unsupported password hash format")
+	}
+
+	parts := strings.Split(rest, "$")
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "i=") {
+		return 0, nil, nil, errors.New("auth: malformed password hash")
+	}
+
+	iterations, err = strconv.Atoi(strings.TrimPrefix(parts[0], "i="))
+	if err != nil || iterations < 1 {
+		return 0, nil, nil, errors.New("auth: malformed password hash iterations")
+	}
+
+	enc := base64.RawStdEncoding
+	if salt, err = enc.DecodeString(parts[1]); err != nil {
+		return 0, nil, nil, errors.New("auth: malformed password hash salt")
+	}
+	if key, err = enc.DecodeString(parts[2]); err != nil || len(key) == 0 {
+		return 0, nil, nil, errors.New("auth: malformed password hash key")
+	}
+	return iterations, salt, key, nil
+}
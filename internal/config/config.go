@@ -0,0 +1,636 @@
+// Package config loads Chirpy's server settings from defaults, an optional config
+// file, environment variables and command-line flags, in increasing order of precedence
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is prepended to the upper-cased setting name to form its environment variable
+const EnvPrefix = "CHIRPY_"
+
+// Config holds every setting the server reads at startup
+type Config struct {
+	ListenAddr          string
+	ReadTimeout         time.Duration
+	WriteTimeout        time.Duration
+	IdleTimeout         time.Duration
+	ShutdownTimeout     time.Duration
+	DrainPeriod         time.Duration
+	ShutdownHookTimeout time.Duration
+	MaxChirpLength      int
+	StreamBuffer        int
+	StreamHeartbeat     time.Duration
+	MaxStreams          int
+	MaxClientStreams    int
+	StaticRoot          string
+	CacheControl        map[string]string
+	StaticListings      bool
+	SPAFallback         bool
+	LogLevel            string
+	LogFormat           string
+	ProfanityFile       string
+	ProfanityStrict     bool
+	ChirpsPath          string
+	UsersPath           string
+	RefreshTokensPath   string
+	HitsPath            string
+	HitsSaveInterval    time.Duration
+	MinFreeDisk         int
+	JWTSecret           string
+	Platform            string
+	AdminUserIDs        []string
+	AdminAPIKey         string
+	AuditLogPath        string
+	AuditLogMaxSize     int
+	AuditLogMaxFiles    int
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSSelfSigned       bool
+	TLSCacheDir         string
+	HTTPRedirectAddr    string
+	HSTSMaxAge          time.Duration
+	RateLimitAuth       RateLimit
+	RateLimitWrite      RateLimit
+	RateLimitRead       RateLimit
+	TrustedProxies      []netip.Prefix
+}
+
+// RateLimit allows Requests per Per with bursts of up to Burst. It is written as
+// "requests/period" or "requests/period:burst", e.g. "10/1m" or "5/s:20";
+// the zero value, written as "", means no limit.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+// Default returns the settings used when nothing overrides them
+func Default() Config {
+	return Config{
+		ListenAddr:          ":8080",
+		ReadTimeout:         5 * time.Second,
+		WriteTimeout:        10 * time.Second,
+		IdleTimeout:         120 * time.Second,
+		ShutdownTimeout:     5 * time.Second,
+		ShutdownHookTimeout: 5 * time.Second,
+		MaxChirpLength:      140,
+		StreamBuffer:        256,
+		StreamHeartbeat:     15 * time.Second,
+		MaxStreams:          1000,
+		MaxClientStreams:    10,
+		LogLevel:            "info",
+		LogFormat:           "json",
+		ChirpsPath:          "chirps.json",
+		UsersPath:           "users.json",
+		RefreshTokensPath:   "refresh_tokens.json",
+		HitsPath:            "hits.json",
+		HitsSaveInterval:    time.Minute,
+		MinFreeDisk:         64 << 20,
+		AuditLogPath:        "audit.jsonl",
+		AuditLogMaxSize:     10 << 20,
+		AuditLogMaxFiles:    10,
+		TLSCacheDir:         "certs",
+		// Sign-up and login each hash a password, which is costly enough to need a limit
+		RateLimitAuth: RateLimit{Requests: 10, Per: time.Minute},
+	}
+}
+
+// setting describes one configurable value. The same name is used as the file key,
+// as the flag (with - for _) and, upper-cased with EnvPrefix, as the environment variable.
+type setting struct {
+	name    string
+	usage   string
+	set     func(cfg *Config, value string) error
+	boolean bool
+}
+
+var settings = []setting{
+	stringSetting("listen_addr", "address to listen on, host:port", func(c *Config) *string { return &c.ListenAddr }),
+	durationSetting("read_timeout", "maximum time to read a request", func(c *Config) *time.Duration { return &c.ReadTimeout }),
+	durationSetting("write_timeout", "maximum time to write a response", func(c *Config) *time.Duration { return &c.WriteTimeout }),
+	durationSetting("idle_timeout", "how long idle keep-alive connections stay open", func(c *Config) *time.Duration { return &c.IdleTimeout }),
+	durationSetting("shutdown_timeout", "how long in-flight requests get to finish during graceful shutdown", func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	durationSetting("drain_period", "how long to keep serving after /api/readyz starts failing on shutdown, so load balancers can drain", func(c *Config) *time.Duration { return &c.DrainPeriod }),
+	durationSetting("shutdown_hook_timeout", "how long each shutdown step, such as saving hit counts, may take", func(c *Config) *time.Duration { return &c.ShutdownHookTimeout }),
+	intSetting("max_chirp_length", "maximum chirp length in characters", func(c *Config) *int { return &c.MaxChirpLength }),
+	intSetting("stream_buffer", "recent chirps kept for clients resuming /api/chirps/stream with Last-Event-ID", func(c *Config) *int { return &c.StreamBuffer }),
+	durationSetting("stream_heartbeat", "how often idle /api/chirps/stream connections get a heartbeat", func(c *Config) *time.Duration { return &c.StreamHeartbeat }),
+	intSetting("max_streams", "open /api/chirps/stream connections allowed at once", func(c *Config) *int { return &c.MaxStreams }),
+	intSetting("max_client_streams", "open /api/chirps/stream connections allowed per client address", func(c *Config) *int { return &c.MaxClientStreams }),
+	stringSetting("static_root", "serve / and /app from this directory instead of the embedded files (development)", func(c *Config) *string { return &c.StaticRoot }),
+	cacheControlSetting("cache_control", `Cache-Control per static file extension, e.g. ".css=public, max-age=86400; *=no-cache"`, func(c *Config) *map[string]string { return &c.CacheControl }),
+	boolSetting("static_listings", "list the files of /app directories that have no index.html", func(c *Config) *bool { return &c.StaticListings }),
+	boolSetting("spa_fallback", "serve /app/index.html for unknown /app paths without an extension (single-page apps)", func(c *Config) *bool { return &c.SPAFallback }),
+	stringSetting("log_level", "log level: debug, info, warn or error", func(c *Config) *string { return &c.LogLevel }),
+	stringSetting("log_format", "log format: json or text", func(c *Config) *string { return &c.LogFormat }),
+	stringSetting("profanity_file", "profanity word list file; empty uses the built-in list", func(c *Config) *string { return &c.ProfanityFile }),
+	boolSetting("profanity_strict", "treat punctuation as a word boundary and fold look-alike characters", func(c *Config) *bool { return &c.ProfanityStrict }),
+	stringSetting("chirps_path", "file the chirp store is saved to", func(c *Config) *string { return &c.ChirpsPath }),
+	stringSetting("users_path", "file the user store is saved to", func(c *Config) *string { return &c.UsersPath }),
+	stringSetting("refresh_tokens_path", "file the refresh token store is saved to", func(c *Config) *string { return &c.RefreshTokensPath }),
+	stringSetting("hits_path", "file the fileserver hit counts are saved to", func(c *Config) *string { return &c.HitsPath }),
+	durationSetting("hits_save_interval", "how often the fileserver hit counts are saved", func(c *Config) *time.Duration { return &c.HitsSaveInterval }),
+	intSetting("min_free_disk", "bytes that must be free next to the data files for /api/readyz to pass; 0 disables the check", func(c *Config) *int { return &c.MinFreeDisk }),
+	stringSetting("jwt_secret", "HMAC key for access tokens; empty uses a random key per start", func(c *Config) *string { return &c.JWTSecret }),
+	stringSetting("platform", "deployment environment; dev enables destructive admin endpoints such as /admin/reset", func(c *Config) *string { return &c.Platform }),
+	stringListSetting("admin_user_ids", "comma-separated IDs of users whose access tokens get the admin role", func(c *Config) *[]string { return &c.AdminUserIDs }),
+	stringSetting("admin_api_key", `key that grants admin access when sent as "Authorization: ApiKey <key>"`, func(c *Config) *string { return &c.AdminAPIKey }),
+	stringSetting("audit_log_path", "hash-chained log of admin requests, chirp deletions and cleaned chirps", func(c *Config) *string { return &c.AuditLogPath }),
+	intSetting("audit_log_max_size", "size in bytes at which the audit log is rotated", func(c *Config) *int { return &c.AuditLogMaxSize }),
+	intSetting("audit_log_max_files", "rotated audit log files kept before the oldest is deleted (0 keeps all)", func(c *Config) *int { return &c.AuditLogMaxFiles }),
+	stringSetting("tls_cert_file", "PEM certificate to serve HTTPS with; requires tls_key_file", func(c *Config) *string { return &c.TLSCertFile }),
+	stringSetting("tls_key_file", "PEM private key for tls_cert_file", func(c *Config) *string { return &c.TLSKeyFile }),
+	boolSetting("tls_self_signed", "serve HTTPS with a generated self-signed certificate (development only)", func(c *Config) *bool { return &c.TLSSelfSigned }),
+	stringSetting("tls_cache_dir", "directory the self-signed certificate is cached in", func(c *Config) *string { return &c.TLSCacheDir }),
+	stringSetting("http_redirect_addr", "plain HTTP address that redirects to HTTPS; empty disables it", func(c *Config) *string { return &c.HTTPRedirectAddr }),
+	rateLimitSetting("rate_limit_auth", "per-client limit on sign-up, login and token routes; 10/m unless set, empty for none", func(c *Config) *RateLimit { return &c.RateLimitAuth }),
+	rateLimitSetting("rate_limit_write", "per-client limit on validating, creating and deleting chirps", func(c *Config) *RateLimit { return &c.RateLimitWrite }),
+	rateLimitSetting("rate_limit_read", "per-client limit on reading chirps", func(c *Config) *RateLimit { return &c.RateLimitRead }),
+	prefixListSetting("trusted_proxies", "comma-separated proxy IPs or CIDRs whose X-Forwarded-For is trusted", func(c *Config) *[]netip.Prefix { return &c.TrustedProxies }),
+	durationSetting("hsts_max_age", "Strict-Transport-Security max-age on HTTPS responses; 0 disables it", func(c *Config) *time.Duration { return &c.HSTSMaxAge }),
+}
+
+// Load builds the configuration from, lowest precedence first: Default(), the config
+// file named by -config or CHIRPY_CONFIG, CHIRPY_* environment variables looked up with
+// getenv, and the command-line flags in args. The result is validated before it is returned.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("chirpy", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configPath := fs.String("config", getenv(EnvPrefix+"CONFIG"), "optional JSON or TOML config file")
+	for _, s := range settings {
+		fs.Var(&flagValue{boolean: s.boolean}, flagName(s.name), s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, fmt.Errorf("config: %w", err)
+	}
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("config: unexpected argument %q", fs.Arg(0))
+	}
+
+	if *configPath != "" {
+		values, err := readFile(*configPath)
+		if err != nil {
+			return Config{}, err
+		}
+		if err := apply(&cfg, values, *configPath); err != nil {
+			return Config{}, err
+		}
+	}
+
+	envValues := make(map[string]string)
+	for _, s := range settings {
+		if value := getenv(envName(s.name)); value != "" {
+			envValues[s.name] = value
+		}
+	}
+	if err := apply(&cfg, envValues, "environment"); err != nil {
+		return Config{}, err
+	}
+
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			set[strings.ReplaceAll(f.Name, "-", "_")] = f.Value.String()
+		}
+	})
+	if err := apply(&cfg, set, "flags"); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// flagValue collects a flag as a string so it can go through the same setters as
+// file and environment values; boolean settings may be given as a bare -flag
+type flagValue struct {
+	value   string
+	boolean bool
+}
+
+func (f *flagValue) String() string { return f.value }
+
+func (f *flagValue) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool { return f.boolean }
+
+// Usage writes the flag and environment variable reference to w
+func Usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: chirpy [flags]")
+	fmt.Fprintln(w, "       chirpy verify-audit [flags]   check the hash chain of the audit log")
+	fmt.Fprintf(w, "  -config\n\tJSON or TOML config file (env %sCONFIG)\n", EnvPrefix)
+	for _, s := range settings {
+		fmt.Fprintf(w, "  -%s\n\t%s (env %s)\n", flagName(s.name), s.usage, envName(s.name))
+	}
+}
+
+func flagName(name string) string { return strings.ReplaceAll(name, "_", "-") }
+
+func envName(name string) string { return EnvPrefix + strings.ToUpper(name) }
+
+// apply sets every value in values on cfg, naming source in errors
+func apply(cfg *Config, values map[string]string, source string) error {
+	for _, s := range settings {
+		value, ok := values[s.name]
+		if !ok {
+			continue
+		}
+		if err := s.set(cfg, value); err != nil {
+			return fmt.Errorf("config: %s: %s: %w", source, s.name, err)
+		}
+		delete(values, s.name)
+	}
+	for name := range values {
+		return fmt.Errorf("config: %s: unknown setting %q", source, name)
+	}
+	return nil
+}
+
+// Validate reports every setting that can't work
+func (cfg Config) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(cfg.ListenAddr); err != nil {
+		errs = append(errs, fmt.Errorf("listen_addr %q: %w", cfg.ListenAddr, err))
+	}
+	timeouts := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"read_timeout", cfg.ReadTimeout},
+		{"write_timeout", cfg.WriteTimeout},
+		{"idle_timeout", cfg.IdleTimeout},
+		{"shutdown_timeout", cfg.ShutdownTimeout},
+		{"shutdown_hook_timeout", cfg.ShutdownHookTimeout},
+		{"hits_save_interval", cfg.HitsSaveInterval},
+		{"stream_heartbeat", cfg.StreamHeartbeat},
+	}
+	for _, timeout := range timeouts {
+		if timeout.d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %s", timeout.name, timeout.d))
+		}
+	}
+	if cfg.MaxChirpLength < 1 {
+		errs = append(errs, fmt.Errorf("max_chirp_length must be positive, got %d", cfg.MaxChirpLength))
+	}
+	if cfg.StreamBuffer < 1 {
+		errs = append(errs, fmt.Errorf("stream_buffer must be positive, got %d", cfg.StreamBuffer))
+	}
+	if cfg.MaxStreams < 1 {
+		errs = append(errs, fmt.Errorf("max_streams must be positive, got %d", cfg.MaxStreams))
+	}
+	if cfg.MaxClientStreams < 1 {
+		errs = append(errs, fmt.Errorf("max_client_streams must be positive, got %d", cfg.MaxClientStreams))
+	}
+	if cfg.StaticRoot != "" {
+		if info, err := os.Stat(cfg.StaticRoot); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("static_root %q is not a directory", cfg.StaticRoot))
+		}
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("log_level %q is not debug, info, warn or error", cfg.LogLevel))
+	}
+	if cfg.LogFormat != "json" && cfg.LogFormat != "text" {
+		errs = append(errs, fmt.Errorf("log_format %q is not json or text", cfg.LogFormat))
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls_cert_file and tls_key_file must be set together"))
+	}
+	if cfg.TLSSelfSigned && cfg.TLSCertFile != "" {
+		errs = append(errs, errors.New("tls_self_signed can't be combined with tls_cert_file"))
+	}
+	if cfg.TLSSelfSigned && cfg.TLSCacheDir == "" {
+		errs = append(errs, errors.New("tls_cache_dir must not be empty with tls_self_signed"))
+	}
+	if cfg.HTTPRedirectAddr != "" {
+		if !cfg.TLSEnabled() {
+			errs = append(errs, errors.New("http_redirect_addr requires TLS"))
+		} else if _, _, err := net.SplitHostPort(cfg.HTTPRedirectAddr); err != nil {
+			errs = append(errs, fmt.Errorf("http_redirect_addr %q: %w", cfg.HTTPRedirectAddr, err))
+		}
+	}
+	if cfg.AuditLogPath == "" {
+		errs = append(errs, errors.New("audit_log_path must not be empty"))
+	}
+	if cfg.DrainPeriod < 0 {
+		errs = append(errs, fmt.Errorf("drain_period must not be negative, got %s", cfg.DrainPeriod))
+	}
+	if cfg.MinFreeDisk < 0 {
+		errs = append(errs, fmt.Errorf("min_free_disk must not be negative, got %d", cfg.MinFreeDisk))
+	}
+	if cfg.AuditLogMaxSize < 1 {
+		errs = append(errs, fmt.Errorf("audit_log_max_size must be positive, got %d", cfg.AuditLogMaxSize))
+	}
+	if cfg.AuditLogMaxFiles < 0 {
+		errs = append(errs, fmt.Errorf("audit_log_max_files must not be negative, got %d", cfg.AuditLogMaxFiles))
+	}
+	if cfg.HSTSMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("hsts_max_age must not be negative, got %s", cfg.HSTSMaxAge))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// TLSEnabled reports whether the server should serve HTTPS
+func (cfg Config) TLSEnabled() bool {
+	return cfg.TLSCertFile != "" || cfg.TLSSelfSigned
+}
+
+// readFile parses a config file into raw string values. Files ending in .json are
+// JSON objects; anything else is read as the TOML subset understood by parseTOML.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		values, err := parseJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("config: %s: %w", path, err)
+		}
+		return values, nil
+	}
+	values, err := parseTOML(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// parseJSON reads a flat JSON object whose values are strings, numbers or booleans
+func parseJSON(data []byte) (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, msg := range raw {
+		var s string
+		if err := json.Unmarshal(msg, &s); err == nil {
+			values[key] = s
+			continue
+		}
+		var v any
+		if err := json.Unmarshal(msg, &v); err != nil {
+			return nil, err
+		}
+		switch v.(type) {
+		case float64, bool:
+			values[key] = string(msg)
+		default:
+			return nil, fmt.Errorf("%s: value must be a string, number or boolean", key)
+		}
+	}
+	return values, nil
+}
+
+// parseTOML reads the subset of TOML Chirpy needs: top-level key = value pairs where
+// the value is a basic "string", a literal 'string', an integer or a boolean, with
+// # comments. Tables, arrays and multi-line strings are rejected.
+func parseTOML(data string) (map[string]string, error) {
+	values := make(map[string]string)
+	for i, line := range strings.Split(data, "\n") {
+		lineNum := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: tables are not supported", lineNum)
+		}
+
+		key, rest, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNum)
+		}
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", lineNum, key)
+		}
+
+		value, err := parseTOMLValue(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", lineNum, key, err)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// parseTOMLValue decodes one value and drops any trailing comment
+func parseTOMLValue(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := closingQuote(s)
+		if end < 0 {
+			return "", errors.New("unterminated string")
+		}
+		if err := onlyComment(s[end+1:]); err != nil {
+			return "", err
+		}
+		return strconv.Unquote(s[:end+1])
+	case strings.HasPrefix(s, "'"):
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated string")
+		}
+		if err := onlyComment(s[end+2:]); err != nil {
+			return "", err
+		}
+		return s[1 : end+1], nil
+	}
+
+	value, _, _ := strings.Cut(s, "#")
+	value = strings.TrimSpace(value)
+	if value == "true" || value == "false" {
+		return value, nil
+	}
+	if _, err := strconv.ParseInt(strings.ReplaceAll(value, "_", ""), 10, 64); err == nil {
+		return strings.ReplaceAll(value, "_", ""), nil
+	}
+	return "", fmt.Errorf("unsupported value %q", value)
+}
+
+// closingQuote returns the index of the quote ending the basic string at the start of s
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+func onlyComment(s string) error {
+	s = strings.TrimSpace(s)
+	if s != "" && !strings.HasPrefix(s, "#") {
+		return fmt.Errorf("unexpected %q after value", s)
+	}
+	return nil
+}
+
+func stringSetting(name, usage string, field func(*Config) *string) setting {
+	return setting{name: name, usage: usage, set: func(cfg *Config, value string) error {
+		*field(cfg) = value
+		return nil
+	}}
+}
+
+// durationSetting accepts Go durations such as "5s"; a bare integer is taken as seconds
+func durationSetting(name, usage string, field func(*Config) *time.Duration) setting {
+	return setting{name: name, usage: usage, set: func(cfg *Config, value string) error {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			*field(cfg) = time.Duration(seconds) * time.Second
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*field(cfg) = d
+		return nil
+	}}
+}
+
+func intSetting(name, usage string, field func(*Config) *int) setting {
+	return setting{name: name, usage: usage, set: func(cfg *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*field(cfg) = n
+		return nil
+	}}
+}
+
+func boolSetting(name, usage string, field func(*Config) *bool) setting {
+	return setting{name: name, usage: usage, boolean: true, set: func(cfg *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*field(cfg) = b
+		return nil
+	}}
+}
+
+// rateLimitSetting parses "requests/period[:burst]"; a bare unit such as "s" or "m" means one of it
+func rateLimitSetting(name, usage string, field func(*Config) *RateLimit) setting {
+	return setting{name: name, usage: usage, set: func(cfg *Config, value string) error {
+		if value == "" {
+			*field(cfg) = RateLimit{}
+			return nil
+		}
+		invalid := fmt.Errorf("invalid rate limit %q, want requests/period[:burst]", value)
+
+		requests, rest, ok := strings.Cut(value, "/")
+		if !ok {
+			return invalid
+		}
+		period, burst, hasBurst := strings.Cut(rest, ":")
+		var limit RateLimit
+		var err error
+		if limit.Requests, err = strconv.Atoi(requests); err != nil || limit.Requests < 1 {
+			return invalid
+		}
+		if period != "" && (period[0] < '0' || period[0] > '9') {
+			period = "1" + period
+		}
+		if limit.Per, err = time.ParseDuration(period); err != nil || limit.Per <= 0 {
+			return invalid
+		}
+		if hasBurst {
+			if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst < 1 {
+				return invalid
+			}
+		}
+		*field(cfg) = limit
+		return nil
+	}}
+}
+
+// cacheControlSetting parses "ext=value" entries separated by semicolons, since
+// Cache-Control values contain commas. ext is an extension such as ".css" or "*" for any other.
+func cacheControlSetting(name, usage string, field func(*Config) *map[string]string) setting {
+	return setting{name: name, usage: usage, set: func(cfg *Config, value string) error {
+		policies := make(map[string]string)
+		for _, entry := range strings.Split(value, ";") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			ext, cc, ok := strings.Cut(entry, "=")
+			ext = strings.ToLower(strings.TrimSpace(ext))
+			if !ok || (ext != "*" && (len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext, " /"))) {
+				return fmt.Errorf("invalid cache policy %q, want .ext=value or *=value", entry)
+			}
+			policies[ext] = strings.TrimSpace(cc)
+		}
+		*field(cfg) = policies
+		return nil
+	}}
+}
+
+// stringListSetting parses a comma-separated list, dropping empty items
+func stringListSetting(name, usage string, field func(*Config) *[]string) setting {
+	return setting{name: name, usage: usage, set: func(cfg *Config, value string) error {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field(cfg) = items
+		return nil
+	}}
+}
+
+// prefixListSetting parses a comma-separated list of IP addresses and CIDR prefixes
+func prefixListSetting(name, usage string, field func(*Config) *[]netip.Prefix) setting {
+	return setting{name: name, usage: usage, set: func(cfg *Config, value string) error {
+		var prefixes []netip.Prefix
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			if addr, err := netip.ParseAddr(item); err == nil {
+				prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+				continue
+			}
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return fmt.Errorf("invalid IP address or CIDR %q", item)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+		}
+		*field(cfg) = prefixes
+		return nil
+	}}
+}
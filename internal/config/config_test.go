@@ -0,0 +1,454 @@
+package config_test
+
+import (
+	"errors"
+	"flag"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShepBook/chirpy/internal/config"
+)
+
+// env returns a getenv func backed by vars
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+// writeFile writes content to name in a fresh temp dir and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+	return path
+}
+
+// Phase 1: Source Precedence Testing
+
+func Test_Load_NoSources_ReturnsDefaults(t *testing.T) {
+	cfg, err := config.Load(nil, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if !reflect.DeepEqual(cfg, config.Default()) {
+		t.Errorf("Expected defaults %+v, got %+v", config.Default(), cfg)
+	}
+}
+
+func Test_Load_FileEnvAndFlags_FlagsWinThenEnvThenFile(t *testing.T) {
+	path := writeFile(t, "chirpy.toml", `
+listen_addr = ":9000"
+max_chirp_length = 200
+chirps_path = "/srv/file.json"
+log_level = "debug"
+`)
+	vars := map[string]string{
+		"CHIRPY_CONFIG":           path,
+		"CHIRPY_MAX_CHIRP_LENGTH": "280",
+		"CHIRPY_CHIRPS_PATH":      "/srv/env.json",
+	}
+
+	cfg, err := config.Load([]string{"-chirps-path", "/srv/flag.json"}, env(vars))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.ListenAddr != ":9000" {
+		t.Errorf("Expected listen_addr from file, got %q", cfg.ListenAddr)
+	}
+	if cfg.LogLevel != "debug" {
+		t.Errorf("Expected log_level from file, got %q", cfg.LogLevel)
+	}
+	if cfg.MaxChirpLength != 280 {
+		t.Errorf("Expected max_chirp_length from env, got %d", cfg.MaxChirpLength)
+	}
+	if cfg.ChirpsPath != "/srv/flag.json" {
+		t.Errorf("Expected chirps_path from flag, got %q", cfg.ChirpsPath)
+	}
+}
+
+func Test_Load_ConfigFlag_OverridesConfigEnv(t *testing.T) {
+	fromEnv := writeFile(t, "env.json", `{"listen_addr": ":1111"}`)
+	fromFlag := writeFile(t, "flag.json", `{"listen_addr": ":2222"}`)
+
+	cfg, err := config.Load([]string{"-config", fromFlag}, env(map[string]string{"CHIRPY_CONFIG": fromEnv}))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.ListenAddr != ":2222" {
+		t.Errorf("Expected file named by -config to be used, got %q", cfg.ListenAddr)
+	}
+}
+
+func Test_Load_BareBoolFlag_SetsTrue(t *testing.T) {
+	cfg, err := config.Load([]string{"-profanity-strict"}, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if !cfg.ProfanityStrict {
+		t.Error("Expected -profanity-strict to enable strict matching")
+	}
+}
+
+func Test_Load_DurationAsSeconds_Accepted(t *testing.T) {
+	cfg, err := config.Load([]string{"-read-timeout", "7", "-shutdown-timeout", "1m30s"}, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.ReadTimeout != 7*time.Second {
+		t.Errorf("Expected read_timeout 7s, got %s", cfg.ReadTimeout)
+	}
+	if cfg.ShutdownTimeout != 90*time.Second {
+		t.Errorf("Expected shutdown_timeout 1m30s, got %s", cfg.ShutdownTimeout)
+	}
+}
+
+func Test_Load_HelpFlag_ReturnsErrHelp(t *testing.T) {
+	_, err := config.Load([]string{"-h"}, env(nil))
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("Expected flag.ErrHelp, got %v", err)
+	}
+}
+
+// Phase 2: Config File Parsing Testing
+
+func Test_Load_JSONFile_ReadsStringsNumbersAndBools(t *testing.T) {
+	path := writeFile(t, "chirpy.json", `{
+		"write_timeout": "15s",
+		"idle_timeout": 60,
+		"profanity_strict": true,
+		"log_format": "text"
+	}`)
+
+	cfg, err := config.Load([]string{"-config", path}, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.WriteTimeout != 15*time.Second || cfg.IdleTimeout != time.Minute {
+		t.Errorf("Expected timeouts 15s/1m, got %s/%s", cfg.WriteTimeout, cfg.IdleTimeout)
+	}
+	if !cfg.ProfanityStrict || cfg.LogFormat != "text" {
+		t.Errorf("Expected strict text logging, got %+v", cfg)
+	}
+}
+
+func Test_Load_TOMLFile_HandlesQuotesAndComments(t *testing.T) {
+	path := writeFile(t, "chirpy.toml", `# Chirpy settings
+profanity_file = 'C:\words.txt'   # literal string keeps the backslash
+chirps_path = "data/\"chirps\".json"
+max_chirp_length = 1_000
+profanity_strict = false
+`)
+
+	cfg, err := config.Load([]string{"-config", path}, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.ProfanityFile != `C:\words.txt` {
+		t.Errorf("Expected literal string, got %q", cfg.ProfanityFile)
+	}
+	if cfg.ChirpsPath != `data/"chirps".json` {
+		t.Errorf("Expected escaped quotes to be decoded, got %q", cfg.ChirpsPath)
+	}
+	if cfg.MaxChirpLength != 1000 {
+		t.Errorf("Expected 1000, got %d", cfg.MaxChirpLength)
+	}
+}
+
+func Test_Load_InvalidFiles_ReturnErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{"unknown key", "c.json", `{"listen_port": 80}`, `unknown setting "listen_port"`},
+		{"nested JSON", "c.json", `{"listen_addr": {"port": 80}}`, "must be a string, number or boolean"},
+		{"TOML table", "c.toml", "[server]\nlisten_addr = \":80\"", "line 1: tables are not supported"},
+		{"TOML duplicate", "c.toml", "log_level = \"info\"\nlog_level = \"warn\"", `line 2: duplicate key "log_level"`},
+		{"TOML unterminated", "c.toml", `chirps_path = "/srv`, "unterminated string"},
+		{"TOML bare word", "c.toml", "log_level = debug", `unsupported value "debug"`},
+		{"bad duration", "c.toml", `read_timeout = "soon"`, `read_timeout: invalid duration "soon"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, tt.file, tt.content)
+			_, err := config.Load([]string{"-config", path}, env(nil))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func Test_Load_MissingFile_ReturnsError(t *testing.T) {
+	_, err := config.Load([]string{"-config", filepath.Join(t.TempDir(), "missing.toml")}, env(nil))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v", err)
+	}
+}
+
+// Phase 3: Validation Testing
+
+func Test_Load_InvalidValues_ReportsEverySetting(t *testing.T) {
+	vars := map[string]string{
+		"CHIRPY_LISTEN_ADDR": "8080",
+		"CHIRPY_LOG_LEVEL":   "loud",
+	}
+	missing := filepath.Join(t.TempDir(), "missing")
+	_, err := config.Load([]string{"-max-chirp-length", "0", "-read-timeout", "-1s", "-static-root", missing}, env(vars))
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+	for _, want := range []string{"listen_addr", "read_timeout must be positive", "max_chirp_length must be positive", `log_level "loud"`, "static_root"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got %v", want, err)
+		}
+	}
+}
+
+func Test_Load_BadEnvValue_NamesSource(t *testing.T) {
+	_, err := config.Load(nil, env(map[string]string{"CHIRPY_PROFANITY_STRICT": "sometimes"}))
+	if err == nil || !strings.Contains(err.Error(), "environment: profanity_strict") {
+		t.Errorf("Expected error naming the environment source, got %v", err)
+	}
+}
+
+func Test_Usage_ListsFlagsAndEnv(t *testing.T) {
+	var b strings.Builder
+	config.Usage(&b)
+	for _, want := range []string{"-listen-addr", "CHIRPY_LISTEN_ADDR", "-max-chirp-length", "CHIRPY_CONFIG"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Expected usage to mention %q", want)
+		}
+	}
+}
+
+func Test_Load_TLSSettings_Validated(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"cert without key", []string{"-tls-cert-file", "cert.pem"}, "tls_cert_file and tls_key_file must be set together"},
+		{"self-signed and files", []string{"-tls-self-signed", "-tls-cert-file", "c.pem", "-tls-key-file", "k.pem"}, "can't be combined"},
+		{"redirect without TLS", []string{"-http-redirect-addr", ":80"}, "http_redirect_addr requires TLS"},
+		{"negative HSTS", []string{"-hsts-max-age", "-1s"}, "hsts_max_age must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := config.Load(tt.args, env(nil))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	cfg, err := config.Load([]string{"-tls-self-signed", "-http-redirect-addr", ":8081", "-hsts-max-age", "24h"}, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if !cfg.TLSEnabled() || cfg.TLSCacheDir != "certs" || cfg.HSTSMaxAge != 24*time.Hour {
+		t.Errorf("Unexpected TLS config %+v", cfg)
+	}
+}
+
+func Test_Load_RateLimitsAndProxies_Parsed(t *testing.T) {
+	path := writeFile(t, "chirpy.toml", `
+rate_limit_auth = "5/m"
+rate_limit_write = "30/10s:60"
+trusted_proxies = "10.0.0.0/8, 192.0.2.7"
+`)
+
+	cfg, err := config.Load([]string{"-config", path}, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if want := (config.RateLimit{Requests: 5, Per: time.Minute}); cfg.RateLimitAuth != want {
+		t.Errorf("rate_limit_auth = %+v, want %+v", cfg.RateLimitAuth, want)
+	}
+	if want := (config.RateLimit{Requests: 30, Per: 10 * time.Second, Burst: 60}); cfg.RateLimitWrite != want {
+		t.Errorf("rate_limit_write = %+v, want %+v", cfg.RateLimitWrite, want)
+	}
+	if cfg.RateLimitRead != (config.RateLimit{}) {
+		t.Errorf("rate_limit_read should default to no limit, got %+v", cfg.RateLimitRead)
+	}
+	want := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.7/32")}
+	if !reflect.DeepEqual(cfg.TrustedProxies, want) {
+		t.Errorf("trusted_proxies = %v, want %v", cfg.TrustedProxies, want)
+	}
+
+	cfg, err = config.Load(nil, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if want := (config.RateLimit{Requests: 10, Per: time.Minute}); cfg.RateLimitAuth != want {
+		t.Errorf("rate_limit_auth defaults to %+v, want %+v", cfg.RateLimitAuth, want)
+	}
+	cfg, err = config.Load([]string{"-rate-limit-auth", ""}, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.RateLimitAuth != (config.RateLimit{}) {
+		t.Errorf("Empty rate_limit_auth = %+v, want no limit", cfg.RateLimitAuth)
+	}
+
+	for _, bad := range []string{"5", "0/m", "5/soon", "5/m:0"} {
+		if _, err := config.Load([]string{"-rate-limit-read", bad}, env(nil)); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+	if _, err := config.Load([]string{"-trusted-proxies", "proxy.internal"}, env(nil)); err == nil {
+		t.Error("Expected host names in trusted_proxies to be rejected")
+	}
+}
+
+func Test_Load_CacheControl_ParsedPerExtension(t *testing.T) {
+	vars := map[string]string{"CHIRPY_CACHE_CONTROL": ".CSS=public, max-age=86400; *=no-store; .map="}
+
+	cfg, err := config.Load(nil, env(vars))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	want := map[string]string{".css": "public, max-age=86400", "*": "no-store", ".map": ""}
+	if !reflect.DeepEqual(cfg.CacheControl, want) {
+		t.Errorf("cache_control = %v, want %v", cfg.CacheControl, want)
+	}
+
+	for _, bad := range []string{"css=no-cache", ".css", "=no-cache", "./x=no-cache"} {
+		if _, err := config.Load([]string{"-cache-control", bad}, env(nil)); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func Test_Load_StaticHandlerSwitches_DefaultOff(t *testing.T) {
+	cfg, err := config.Load(nil, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.StaticListings || cfg.SPAFallback {
+		t.Errorf("Expected listings and SPA fallback off by default, got %+v", cfg)
+	}
+
+	cfg, err = config.Load([]string{"-static-listings", "-spa-fallback"}, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if !cfg.StaticListings || !cfg.SPAFallback {
+		t.Errorf("Expected flags to turn listings and SPA fallback on, got %+v", cfg)
+	}
+}
+
+func Test_Load_AdminSettings_Parsed(t *testing.T) {
+	vars := map[string]string{
+		"CHIRPY_ADMIN_USER_IDS": "6f1c2a9e-0d4b-4c1e-9f7a-2b3c4d5e6f70, , 0b9e8d7c-6a5f-4e3d-8c2b-1a0f9e8d7c6b",
+		"CHIRPY_PLATFORM":       "dev",
+		"CHIRPY_ADMIN_API_KEY":  "s3cret",
+	}
+
+	cfg, err := config.Load(nil, env(vars))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if want := []string{"6f1c2a9e-0d4b-4c1e-9f7a-2b3c4d5e6f70", "0b9e8d7c-6a5f-4e3d-8c2b-1a0f9e8d7c6b"}; !reflect.DeepEqual(cfg.AdminUserIDs, want) {
+		t.Errorf("admin_user_ids = %v, want %v", cfg.AdminUserIDs, want)
+	}
+	if cfg.Platform != "dev" || cfg.AdminAPIKey != "s3cret" || cfg.AuditLogPath != "audit.jsonl" {
+		t.Errorf("Unexpected admin config %+v", cfg)
+	}
+
+	if _, err := config.Load([]string{"-audit-log-path", ""}, env(nil)); err == nil {
+		t.Error("Expected an empty audit_log_path to be rejected")
+	}
+}
+
+func Test_Load_AuditLogMaxSize_MustBePositive(t *testing.T) {
+	cfg, err := config.Load([]string{"-audit-log-max-size", "1048576"}, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.AuditLogMaxSize != 1<<20 {
+		t.Errorf("audit_log_max_size = %d, want %d", cfg.AuditLogMaxSize, 1<<20)
+	}
+
+	if _, err := config.Load([]string{"-audit-log-max-size", "0"}, env(nil)); err == nil {
+		t.Error("Expected a zero audit_log_max_size to be rejected")
+	}
+}
+
+func Test_Load_AuditLogMaxFiles_MustNotBeNegative(t *testing.T) {
+	cfg, err := config.Load([]string{"-audit-log-max-files", "0"}, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.AuditLogMaxFiles != 0 {
+		t.Errorf("audit_log_max_files = %d, want 0", cfg.AuditLogMaxFiles)
+	}
+
+	if _, err := config.Load([]string{"-audit-log-max-files", "-1"}, env(nil)); err == nil {
+		t.Error("Expected a negative audit_log_max_files to be rejected")
+	}
+}
+
+func Test_Load_MinFreeDisk_MustNotBeNegative(t *testing.T) {
+	cfg, err := config.Load([]string{"-min-free-disk", "0"}, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.MinFreeDisk != 0 {
+		t.Errorf("min_free_disk = %d, want 0", cfg.MinFreeDisk)
+	}
+	if config.Default().MinFreeDisk <= 0 {
+		t.Errorf("Default min_free_disk = %d, want a positive default", config.Default().MinFreeDisk)
+	}
+
+	if _, err := config.Load([]string{"-min-free-disk", "-1"}, env(nil)); err == nil {
+		t.Error("Expected a negative min_free_disk to be rejected")
+	}
+}
+
+func Test_Load_ShutdownSettings_Validated(t *testing.T) {
+	cfg, err := config.Load([]string{"-drain-period", "15s", "-shutdown-hook-timeout", "2s"}, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.DrainPeriod != 15*time.Second || cfg.ShutdownHookTimeout != 2*time.Second {
+		t.Errorf("drain_period = %s, shutdown_hook_timeout = %s; want 15s and 2s", cfg.DrainPeriod, cfg.ShutdownHookTimeout)
+	}
+	if def := config.Default(); def.DrainPeriod != 0 || def.ShutdownHookTimeout <= 0 {
+		t.Errorf("Defaults: drain_period = %s, shutdown_hook_timeout = %s", def.DrainPeriod, def.ShutdownHookTimeout)
+	}
+
+	for _, args := range [][]string{{"-drain-period", "-1s"}, {"-shutdown-hook-timeout", "0s"}} {
+		if _, err := config.Load(args, env(nil)); err == nil {
+			t.Errorf("Expected %v to be rejected", args)
+		}
+	}
+}
+
+func Test_Load_StreamSettings_Validated(t *testing.T) {
+	cfg, err := config.Load([]string{"-stream-buffer", "32", "-stream-heartbeat", "30s", "-max-streams", "50", "-max-client-streams", "2"}, env(nil))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.StreamBuffer != 32 || cfg.StreamHeartbeat != 30*time.Second {
+		t.Errorf("stream_buffer = %d, stream_heartbeat = %s; want 32 and 30s", cfg.StreamBuffer, cfg.StreamHeartbeat)
+	}
+	if cfg.MaxStreams != 50 || cfg.MaxClientStreams != 2 {
+		t.Errorf("max_streams = %d, max_client_streams = %d; want 50 and 2", cfg.MaxStreams, cfg.MaxClientStreams)
+	}
+
+	for _, args := range [][]string{{"-stream-buffer", "0"}, {"-stream-heartbeat", "0s"}, {"-max-streams", "0"}, {"-max-client-streams", "-1"}} {
+		if _, err := config.Load(args, env(nil)); err == nil {
+			t.Errorf("Expected %v to be rejected", args)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+// This file exports internal functions for testing
+// It is only compiled during tests
+
+//go:build unix
+
+package handoff
+
+// StartChildForTest exports startChild for testing
+var StartChildForTest = startChild
@@ -0,0 +1,53 @@
+// Package handoff lets a new server process take over the listening sockets of an old
+// one, so restarts and deploys don't refuse connections. Sockets arrive either from
+// systemd socket activation or from a running process that called Reexec; in both
+// cases they are found through the LISTEN_FDS protocol.
+package handoff
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Environment variables of the LISTEN_FDS protocol, plus the pipes a child started by
+// Reexec reports readiness on and waits for its parent's release on
+const (
+	envListenFDs     = "LISTEN_FDS"
+	envListenPID     = "LISTEN_PID"
+	envListenFDNames = "LISTEN_FDNAMES"
+	envReadyFD       = "CHIRPY_READY_FD"
+	envReleaseFD     = "CHIRPY_RELEASE_FD"
+	envNotifySocket  = "NOTIFY_SOCKET"
+)
+
+// listenFDsStart is the first inherited descriptor; 0-2 are stdin, stdout and stderr
+const listenFDsStart = 3
+
+// inheritedFDCount returns how many sockets were passed to this process. LISTEN_PID,
+// when set, must name this process, as systemd sets it; Reexec leaves it unset since
+// the child's PID isn't known before it starts.
+func inheritedFDCount() int {
+	if pid := os.Getenv(envListenPID); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	n, err := strconv.Atoi(os.Getenv(envListenFDs))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// childEnv returns env without the variables of this package
+func childEnv(env []string) []string {
+	kept := make([]string, 0, len(env))
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case envListenFDs, envListenPID, envListenFDNames, envReadyFD, envReleaseFD:
+			continue
+		}
+		kept = append(kept, kv)
+	}
+	return kept
+}
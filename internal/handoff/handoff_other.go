@@ -0,0 +1,43 @@
+//go:build !unix
+
+package handoff
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+)
+
+// NotifyRestart does nothing, since restarts can't hand over sockets on this platform
+func NotifyRestart(c chan<- os.Signal) {}
+
+// Inherited returns no listeners, since sockets can't be inherited on this platform
+func Inherited() ([]net.Listener, error) {
+	return nil, nil
+}
+
+// Child is a process started by Reexec, which this platform never starts
+type Child struct {
+	Process *os.Process
+}
+
+// Reexec is not supported on this platform and returns errors.ErrUnsupported
+func Reexec(ctx context.Context, listeners ...net.Listener) (*Child, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// Release is not supported on this platform and returns errors.ErrUnsupported
+func (c *Child) Release(ctx context.Context) error {
+	return errors.ErrUnsupported
+}
+
+// WaitForRelease does nothing on this platform
+func WaitForRelease() error {
+	return nil
+}
+
+// Ready does nothing on this platform
+func Ready() error {
+	return nil
+}
@@ -0,0 +1,270 @@
+//go:build unix
+
+package handoff_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShepBook/chirpy/internal/handoff"
+	"github.com/ShepBook/chirpy/internal/store"
+)
+
+// childModeEnv makes the test binary act as a child process instead of running tests
+const childModeEnv = "HANDOFF_TEST_CHILD"
+
+// chirpsPathEnv is the chirp file a child in "chirps" mode opens
+const chirpsPathEnv = "HANDOFF_TEST_CHIRPS"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(childModeEnv) {
+	case "serve":
+		os.Exit(serveInherited())
+	case "chirps":
+		os.Exit(serveChirpCount())
+	case "fail":
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// serveInherited answers one request on the first inherited listener, as a child
+// started by Reexec would
+func serveInherited() int {
+	listeners, err := handoff.Inherited()
+	if err != nil || len(listeners) != 1 {
+		return 2
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		return 3
+	}
+	served := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "child")
+		close(served)
+	})}
+	go srv.Serve(listeners[0])
+	if err := handoff.Ready(); err != nil {
+		return 4
+	}
+	select {
+	case <-served:
+		time.Sleep(50 * time.Millisecond)
+		return 0
+	case <-time.After(5 * time.Second):
+		return 5
+	}
+}
+
+// serveChirpCount answers one request on the first inherited listener with the number
+// of chirps in the file at chirpsPathEnv, opened once the parent releases it, as
+// chirpy does after a restart
+func serveChirpCount() int {
+	listeners, err := handoff.Inherited()
+	if err != nil || len(listeners) != 1 {
+		return 2
+	}
+	if err := handoff.WaitForRelease(); err != nil {
+		return 3
+	}
+	chirps, err := store.NewFileChirpStore(os.Getenv(chirpsPathEnv))
+	if err != nil {
+		return 4
+	}
+	served := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list, _ := chirps.List(r.Context())
+		io.WriteString(w, strconv.Itoa(len(list)))
+		close(served)
+	})}
+	go srv.Serve(listeners[0])
+	if err := handoff.Ready(); err != nil {
+		return 5
+	}
+	select {
+	case <-served:
+		time.Sleep(50 * time.Millisecond)
+		return 0
+	case <-time.After(5 * time.Second):
+		return 6
+	}
+}
+
+// startChild runs the test binary in the given child mode with l
+func startChild(t *testing.T, ctx context.Context, mode string, l net.Listener, env ...string) (*handoff.Child, error) {
+	t.Helper()
+	env = append(append(os.Environ(), childModeEnv+"="+mode, "LISTEN_PID=1"), env...)
+	return handoff.StartChildForTest(ctx, os.Args[0], []string{"-test.run=^$"}, env, []net.Listener{l})
+}
+
+// Phase 1: Listener Handoff Testing
+
+func Test_Reexec_ChildServesInheritedListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	child, err := startChild(t, ctx, "serve", l)
+	if err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	// The parent stops listening; the socket stays open in the child
+	addr := l.Addr().String()
+	l.Close()
+
+	resp, err := http.Get("http://" + addr)
+	if err != nil {
+		t.Fatalf("GET error after the handoff: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "child" {
+		t.Errorf("Body = %q, want the child's response", body)
+	}
+	if child.Process.Pid == os.Getpid() {
+		t.Error("Expected the child to be a separate process")
+	}
+}
+
+func Test_Reexec_ParentWritesBeforeRelease_ChildLoadsThem(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chirps.json")
+	chirps, err := store.NewFileChirpStore(path)
+	if err != nil {
+		t.Fatalf("NewFileChirpStore error: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	child, err := startChild(t, ctx, "chirps", l, chirpsPathEnv+"="+path)
+	if err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	// The parent keeps writing until it has finished its requests, then releases
+	for _, body := range []string{"first", "second"} {
+		if _, err := chirps.Create(ctx, store.Chirp{Body: body}); err != nil {
+			t.Fatalf("Create error: %v", err)
+		}
+	}
+	addr := l.Addr().String()
+	l.Close()
+	if err := child.Release(ctx); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+
+	resp, err := http.Get("http://" + addr)
+	if err != nil {
+		t.Fatalf("GET error after the handoff: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "2" {
+		t.Errorf("Child loaded %s chirps, want the 2 written during the handoff", body)
+	}
+}
+
+func Test_Reexec_ChildExitsAfterRelease_ReturnsError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	defer l.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// A directory can't be read as a chirp file, so the child exits once released
+	child, err := startChild(t, ctx, "chirps", l, chirpsPathEnv+"="+t.TempDir())
+	if err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	if err := child.Release(ctx); err == nil || !strings.Contains(err.Error(), "exited before it was ready") {
+		t.Errorf("Release error = %v, want the child's early exit", err)
+	}
+}
+
+func Test_Reexec_ChildExitsBeforeReady_ReturnsError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	defer l.Close()
+
+	_, err = startChild(t, context.Background(), "fail", l)
+	if err == nil || !strings.Contains(err.Error(), "exited before it was ready") {
+		t.Errorf("Start error = %v, want the child's early exit", err)
+	}
+}
+
+func Test_Reexec_ChildNeverReady_KilledAtDeadline(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	defer l.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	// A process that never calls Ready
+	_, err = handoff.StartChildForTest(ctx, "/bin/sh", []string{"-c", "exec sleep 10"}, os.Environ(), []net.Listener{l})
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("Start error = %v, want the deadline", err)
+	}
+}
+
+func Test_Inherited_NotPassed_ReturnsNone(t *testing.T) {
+	t.Setenv("LISTEN_FDS", "")
+	listeners, err := handoff.Inherited()
+	if err != nil || len(listeners) != 0 {
+		t.Errorf("Inherited = %v, %v; want none", listeners, err)
+	}
+}
+
+func Test_Inherited_OtherProcessPID_Ignored(t *testing.T) {
+	// systemd sets LISTEN_PID to the process the sockets are meant for
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_PID", "1")
+	listeners, err := handoff.Inherited()
+	if err != nil || len(listeners) != 0 {
+		t.Errorf("Inherited = %v, %v; want none", listeners, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("Expected LISTEN_FDS to be cleared")
+	}
+}
+
+func Test_Ready_NotifiesSystemd(t *testing.T) {
+	socket := t.TempDir() + "/notify"
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram error: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	if err := handoff.Ready(); err != nil {
+		t.Fatalf("Ready error: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if got := string(buf[:n]); !strings.Contains(got, "READY=1") || !strings.Contains(got, "MAINPID=") {
+		t.Errorf("Notification = %q, want READY=1 and MAINPID", got)
+	}
+}
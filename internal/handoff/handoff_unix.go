@@ -0,0 +1,286 @@
+//go:build unix
+
+package handoff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// RestartSignal asks a running server to Reexec itself and hand over its listeners
+const RestartSignal = syscall.SIGUSR2
+
+// NotifyRestart relays RestartSignal to c
+func NotifyRestart(c chan<- os.Signal) {
+	signal.Notify(c, RestartSignal)
+}
+
+// Inherited returns the listening sockets passed to this process, in the order they
+// were passed, or none if it was started normally. The LISTEN_* variables are
+// cleared so processes started later don't mistake them for their own.
+func Inherited() ([]net.Listener, error) {
+	n := inheritedFDCount()
+	os.Unsetenv(envListenFDs)
+	os.Unsetenv(envListenPID)
+	os.Unsetenv(envListenFDNames)
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "listen-fd-"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("handoff: inherited descriptor %d: %w", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// Messages a child started by Reexec sends its parent
+const (
+	msgWaiting byte = 'w'
+	msgReady   byte = 'r'
+)
+
+// Child is a process started by Reexec
+type Child struct {
+	Process *os.Process
+	cmd     *exec.Cmd
+	// ready is the pipe the child reports on, and release the one it waits on in
+	// WaitForRelease; release is nil once there is nothing left to release
+	ready   *os.File
+	release *os.File
+}
+
+// Reexec starts the running binary again with the same arguments and environment,
+// passing it listeners, and waits until the new process calls WaitForRelease or
+// Ready. If it exits first or ctx ends, it is killed and an error returned; the
+// caller still owns the listeners either way and should keep serving on failure.
+func Reexec(ctx context.Context, listeners ...net.Listener) (*Child, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("handoff: %w", err)
+	}
+	return startChild(ctx, path, os.Args[1:], os.Environ(), listeners)
+}
+
+// startChild is Reexec for any command
+func startChild(ctx context.Context, path string, args, env []string, listeners []net.Listener) (*Child, error) {
+	files := make([]*os.File, 0, len(listeners)+2)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, l := range listeners {
+		filer, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("handoff: can't pass a %T to another process", l)
+		}
+		f, err := filer.File()
+		if err != nil {
+			return nil, fmt.Errorf("handoff: %w", err)
+		}
+		files = append(files, f)
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("handoff: %w", err)
+	}
+	files = append(files, readyW)
+	releaseR, release, err := os.Pipe()
+	if err != nil {
+		ready.Close()
+		return nil, fmt.Errorf("handoff: %w", err)
+	}
+	files = append(files, releaseR)
+
+	cmd := exec.Command(path, args...)
+	cmd.Env = append(childEnv(env),
+		envListenFDs+"="+strconv.Itoa(len(listeners)),
+		envReadyFD+"="+strconv.Itoa(listenFDsStart+len(listeners)),
+		envReleaseFD+"="+strconv.Itoa(listenFDsStart+len(listeners)+1),
+	)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		ready.Close()
+		release.Close()
+		return nil, fmt.Errorf("handoff: start %s: %w", path, err)
+	}
+	// The child holds the only write end of ready now, so reading it ends if the
+	// child exits
+	readyW.Close()
+	releaseR.Close()
+	files = files[:len(files)-2]
+
+	child := &Child{Process: cmd.Process, cmd: cmd, ready: ready, release: release}
+	msg, err := child.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if msg == msgReady {
+		child.done()
+	}
+	return child, nil
+}
+
+// Release tells a child waiting in WaitForRelease that this process has stopped
+// using the files they share, then waits until the child calls Ready. If it exits
+// first or ctx ends, it is killed and an error returned; by then this process has
+// usually stopped serving, so the caller should exit and let it be restarted. It
+// does nothing if the child was ready without waiting.
+func (c *Child) Release(ctx context.Context) error {
+	if c.release == nil {
+		return nil
+	}
+	_, err := c.release.Write([]byte{1})
+	c.release.Close()
+	c.release = nil
+	if err != nil {
+		c.kill()
+		return fmt.Errorf("handoff: new process %d: %w", c.Process.Pid, err)
+	}
+	if _, err := c.read(ctx); err != nil {
+		return err
+	}
+	c.done()
+	return nil
+}
+
+// read returns the child's next message, killing it if it exits first or ctx ends
+func (c *Child) read(ctx context.Context) (byte, error) {
+	type result struct {
+		msg byte
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		var b [1]byte
+		_, err := c.ready.Read(b[:])
+		if err == io.EOF {
+			err = errors.New("exited before it was ready")
+		}
+		results <- result{b[0], err}
+	}()
+
+	var r result
+	select {
+	case r = <-results:
+	case <-ctx.Done():
+		r.err = ctx.Err()
+	}
+	if r.err != nil {
+		c.kill()
+		return 0, fmt.Errorf("handoff: new process %d: %w", c.Process.Pid, r.err)
+	}
+	return r.msg, nil
+}
+
+// kill stops a child that failed to start
+func (c *Child) kill() {
+	c.Process.Kill()
+	c.cmd.Wait()
+	c.ready.Close()
+	if c.release != nil {
+		c.release.Close()
+		c.release = nil
+	}
+}
+
+// done lets go of a child that is ready
+func (c *Child) done() {
+	c.ready.Close()
+	if c.release != nil {
+		c.release.Close()
+		c.release = nil
+	}
+	// The child outlives this process; reap it rather than waiting for it
+	go c.cmd.Wait()
+}
+
+// parentPipes returns the pipes to the process that called Reexec, or nil if there
+// is none. The descriptors are taken once and not passed on to later processes.
+var parentPipes = sync.OnceValues(func() (ready, release *os.File) {
+	readyFD, readyErr := strconv.Atoi(os.Getenv(envReadyFD))
+	releaseFD, releaseErr := strconv.Atoi(os.Getenv(envReleaseFD))
+	os.Unsetenv(envReadyFD)
+	os.Unsetenv(envReleaseFD)
+	if readyErr == nil {
+		syscall.CloseOnExec(readyFD)
+		ready = os.NewFile(uintptr(readyFD), "ready")
+	}
+	if releaseErr == nil {
+		syscall.CloseOnExec(releaseFD)
+		release = os.NewFile(uintptr(releaseFD), "release")
+	}
+	return ready, release
+})
+
+// WaitForRelease, in a process started by Reexec, tells the parent that it has
+// started and blocks until the parent calls Release or exits, so the files they
+// share are never written by both. Call it once, before opening those files; it
+// returns at once in a process started any other way.
+func WaitForRelease() error {
+	ready, release := parentPipes()
+	if ready == nil || release == nil {
+		return nil
+	}
+	defer release.Close()
+	if _, err := ready.Write([]byte{msgWaiting}); err != nil {
+		return fmt.Errorf("handoff: tell parent: %w", err)
+	}
+	var b [1]byte
+	if _, err := release.Read(b[:]); err != nil && err != io.EOF {
+		return fmt.Errorf("handoff: wait for parent: %w", err)
+	}
+	return nil
+}
+
+// Ready tells whoever started this process that it is serving: the parent that
+// called Reexec, and systemd when NOTIFY_SOCKET is set, which also learns that this
+// is now the service's main process. It does nothing when neither applies.
+func Ready() error {
+	var errs []error
+	if ready, _ := parentPipes(); ready != nil {
+		if _, err := ready.Write([]byte{msgReady}); err != nil && !errors.Is(err, os.ErrClosed) {
+			errs = append(errs, fmt.Errorf("handoff: tell parent: %w", err))
+		}
+		ready.Close()
+	}
+	if socket := os.Getenv(envNotifySocket); socket != "" {
+		if err := notifySystemd(socket, "READY=1\nMAINPID="+strconv.Itoa(os.Getpid())); err != nil {
+			errs = append(errs, fmt.Errorf("handoff: notify systemd: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// notifySystemd sends state to the sd_notify socket; a leading "@" names an abstract socket
+func notifySystemd(socket, state string) error {
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
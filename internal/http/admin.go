@@ -0,0 +1,184 @@
+package http
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ShepBook/chirpy/internal/audit"
+	"github.com/ShepBook/chirpy/internal/auth"
+)
+
+// AdminPrefix starts the path of every route that only admins may use, including
+// routes added through Mux()
+const AdminPrefix = "/admin/"
+
+// apiKeyActor is the actor recorded for requests authenticated with the admin API key
+const apiKeyActor = "api-key"
+
+// anonymousActor is the actor recorded for admin requests without valid credentials
+const anonymousActor = "anonymous"
+
+// deniedAuditWindow is how long the denied admin requests of one client after the
+// first are left out of the audit log; the next entry says how many were left out
+const deniedAuditWindow = time.Minute
+
+// WithAdmins gives the users with these IDs the admin role in the access tokens
+// issued when they log in or refresh. IDs are assigned by the store, so unlike an
+// email, nobody can claim one by registering first.
+func WithAdmins(userIDs ...string) Option {
+	return func(server *Server) {
+		server.admins = make(map[string]bool, len(userIDs))
+		for _, id := range userIDs {
+			server.admins[id] = true
+		}
+	}
+}
+
+// WithAdminAPIKey lets requests with "Authorization: ApiKey <key>" use the admin
+// routes, for scripts and scrapers that don't log in
+func WithAdminAPIKey(key string) Option {
+	return func(server *Server) {
+		server.adminAPIKey = key
+	}
+}
+
+// errNotAdmin is returned by authenticateAdmin for a valid token without the admin role
+var errNotAdmin = errors.New("not an admin")
+
+// requireAdmin answers requests under AdminPrefix with 401 or 403 unless they carry
+// an admin access token or the admin API key. Denied requests and allowed requests
+// that can change something are written to the audit log.
+func (server *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, AdminPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := newStatusRecorder(w)
+		actor, err := server.authenticateAdmin(r)
+		switch {
+		case errors.Is(err, errNotAdmin):
+			RespondWithError(rec, r, http.StatusForbidden, CodeForbidden, "Admin role required")
+		case errors.Is(err, auth.ErrNoBearerToken):
+			RespondWithError(rec, r, http.StatusUnauthorized, CodeUnauthorized, "Admin credentials required")
+		case err != nil:
+			RespondWithError(rec, r, http.StatusUnauthorized, CodeInvalidToken, "Invalid or expired admin credentials")
+		default:
+			next.ServeHTTP(rec, r)
+		}
+
+		// Allowed reads, such as metrics scrapes and audit queries, change nothing and
+		// would drown out the entries worth reviewing
+		if err == nil && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			return
+		}
+
+		clientIP := server.ClientIP(r)
+		details := map[string]string{
+			"method":    r.Method,
+			"status":    strconv.Itoa(rec.status),
+			"allowed":   strconv.FormatBool(err == nil),
+			"client_ip": clientIP,
+		}
+		if err != nil {
+			// Anyone can send denied requests, so they can't be allowed to flood the log
+			record, suppressed := server.deniedAudit.allow(clientBlock(clientIP))
+			if !record {
+				return
+			}
+			if suppressed > 0 {
+				details["suppressed"] = strconv.Itoa(suppressed)
+			}
+		}
+		if actor == "" {
+			actor = anonymousActor
+		}
+		server.audit(r, audit.Entry{
+			Actor:   actor,
+			Action:  audit.ActionAdminRequest,
+			Target:  r.URL.Path,
+			Details: details,
+		})
+	})
+}
+
+// authenticateAdmin returns who is making an admin request: the user ID from an admin
+// access token, or apiKeyActor. A non-admin token returns its user ID with errNotAdmin.
+func (server *Server) authenticateAdmin(r *http.Request) (string, error) {
+	if key, err := auth.GetAPIKey(r.Header); err == nil {
+		if server.adminAPIKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(server.adminAPIKey)) != 1 {
+			return "", auth.ErrInvalidToken
+		}
+		return apiKeyActor, nil
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return "", err
+	}
+	claims, err := auth.ValidateJWTClaims(token, server.jwtSecret)
+	if err != nil {
+		return "", err
+	}
+	if claims.Role != auth.RoleAdmin {
+		return claims.UserID, errNotAdmin
+	}
+	return claims.UserID, nil
+}
+
+// deniedAudit collapses the denied admin requests of each client to one audit entry
+// per deniedAuditWindow. Clients not seen for two windows are swept, losing their
+// count of left-out requests, so memory is bounded by recent clients.
+type deniedAudit struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*deniedClient
+	lastSweep time.Time
+}
+
+type deniedClient struct {
+	recorded   time.Time
+	seen       time.Time
+	suppressed int
+}
+
+func newDeniedAudit(now func() time.Time) *deniedAudit {
+	return &deniedAudit{now: now, clients: make(map[string]*deniedClient), lastSweep: now()}
+}
+
+// allow reports whether a denied request from key should be audited and, if so, how
+// many of key's requests were left out since its last entry
+func (d *deniedAudit) allow(key string) (bool, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	if now.Sub(d.lastSweep) >= deniedAuditWindow {
+		for k, c := range d.clients {
+			if now.Sub(c.seen) >= 2*deniedAuditWindow {
+				delete(d.clients, k)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	c, ok := d.clients[key]
+	if ok && now.Sub(c.recorded) < deniedAuditWindow {
+		c.seen = now
+		c.suppressed++
+		return false, 0
+	}
+	suppressed := 0
+	if ok {
+		suppressed = c.suppressed
+	}
+	d.clients[key] = &deniedClient{recorded: now, seen: now}
+	return true, suppressed
+}
@@ -0,0 +1,83 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ShepBook/chirpy/internal/audit"
+)
+
+// Limits on the entries returned by GET /admin/audit
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// WithAuditLog records admin requests, chirp deletions and cleaned chirps to log and
+// serves it at GET /admin/audit. Without it nothing is audited.
+func WithAuditLog(log *audit.Log) Option {
+	return func(server *Server) {
+		server.auditLog = log
+	}
+}
+
+// audit appends e to the audit log with the request's ID. A failed write is logged
+// rather than failing a request whose action has already happened.
+func (server *Server) audit(r *http.Request, e audit.Entry) {
+	if server.auditLog == nil {
+		return
+	}
+	e.RequestID = RequestIDFromContext(r.Context())
+	if _, err := server.auditLog.Record(e); err != nil {
+		server.logger.ErrorContext(r.Context(), "couldn't write audit entry",
+			"request_id", e.RequestID,
+			"action", e.Action,
+			"error", err,
+		)
+	}
+}
+
+// handleAudit returns the audit entries matching the actor, action, since, until and
+// limit query parameters
+func (server *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Limit:  defaultAuditLimit,
+	}
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		value := query.Get(bound.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			RespondWithError(w, r, http.StatusBadRequest, CodeInvalidQuery, bound.name+" must be an RFC 3339 time")
+			return
+		}
+		*bound.t = t
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			RespondWithError(w, r, http.StatusBadRequest, CodeInvalidQuery, "limit must be between 1 and "+strconv.Itoa(maxAuditLimit))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	entries, err := server.auditLog.Query(filter)
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Couldn't read the audit log")
+		return
+	}
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+	respondWithJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,197 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ShepBook/chirpy/internal/auth"
+	"github.com/ShepBook/chirpy/internal/store"
+)
+
+const (
+	// accessTokenTTL keeps access tokens short-lived; clients renew them with a refresh token
+	accessTokenTTL = time.Hour
+	// refreshTokenTTL bounds how long a login session can be kept alive
+	refreshTokenTTL = 60 * 24 * time.Hour
+)
+
+type contextKey int
+
+const (
+	userIDContextKey contextKey = iota
+	requestIDContextKey
+)
+
+// userIDFromContext returns the user ID stored by requireAuth
+func userIDFromContext(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(userIDContextKey).(string)
+	return userID, ok
+}
+
+// requireAuth returns a handler that rejects requests without a valid Bearer
+// access token and otherwise calls next with the token's user ID in the context
+func (server *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			RespondWithError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Missing bearer token")
+			return
+		}
+
+		userID, err := auth.ValidateJWT(token, server.jwtSecret)
+		if err != nil {
+			RespondWithError(w, r, http.StatusUnauthorized, CodeInvalidToken, "Invalid or expired token")
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), userIDContextKey, userID)))
+	}
+}
+
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (req loginRequest) validate() error {
+	return requireFields("email", req.Email, "password", req.Password)
+}
+
+type loginResponse struct {
+	userResponse
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type refreshResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// issueTokens creates a new access token and server-side refresh token for user.
+// Users listed with WithAdmins get the admin role in their access token.
+func (server *Server) issueTokens(ctx context.Context, user store.User) (refreshResponse, error) {
+	userID := user.ID
+	role := ""
+	if server.admins[user.ID] {
+		role = auth.RoleAdmin
+	}
+	accessToken, err := auth.MakeJWTWithRole(userID, role, server.jwtSecret, accessTokenTTL)
+	if err != nil {
+		return refreshResponse{}, err
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return refreshResponse{}, err
+	}
+	_, err = server.refreshTokens.Create(ctx, store.RefreshToken{
+		Token:     refreshToken,
+		UserID:    userID,
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+	})
+	if err != nil {
+		return refreshResponse{}, err
+	}
+
+	return refreshResponse{Token: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// handleLogin checks an email and password and returns the user with a fresh token pair
+func (server *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req loginRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondWithRequestError(w, r, err)
+		return
+	}
+
+	// Unknown emails and wrong passwords get the same answer, and an unknown email is
+	// checked against a dummy hash so the response time doesn't tell them apart either
+	user, err := server.users.GetByEmail(r.Context(), normalizeEmail(req.Email))
+	if errors.Is(err, store.ErrNotFound) {
+		auth.CheckPasswordHash(req.Password, server.dummyHash())
+		RespondWithError(w, r, http.StatusUnauthorized, CodeInvalidCredentials, "Incorrect email or password")
+		return
+	}
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Couldn't get user")
+		return
+	}
+	if err := auth.CheckPasswordHash(req.Password, user.HashedPassword); err != nil {
+		RespondWithError(w, r, http.StatusUnauthorized, CodeInvalidCredentials, "Incorrect email or password")
+		return
+	}
+
+	tokens, err := server.issueTokens(r.Context(), user)
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Couldn't create tokens")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, loginResponse{
+		userResponse: newUserResponse(user),
+		Token:        tokens.Token,
+		RefreshToken: tokens.RefreshToken,
+	})
+}
+
+// handleRefresh exchanges the Bearer refresh token for a new access token.
+// The refresh token is rotated: the presented one is revoked and a new one returned.
+func (server *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		RespondWithError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Missing refresh token")
+		return
+	}
+
+	// Revoking first, and only if the token is still usable, means a token presented
+	// twice at once is exchanged by one request and the other gets 401
+	rt, err := server.refreshTokens.Rotate(r.Context(), token, time.Now())
+	if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrTokenUnusable) {
+		RespondWithError(w, r, http.StatusUnauthorized, CodeInvalidToken, "Invalid or expired refresh token")
+		return
+	}
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Couldn't rotate refresh token")
+		return
+	}
+
+	// The user is looked up again so the new access token carries their current role
+	user, err := server.users.Get(r.Context(), rt.UserID)
+	if errors.Is(err, store.ErrNotFound) {
+		RespondWithError(w, r, http.StatusUnauthorized, CodeInvalidToken, "Invalid or expired refresh token")
+		return
+	}
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Couldn't get user")
+		return
+	}
+
+	tokens, err := server.issueTokens(r.Context(), user)
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Couldn't create tokens")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+// handleRevoke revokes the Bearer refresh token, ending that login session
+func (server *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		RespondWithError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Missing refresh token")
+		return
+	}
+
+	err = server.refreshTokens.Revoke(r.Context(), token)
+	if errors.Is(err, store.ErrNotFound) {
+		RespondWithError(w, r, http.StatusUnauthorized, CodeInvalidToken, "Invalid refresh token")
+		return
+	}
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Couldn't revoke refresh token")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,116 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ShepBook/chirpy/internal/audit"
+	"github.com/ShepBook/chirpy/internal/store"
+)
+
+type createChirpRequest struct {
+	Body string `json:"body"`
+}
+
+func (req createChirpRequest) validate() error {
+	return requireFields("body", req.Body)
+}
+
+// handleCreateChirp validates and cleans a chirp, saves it as written by the
+// authenticated user and returns the stored chirp
+func (server *Server) handleCreateChirp(w http.ResponseWriter, r *http.Request) {
+	userID, _ := userIDFromContext(r.Context())
+
+	policy := server.chirpPolicy()
+
+	var req createChirpRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		policy.observe(outcomeInvalidJSON)
+		respondWithRequestError(w, r, err)
+		return
+	}
+
+	cleaned, err := policy.clean(req.Body)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, CodeChirpTooLong, err.Error())
+		return
+	}
+
+	chirp, err := server.chirps.Create(r.Context(), store.Chirp{Body: cleaned, UserID: userID})
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Couldn't save chirp")
+		return
+	}
+	if cleaned != req.Body {
+		server.audit(r, audit.Entry{
+			Actor:   userID,
+			Action:  audit.ActionChirpCleaned,
+			Target:  chirp.ID,
+			Details: map[string]string{"original": req.Body, "cleaned": cleaned},
+		})
+	}
+	server.stream.publish(chirp)
+	respondWithJSON(w, http.StatusCreated, chirp)
+}
+
+// handleListChirps returns every chirp, oldest first
+func (server *Server) handleListChirps(w http.ResponseWriter, r *http.Request) {
+	chirps, err := server.chirps.List(r.Context())
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Couldn't list chirps")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirps)
+}
+
+// handleGetChirp returns the chirp named by the {id} path wildcard
+func (server *Server) handleGetChirp(w http.ResponseWriter, r *http.Request) {
+	chirp, err := server.chirps.Get(r.Context(), r.PathValue("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		RespondWithError(w, r, http.StatusNotFound, CodeNotFound, "Chirp not found")
+		return
+	}
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Couldn't get chirp")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirp)
+}
+
+// handleDeleteChirp deletes the chirp named by the {id} path wildcard if the
+// authenticated user wrote it
+func (server *Server) handleDeleteChirp(w http.ResponseWriter, r *http.Request) {
+	userID, _ := userIDFromContext(r.Context())
+
+	chirp, err := server.chirps.Get(r.Context(), r.PathValue("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		RespondWithError(w, r, http.StatusNotFound, CodeNotFound, "Chirp not found")
+		return
+	}
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Couldn't get chirp")
+		return
+	}
+	if chirp.UserID != userID {
+		RespondWithError(w, r, http.StatusForbidden, CodeForbidden, "You can only delete your own chirps")
+		return
+	}
+
+	err = server.chirps.Delete(r.Context(), chirp.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		RespondWithError(w, r, http.StatusNotFound, CodeNotFound, "Chirp not found")
+		return
+	}
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Couldn't delete chirp")
+		return
+	}
+	server.stream.forget(chirp.ID)
+	server.audit(r, audit.Entry{
+		Actor:   userID,
+		Action:  audit.ActionChirpDelete,
+		Target:  chirp.ID,
+		Details: map[string]string{"body": chirp.Body},
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
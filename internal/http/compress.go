@@ -0,0 +1,165 @@
+package http
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// minCompressSize is the smallest body worth gzipping; shorter ones grow or barely shrink
+const minCompressSize = 512
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// compressible reports whether responses of contentType are text that gzip shrinks.
+// Event streams are left alone so every event reaches the client as it is written.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// withCompression gzips text responses for clients that accept it. Bodies are held
+// back until minCompressSize bytes, a flush or the end of the handler, so short
+// responses are sent as they are.
+func withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &compressWriter{
+			ResponseWriter: w,
+			acceptsGzip:    r.Method != http.MethodHead && acceptsEncoding(r, "gzip"),
+		}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter buffers the start of a response until it can decide whether to gzip it
+type compressWriter struct {
+	http.ResponseWriter
+	acceptsGzip bool
+	// status is 0 until the handler writes a header or body
+	status  int
+	decided bool
+	buf     []byte
+	gz      *gzip.Writer
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.decided {
+		if cw.gz != nil {
+			return cw.gz.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= minCompressSize {
+		if err := cw.decide(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide writes the header, gzipping the body if it is compressible text, then sends
+// whatever was buffered. flushing means the handler wants the bytes out now, so the
+// body is compressed even when less than minCompressSize has arrived.
+func (cw *compressWriter) decide(flushing bool) error {
+	cw.decided = true
+	header := cw.Header()
+	bodyless := cw.status == http.StatusNoContent || cw.status == http.StatusNotModified
+	if header.Get("Content-Type") == "" && len(cw.buf) > 0 && !bodyless {
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	if compressible(header.Get("Content-Type")) && header.Get("Content-Encoding") == "" {
+		addVary(header, "Accept-Encoding")
+		if cw.acceptsGzip && !bodyless && cw.status != http.StatusPartialContent &&
+			(flushing || len(cw.buf) >= minCompressSize) {
+			header.Del("Content-Length")
+			header.Del("Accept-Ranges")
+			header.Set("Content-Encoding", "gzip")
+			// The compressed bytes differ from the original, so the validator becomes weak
+			if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+				header.Set("ETag", "W/"+etag)
+			}
+			cw.gz = gzipWriters.Get().(*gzip.Writer)
+			cw.gz.Reset(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if cw.gz != nil {
+		_, err := cw.gz.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// close sends anything still buffered and ends the gzip stream
+func (cw *compressWriter) close() {
+	if cw.status == 0 {
+		return
+	}
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.gz != nil {
+		cw.gz.Close()
+		gzipWriters.Put(cw.gz)
+		cw.gz = nil
+	}
+}
+
+// Flush sends the buffered and compressed bytes so far to the client
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.gz != nil {
+		cw.gz.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,119 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxJSONBodyBytes caps request bodies read by decodeJSON
+const maxJSONBodyBytes = 1 << 20
+
+// requestError is a problem with a request body that the client has to fix
+type requestError struct {
+	status int
+	code   string
+	msg    string
+}
+
+func (e *requestError) Error() string { return e.msg }
+
+func badRequest(code, format string, args ...any) *requestError {
+	return &requestError{status: http.StatusBadRequest, code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// validator is implemented by request bodies that check their own fields after decoding
+type validator interface {
+	validate() error
+}
+
+// decodeJSON reads a single JSON value from r's body into dst. Bodies must be at most
+// maxJSONBodyBytes, must not carry fields dst doesn't have or anything after the
+// value, and, if a Content-Type is sent, it must be JSON. When dst implements
+// validator its field checks run too. The returned error is always a *requestError.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+			return &requestError{status: http.StatusUnsupportedMediaType, code: CodeUnsupportedMediaType, msg: "Content-Type must be application/json"}
+		}
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return decodeError(err)
+		}
+		return badRequest(CodeInvalidJSON, "Request body must contain a single JSON value")
+	}
+
+	if v, ok := dst.(validator); ok {
+		if err := v.validate(); err != nil {
+			return badRequest(CodeValidationFailed, "%s", err)
+		}
+	}
+	return nil
+}
+
+// decodeError turns a json.Decoder error into a message that says what to fix
+func decodeError(err error) *requestError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxBytesErr *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return &requestError{
+			status: http.StatusRequestEntityTooLarge,
+			code:   CodeBodyTooLarge,
+			msg:    fmt.Sprintf("Request body must not be larger than %d bytes", maxBytesErr.Limit),
+		}
+	case errors.Is(err, io.EOF):
+		return badRequest(CodeInvalidJSON, "Request body must not be empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return badRequest(CodeInvalidJSON, "Invalid JSON: unexpected end of input")
+	case errors.As(err, &syntaxErr):
+		return badRequest(CodeInvalidJSON, "Invalid JSON at offset %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return badRequest(CodeInvalidJSON, "Invalid JSON: %s must be a %s", typeErr.Field, typeErr.Type)
+	case errors.As(err, &typeErr):
+		return badRequest(CodeInvalidJSON, "Request body must be a JSON object")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for this; the message format is stable
+		return badRequest(CodeUnknownField, "Unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	}
+	return badRequest(CodeInvalidJSON, "Invalid JSON")
+}
+
+// respondWithRequestError writes err from decodeJSON as an error response
+func respondWithRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		RespondWithError(w, r, reqErr.status, reqErr.code, reqErr.msg)
+		return
+	}
+	RespondWithError(w, r, http.StatusBadRequest, CodeInvalidJSON, "Invalid request body")
+}
+
+// requireFields takes alternating field names and values and reports every empty one
+func requireFields(nameValues ...string) error {
+	var missing []string
+	for i := 0; i+1 < len(nameValues); i += 2 {
+		if strings.TrimSpace(nameValues[i+1]) == "" {
+			missing = append(missing, nameValues[i]+" is required")
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(missing, "; "))
+}
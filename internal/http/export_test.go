@@ -3,5 +3,40 @@
 
 package http
 
+import "time"
+
 // CleanProfanityForTest exports cleanProfanity for testing
 var CleanProfanityForTest = cleanProfanity
+
+// RedirectToHTTPSForTest exports redirectToHTTPS for testing
+var RedirectToHTTPSForTest = redirectToHTTPS
+
+// RateLimiterForTest exposes a rateLimiter with an injectable clock for testing
+type RateLimiterForTest struct{ l *rateLimiter }
+
+func NewRateLimiterForTest(limit RateLimit, now func() time.Time) RateLimiterForTest {
+	return RateLimiterForTest{newRateLimiter(limit, now)}
+}
+
+// Allow reports whether a request from key is allowed and the Retry-After it would get
+func (t RateLimiterForTest) Allow(key string) (bool, time.Duration) {
+	d := t.l.allow(key)
+	return d.allowed, d.retryAfter
+}
+
+// Buckets returns the number of tracked clients
+func (t RateLimiterForTest) Buckets() int { return t.l.len() }
+
+// DeniedAuditForTest exposes the collapsing of denied admin request entries with an
+// injectable clock for testing
+type DeniedAuditForTest struct{ d *deniedAudit }
+
+func NewDeniedAuditForTest(now func() time.Time) DeniedAuditForTest {
+	return DeniedAuditForTest{newDeniedAudit(now)}
+}
+
+// Allow reports whether a denied request from key is audited and how many were left out before it
+func (t DeniedAuditForTest) Allow(key string) (bool, int) { return t.d.allow(key) }
+
+// ClientBlockForTest exports clientBlock for testing
+var ClientBlockForTest = clientBlock
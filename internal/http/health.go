@@ -0,0 +1,150 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthCheckTimeout bounds each check run for /api/livez and /api/readyz
+const healthCheckTimeout = 2 * time.Second
+
+// Health statuses reported by /api/livez and /api/readyz
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+// errShuttingDown fails the readiness check once Shutdown has begun
+var errShuttingDown = errors.New("server is shutting down")
+
+// HealthCheck reports a problem with a subsystem by returning an error
+type HealthCheck func(ctx context.Context) error
+
+// HealthChecker holds the checks behind /api/livez and /api/readyz. Liveness checks
+// say whether the process should be restarted; readiness checks say whether it
+// should get traffic, and /api/readyz runs both.
+type HealthChecker struct {
+	mu        sync.RWMutex
+	liveness  []namedCheck
+	readiness []namedCheck
+}
+
+type namedCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// HealthReport is the body of /api/livez and /api/readyz
+type HealthReport struct {
+	Status string              `json:"status"`
+	Checks []HealthCheckResult `json:"checks"`
+}
+
+// HealthCheckResult is the outcome of one check
+type HealthCheckResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// AddLivenessCheck adds a check to /api/livez and /api/readyz
+func (h *HealthChecker) AddLivenessCheck(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness = append(h.liveness, namedCheck{name, check})
+}
+
+// AddReadinessCheck adds a check to /api/readyz
+func (h *HealthChecker) AddReadinessCheck(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness = append(h.readiness, namedCheck{name, check})
+}
+
+// Liveness runs the liveness checks
+func (h *HealthChecker) Liveness(ctx context.Context) HealthReport {
+	h.mu.RLock()
+	checks := append([]namedCheck(nil), h.liveness...)
+	h.mu.RUnlock()
+	return runChecks(ctx, checks)
+}
+
+// Readiness runs the liveness and readiness checks
+func (h *HealthChecker) Readiness(ctx context.Context) HealthReport {
+	h.mu.RLock()
+	checks := append(append([]namedCheck(nil), h.liveness...), h.readiness...)
+	h.mu.RUnlock()
+	return runChecks(ctx, checks)
+}
+
+// runChecks runs checks concurrently, each with healthCheckTimeout, and reports them
+// in order. The report fails if any check does.
+func runChecks(ctx context.Context, checks []namedCheck) HealthReport {
+	report := HealthReport{Status: HealthOK, Checks: make([]HealthCheckResult, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.check(ctx)
+			result := HealthCheckResult{
+				Name:      c.name,
+				Status:    HealthOK,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status, result.Error = HealthFail, err.Error()
+			}
+			report.Checks[i] = result
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != HealthOK {
+			report.Status = HealthFail
+		}
+	}
+	return report
+}
+
+// Health returns the registry behind /api/livez and /api/readyz, for subsystems to
+// add their checks to
+func (server *Server) Health() *HealthChecker {
+	return server.health
+}
+
+// checkNotShuttingDown fails once Shutdown has been called, so load balancers stop
+// sending requests while the in-flight ones finish
+func (server *Server) checkNotShuttingDown(ctx context.Context) error {
+	if server.shuttingDown.Load() {
+		return errShuttingDown
+	}
+	return nil
+}
+
+func (server *Server) handleLivez(w http.ResponseWriter, r *http.Request) {
+	respondWithHealth(w, server.health.Liveness(r.Context()))
+}
+
+func (server *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	respondWithHealth(w, server.health.Readiness(r.Context()))
+}
+
+// respondWithHealth sends report with 200, or 503 when it failed
+func respondWithHealth(w http.ResponseWriter, report HealthReport) {
+	status := http.StatusOK
+	if report.Status != HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, status, report)
+}
@@ -1,101 +1,368 @@
 package http
 
 import (
-	"context"
+	"crypto/rand"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
-	"regexp"
+	"net/netip"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/ShepBook/chirpy/internal/audit"
+	"github.com/ShepBook/chirpy/internal/auth"
+	"github.com/ShepBook/chirpy/internal/metrics"
+	"github.com/ShepBook/chirpy/internal/store"
+	"github.com/ShepBook/chirpy/web"
 )
 
-// cleanProfanity replaces profane words with asterisks using word boundary matching
+// cleanProfanity replaces the default profane words with asterisks using word boundary matching
 func cleanProfanity(text string) string {
-	// Create regex pattern for the three profane words with strict boundaries
-	// (?i) makes it case-insensitive
-	// (^|\s) ensures the word starts after whitespace or at string start
-	// ($|\s) ensures the word ends before whitespace or at string end
-	pattern := `(?i)(^|\s)(kerfuffle|sharbert|fornax)($|\s)`
-	re := regexp.MustCompile(pattern)
-
-	// Use ReplaceAllStringFunc to handle each match properly
-	// This prevents boundary overlap issues with multiple replacements
-	result := text
-	for {
-		match := re.FindStringSubmatchIndex(result)
-		if match == nil {
-			break
-		}
-		// match[4] and match[5] are the start and end of the profane word (group 2)
-		// Replace just the word, preserving boundaries
-		result = result[:match[4]] + "****" + result[match[5]:]
+	return defaultProfanityFilter.Clean(text)
+}
+
+type Server struct {
+	httpSrv        *http.Server
+	mux            *Router
+	chirps         store.ChirpStore
+	users          store.UserStore
+	refreshTokens  store.RefreshTokenStore
+	passwordParams auth.PasswordParams
+	// dummyHash is checked for unknown emails so a login takes as long as for a real account
+	dummyHash      func() string
+	jwtSecret      string
+	profanity      *ProfanityFilter
+	metrics        *serverMetrics
+	logger         *slog.Logger
+	addr           string
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	idleTimeout    time.Duration
+	maxChirpLength int
+
+	certFile        string
+	keyFile         string
+	selfSignedDir   string
+	selfSignedHosts []string
+	redirectAddr    string
+	redirectSrv     *http.Server
+	redirectLn      net.Listener
+	hstsMaxAge      time.Duration
+
+	static       fs.FS
+	cacheControl CacheControl
+	home         http.Handler
+
+	rateLimits     map[string]RateLimit
+	rateLimiters   map[string]*rateLimiter
+	trustedProxies []netip.Prefix
+
+	admins      map[string]bool
+	adminAPIKey string
+	auditLog    *audit.Log
+	deniedAudit *deniedAudit
+
+	stream           *chirpBroker
+	streamBuffer     int
+	streamHeartbeat  time.Duration
+	maxStreams       int
+	maxClientStreams int
+
+	health       *HealthChecker
+	shuttingDown atomic.Bool
+	drainPeriod  time.Duration
+	handingOff   atomic.Bool
+	hooksMu      sync.Mutex
+	hooks        []shutdownHook
+	shutdownOnce sync.Once
+	shutdownErr  error
+}
+
+// Option configures optional dependencies of a Server
+type Option func(*Server)
+
+// WithChirpStore makes the server save chirps to chirps instead of an in-memory store
+func WithChirpStore(chirps store.ChirpStore) Option {
+	return func(server *Server) {
+		server.chirps = chirps
 	}
-	return result
 }
 
-// methodRestriction returns a handler that validates the request method
-// and returns HTTP 405 with Allow header if the method doesn't match
-func methodRestriction(method string, next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != method {
-			w.Header().Set("Allow", method)
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
-		next(w, r)
+// WithUserStore makes the server save users to users instead of an in-memory store
+func WithUserStore(users store.UserStore) Option {
+	return func(server *Server) {
+		server.users = users
 	}
 }
 
-type Server struct {
-	httpSrv *http.Server
-	mux     *http.ServeMux
+// WithPasswordParams overrides the key-derivation cost used when hashing new passwords
+func WithPasswordParams(params auth.PasswordParams) Option {
+	return func(server *Server) {
+		server.passwordParams = params
+	}
+}
+
+// WithRefreshTokenStore makes the server keep refresh tokens in tokens instead of an in-memory store
+func WithRefreshTokenStore(tokens store.RefreshTokenStore) Option {
+	return func(server *Server) {
+		server.refreshTokens = tokens
+	}
+}
+
+// WithJWTSecret sets the HMAC key used to sign and verify access tokens.
+// Without it the server signs with a random key, so tokens don't survive a restart.
+func WithJWTSecret(secret string) Option {
+	return func(server *Server) {
+		server.jwtSecret = secret
+	}
+}
+
+// WithProfanityFilter makes the server clean chirps with filter instead of the default word list
+func WithProfanityFilter(filter *ProfanityFilter) Option {
+	return func(server *Server) {
+		server.profanity = filter
+	}
+}
+
+// WithMetricsRegistry makes the server record its metrics in registry, so callers can
+// add their own metrics to the same /admin/metrics/prometheus output
+func WithMetricsRegistry(registry *metrics.Registry) Option {
+	return func(server *Server) {
+		server.metrics = newServerMetrics(registry)
+	}
+}
+
+// WithLogger makes the server write request logs to logger instead of slog.Default()
+func WithLogger(logger *slog.Logger) Option {
+	return func(server *Server) {
+		server.logger = logger
+	}
+}
+
+// WithStaticFS makes the home page come from index.html in fsys instead of the
+// embedded web.Assets; pass the same files the /app handler serves
+func WithStaticFS(fsys fs.FS) Option {
+	return func(server *Server) {
+		server.static = fsys
+	}
+}
+
+// WithCacheControl sets the Cache-Control policies of the home page; use the same
+// policies as the /app handler's FileServer
+func WithCacheControl(cc CacheControl) Option {
+	return func(server *Server) {
+		server.cacheControl = cc
+	}
+}
+
+// WithAddr sets the TCP address to listen on, ":8080" by default
+func WithAddr(addr string) Option {
+	return func(server *Server) {
+		server.addr = addr
+	}
+}
+
+// WithTimeouts overrides the read, write and idle timeouts of the underlying http.Server
+func WithTimeouts(read, write, idle time.Duration) Option {
+	return func(server *Server) {
+		server.readTimeout = read
+		server.writeTimeout = write
+		server.idleTimeout = idle
+	}
+}
+
+// WithMaxChirpLength sets the longest chirp, in characters, the server accepts
+func WithMaxChirpLength(n int) Option {
+	return func(server *Server) {
+		server.maxChirpLength = n
+	}
 }
 
 // NewWithConfig creates a server with custom handler configuration
-func NewWithConfig(appHandler http.Handler) *Server {
+func NewWithConfig(appHandler http.Handler, opts ...Option) *Server {
 	const port = "8080"
 
-	mux := http.NewServeMux()
+	server := &Server{
+		addr:             ":" + port,
+		readTimeout:      5 * time.Second,
+		writeTimeout:     10 * time.Second,
+		idleTimeout:      120 * time.Second,
+		maxChirpLength:   defaultMaxChirpLength,
+		chirps:           store.NewMemoryChirpStore(),
+		users:            store.NewMemoryUserStore(),
+		refreshTokens:    store.NewMemoryRefreshTokenStore(),
+		passwordParams:   auth.DefaultPasswordParams,
+		jwtSecret:        rand.Text(),
+		profanity:        defaultProfanityFilter,
+		logger:           slog.Default(),
+		static:           web.Assets,
+		deniedAudit:      newDeniedAudit(time.Now),
+		streamBuffer:     DefaultStreamBuffer,
+		streamHeartbeat:  DefaultStreamHeartbeat,
+		maxStreams:       DefaultMaxStreams,
+		maxClientStreams: DefaultMaxClientStreams,
+	}
+	for _, opt := range opts {
+		opt(server)
+	}
+	if server.metrics == nil {
+		server.metrics = newServerMetrics(metrics.NewRegistry())
+	}
+	server.dummyHash = sync.OnceValue(func() string {
+		hash, _ := server.passwordParams.Hash(rand.Text())
+		return hash
+	})
+	server.home = FileServer(server.static, CachePolicies(server.cacheControl))
+
+	server.health = &HealthChecker{}
+	server.health.AddReadinessCheck("shutdown", server.checkNotShuttingDown)
+	for _, s := range []struct {
+		name  string
+		store any
+	}{{"chirps", server.chirps}, {"users", server.users}, {"refresh_tokens", server.refreshTokens}} {
+		if pinger, ok := s.store.(store.Pinger); ok {
+			server.health.AddReadinessCheck(s.name, pinger.Ping)
+		}
+	}
+	server.health.AddReadinessCheck("profanity_list", server.profanity.CheckReload)
+
+	server.stream = newChirpBroker(server.streamBuffer, server.maxStreams, server.maxClientStreams)
+	server.metrics.registry.NewGaugeFunc("chirpy_chirp_streams",
+		"Open GET /api/chirps/stream connections.",
+		func() float64 { return float64(server.stream.streams()) })
+
+	server.rateLimiters = newRateLimiters(server.rateLimits)
+
+	// Method patterns make the mux answer mismatched methods with 405 and an Allow header
+	mux := newRouter()
 
-	mux.HandleFunc("/", handleHome)
-	mux.Handle("/app/", appHandler)
-	mux.HandleFunc("/api/healthz", methodRestriction("GET", handleHealthz))
-	mux.HandleFunc("/api/validate_chirp", methodRestriction("POST", HandleValidateChirp))
+	mux.HandleFunc("GET /{$}", server.handleHome, homeDoc)
+	mux.Handle("/app/", appHandler, appDoc)
+	mux.HandleFunc("GET /api/healthz", handleHealthz, healthzDoc)
+	mux.HandleFunc("GET /api/livez", server.handleLivez, livezDoc)
+	mux.HandleFunc("GET /api/readyz", server.handleReadyz, readyzDoc)
+	mux.HandleFunc("POST /api/validate_chirp", server.rateLimited(RateLimitWrite, server.handleValidateChirp), validateChirpDoc)
+	mux.HandleFunc("POST /api/users", server.rateLimited(RateLimitAuth, server.handleCreateUser), createUserDoc)
+	mux.HandleFunc("POST /api/login", server.rateLimited(RateLimitAuth, server.handleLogin), loginDoc)
+	mux.HandleFunc("POST /api/refresh", server.rateLimited(RateLimitAuth, server.handleRefresh), refreshDoc)
+	mux.HandleFunc("POST /api/revoke", server.rateLimited(RateLimitAuth, server.handleRevoke), revokeDoc)
+	mux.HandleFunc("POST /api/chirps", server.rateLimited(RateLimitWrite, server.requireAuth(server.handleCreateChirp)), createChirpDoc)
+	mux.HandleFunc("GET /api/chirps", server.rateLimited(RateLimitRead, server.handleListChirps), listChirpsDoc)
+	mux.HandleFunc("GET /api/chirps/stream", server.rateLimited(RateLimitRead, server.handleChirpStream), chirpStreamDoc)
+	mux.HandleFunc("GET /api/chirps/{id}", server.rateLimited(RateLimitRead, server.handleGetChirp), getChirpDoc)
+	mux.HandleFunc("DELETE /api/chirps/{id}", server.rateLimited(RateLimitWrite, server.requireAuth(server.handleDeleteChirp)), deleteChirpDoc)
+	mux.Handle("GET /admin/metrics/prometheus", server.metrics.registry.Handler(), prometheusDoc)
+	mux.HandleFunc("GET /api/openapi.json", server.handleOpenAPI, openAPIDoc)
+	mux.HandleFunc("GET /api/docs", handleAPIExplorer, apiExplorerDoc)
+	if server.auditLog != nil {
+		mux.HandleFunc("GET /admin/audit", server.handleAudit, auditDoc)
+	}
+
+	// Every request gets an ID first so the log line and metrics below can refer to it
+	var handler http.Handler = withProblemFallback(mux)
+	handler = server.requireAdmin(handler)
+	handler = server.recoverPanics(handler)
+	handler = withCompression(handler)
+	handler = server.metrics.instrument(handler)
+	handler = logRequests(server.logger, handler)
+	handler = withRequestID(handler)
+	if server.hstsMaxAge > 0 {
+		handler = withHSTS(server.hstsMaxAge, handler)
+	}
 
 	srv := &http.Server{
-		Addr:         ":" + port,
-		Handler:      mux,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		Addr:         server.addr,
+		Handler:      handler,
+		ReadTimeout:  server.readTimeout,
+		WriteTimeout: server.writeTimeout,
+		IdleTimeout:  server.idleTimeout,
+		ErrorLog:     slog.NewLogLogger(server.logger.Handler(), slog.LevelError),
 	}
+	// Streams never finish on their own, so Shutdown ends them rather than waiting
+	srv.RegisterOnShutdown(server.stream.close)
 
-	return &Server{
-		httpSrv: srv,
-		mux:     mux,
+	if server.tlsEnabled() {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if server.redirectAddr != "" {
+			server.redirectSrv = &http.Server{
+				Addr:              server.redirectAddr,
+				Handler:           redirectToHTTPS(server.addr),
+				ReadHeaderTimeout: server.readTimeout,
+				ErrorLog:          srv.ErrorLog,
+			}
+		}
 	}
+
+	server.httpSrv = srv
+	server.mux = mux
+	return server
 }
 
+// New creates a server that serves the embedded static site under /app
 func New() *Server {
-	const filepathRoot = "."
-
-	fileServer := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
+	fileServer := http.StripPrefix("/app", FileServer(web.Assets))
 	return NewWithConfig(fileServer)
 }
 
-func (server *Server) Mux() *http.ServeMux {
+// Mux returns the router behind the server. Routes added to it are served with the
+// server's middleware and, when registered with a RouteDoc, appear in /api/openapi.json.
+func (server *Server) Mux() *Router {
 	return server.mux
 }
 
+// ServeHTTP runs a request through the same middleware and routes as the listening server
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.httpSrv.Handler.ServeHTTP(w, r)
+}
+
+// Metrics returns the registry behind /admin/metrics/prometheus
+func (server *Server) Metrics() *metrics.Registry {
+	return server.metrics.registry
+}
+
+// ListenAndServe serves plain HTTP, or HTTPS when WithTLS or WithSelfSignedTLS is set
 func (server *Server) ListenAndServe() error {
-	return server.httpSrv.ListenAndServe()
+	defaultAddr := ":http"
+	if server.tlsEnabled() {
+		defaultAddr = ":https"
+	}
+	ln, err := server.listen(defaultAddr)
+	if err != nil {
+		return err
+	}
+	return server.Serve(ln)
 }
 
-func (server *Server) Shutdown(ctx context.Context) error {
-	return server.httpSrv.Shutdown(ctx)
+// Serve is ListenAndServe on an existing listener, such as a socket inherited from
+// systemd or from the process being replaced. It closes ln when it returns.
+func (server *Server) Serve(ln net.Listener) error {
+	if server.tlsEnabled() {
+		return server.serveConfiguredTLS(ln)
+	}
+	return server.httpSrv.Serve(ln)
 }
 
-func handleHome(writer http.ResponseWriter, req *http.Request) {
-	http.ServeFile(writer, req, "index.html")
+// listen binds the configured address, or defaultAddr when none is set. After
+// Shutdown it returns http.ErrServerClosed without binding, like http.Server does.
+func (server *Server) listen(defaultAddr string) (net.Listener, error) {
+	if server.shuttingDown.Load() {
+		return nil, http.ErrServerClosed
+	}
+	addr := server.addr
+	if addr == "" {
+		addr = defaultAddr
+	}
+	return net.Listen("tcp", addr)
+}
+
+// handleHome serves index.html from the static files; the route only matches "/"
+func (server *Server) handleHome(writer http.ResponseWriter, req *http.Request) {
+	server.home.ServeHTTP(writer, req)
 }
 
 func handleHealthz(writer http.ResponseWriter, req *http.Request) {
@@ -110,36 +377,102 @@ type validateChirpRequest struct {
 	Body string `json:"body"`
 }
 
+func (req validateChirpRequest) validate() error {
+	return requireFields("body", req.Body)
+}
+
 type validateChirpResponse struct {
 	CleanedBody string `json:"cleaned_body"`
 }
 
+// errorResponse is the error body sent to clients that ask for legacy errors
 type errorResponse struct {
 	Error string `json:"error"`
 }
 
+// respondWithJSON writes payload as a JSON response with the given status code
+func respondWithJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+// errChirpTooLong is returned by chirpPolicy.clean when a chirp exceeds the length limit
+var errChirpTooLong = errors.New("Chirp is too long")
+
+// defaultMaxChirpLength is the longest chirp accepted unless configured otherwise
+const defaultMaxChirpLength = 140
+
+// chirpPolicy is the chirp validation pipeline shared by /api/validate_chirp and POST /api/chirps
+type chirpPolicy struct {
+	maxLength int
+	filter    *ProfanityFilter
+	// outcomes counts validation results; nil when nothing is recorded
+	outcomes *metrics.CounterVec
+}
+
+// defaultChirpPolicy validates chirps when no server is involved
+var defaultChirpPolicy = chirpPolicy{maxLength: defaultMaxChirpLength, filter: defaultProfanityFilter}
+
+// chirpPolicy returns the validation pipeline configured for this server
+func (server *Server) chirpPolicy() chirpPolicy {
+	return chirpPolicy{
+		maxLength: server.maxChirpLength,
+		filter:    server.profanity,
+		outcomes:  server.metrics.chirpValidations,
+	}
+}
+
+// clean runs body through the validation pipeline and returns the text to keep
+func (p chirpPolicy) clean(body string) (string, error) {
+	// Validate chirp length using runes for proper Unicode support
+	if len([]rune(body)) > p.maxLength {
+		p.observe(outcomeTooLong)
+		return "", errChirpTooLong
+	}
+
+	// Valid chirp - apply profanity filter
+	cleaned := p.filter.Clean(body)
+	if cleaned != body {
+		p.observe(outcomeCleaned)
+	} else {
+		p.observe(outcomeAccepted)
+	}
+	return cleaned, nil
+}
+
+// observe records a validation outcome
+func (p chirpPolicy) observe(outcome string) {
+	if p.outcomes != nil {
+		p.outcomes.WithLabelValues(outcome).Inc()
+	}
+}
+
 // HandleValidateChirp validates that a chirp is within the allowed character limit
+// without saving it, cleaning it with the default profanity list; POST /api/chirps
+// runs the same checks before creating a chirp
 func HandleValidateChirp(w http.ResponseWriter, r *http.Request) {
+	validateChirp(w, r, defaultChirpPolicy)
+}
+
+// handleValidateChirp is HandleValidateChirp using the server's chirp policy
+func (server *Server) handleValidateChirp(w http.ResponseWriter, r *http.Request) {
+	validateChirp(w, r, server.chirpPolicy())
+}
+
+func validateChirp(w http.ResponseWriter, r *http.Request, policy chirpPolicy) {
 	var req validateChirpRequest
 
-	// Decode the JSON request
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(errorResponse{Error: "Invalid JSON"})
+	if err := decodeJSON(w, r, &req); err != nil {
+		policy.observe(outcomeInvalidJSON)
+		respondWithRequestError(w, r, err)
 		return
 	}
 
-	// Validate chirp length using runes for proper Unicode support
-	if len([]rune(req.Body)) > 140 {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(errorResponse{Error: "Chirp is too long"})
+	cleaned, err := policy.clean(req.Body)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, CodeChirpTooLong, err.Error())
 		return
 	}
-
-	// Valid chirp - apply profanity filter
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(validateChirpResponse{CleanedBody: cleanProfanity(req.Body)})
+	respondWithJSON(w, http.StatusOK, validateChirpResponse{CleanedBody: cleaned})
 }
@@ -1,15 +1,35 @@
 package http_test
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
+	"github.com/ShepBook/chirpy/internal/audit"
+	"github.com/ShepBook/chirpy/internal/auth"
 	httpserver "github.com/ShepBook/chirpy/internal/http"
+	"github.com/ShepBook/chirpy/internal/metrics"
+	"github.com/ShepBook/chirpy/internal/store"
 )
 
 // Helper to access unexported cleanProfanity function for testing
@@ -48,10 +68,10 @@ func Test_New_ReturnsServerWithCorrectConfiguration(t *testing.T) {
 	}
 	resp.Body.Close()
 
-	// Verify we get a 404 (expected since no routes are configured)
+	// Verify we get the embedded home page
 	// This confirms the ServeMux is properly initialized
-	if resp.StatusCode != http.StatusNotFound {
-		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, resp.StatusCode)
 	}
 
 	// Clean up: shutdown the server
@@ -88,9 +108,9 @@ func Test_ListenAndServe_StartsServer(t *testing.T) {
 	}
 	defer resp.Body.Close()
 
-	// Verify we get a response (404 is expected with no routes)
-	if resp.StatusCode != http.StatusNotFound {
-		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, resp.StatusCode)
+	// Verify we get a response (the embedded home page)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, resp.StatusCode)
 	}
 
 	// Shutdown server
@@ -302,10 +322,10 @@ func Test_handleHealthz_PostRequest_Returns405(t *testing.T) {
 		t.Errorf("Expected status code %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
 	}
 
-	// Verify Allow header is set to GET
+	// Verify Allow header lists GET (the mux adds HEAD for GET patterns)
 	allowHeader := resp.Header.Get("Allow")
-	if allowHeader != "GET" {
-		t.Errorf("Expected Allow header to be 'GET', got '%s'", allowHeader)
+	if allowHeader != "GET, HEAD" {
+		t.Errorf("Expected Allow header to be 'GET, HEAD', got '%s'", allowHeader)
 	}
 
 	// Cleanup
@@ -342,10 +362,10 @@ func Test_handleHealthz_DeleteRequest_Returns405(t *testing.T) {
 		t.Errorf("Expected status code %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
 	}
 
-	// Verify Allow header is set to GET
+	// Verify Allow header lists GET (the mux adds HEAD for GET patterns)
 	allowHeader := resp.Header.Get("Allow")
-	if allowHeader != "GET" {
-		t.Errorf("Expected Allow header to be 'GET', got '%s'", allowHeader)
+	if allowHeader != "GET, HEAD" {
+		t.Errorf("Expected Allow header to be 'GET, HEAD', got '%s'", allowHeader)
 	}
 
 	// Cleanup
@@ -420,6 +440,7 @@ func Test_handleValidateChirp_TooLong_Returns400(t *testing.T) {
 	chirp := strings.Repeat("a", 141)
 	reqBody := `{"body":"` + chirp + `"}`
 	req := httptest.NewRequest(http.MethodPost, "/api/validate_chirp", strings.NewReader(reqBody))
+	req.Header.Set("Accept", "application/json") // legacy error shape
 	rec := httptest.NewRecorder()
 
 	httpserver.HandleValidateChirp(rec, req)
@@ -451,6 +472,7 @@ func Test_handleValidateChirp_TooLong_Returns400(t *testing.T) {
 func Test_handleValidateChirp_MalformedJSON_Returns400(t *testing.T) {
 	reqBody := `{invalid json`
 	req := httptest.NewRequest(http.MethodPost, "/api/validate_chirp", strings.NewReader(reqBody))
+	req.Header.Set("Accept", "application/json") // legacy error shape
 	rec := httptest.NewRecorder()
 
 	httpserver.HandleValidateChirp(rec, req)
@@ -479,6 +501,7 @@ func Test_handleValidateChirp_MalformedJSON_Returns400(t *testing.T) {
 func Test_handleValidateChirp_EmptyBody_Returns400(t *testing.T) {
 	reqBody := ``
 	req := httptest.NewRequest(http.MethodPost, "/api/validate_chirp", strings.NewReader(reqBody))
+	req.Header.Set("Accept", "application/json") // legacy error shape
 	rec := httptest.NewRecorder()
 
 	httpserver.HandleValidateChirp(rec, req)
@@ -504,15 +527,16 @@ func Test_handleValidateChirp_EmptyBody_Returns400(t *testing.T) {
 	}
 }
 
-func Test_handleValidateChirp_MissingBodyField_ReturnsValid(t *testing.T) {
+func Test_handleValidateChirp_MissingBodyField_Returns400(t *testing.T) {
 	reqBody := `{}`
 	req := httptest.NewRequest(http.MethodPost, "/api/validate_chirp", strings.NewReader(reqBody))
+	req.Header.Set("Accept", "application/json") // legacy error shape
 	rec := httptest.NewRecorder()
 
 	httpserver.HandleValidateChirp(rec, req)
 
-	if rec.Code != http.StatusOK {
-		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusOK)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusBadRequest)
 	}
 
 	contentType := rec.Header().Get("Content-Type")
@@ -521,14 +545,14 @@ func Test_handleValidateChirp_MissingBodyField_ReturnsValid(t *testing.T) {
 	}
 
 	var response struct {
-		CleanedBody string `json:"cleaned_body"`
+		Error string `json:"error"`
 	}
 	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
-		t.Fatalf("Failed to unmarshal response: %v", err)
+		t.Fatalf("Failed to unmarshal error response: %v", err)
 	}
 
-	if response.CleanedBody != "" {
-		t.Errorf("CleanedBody = %q, want empty string", response.CleanedBody)
+	if response.Error != "body is required" {
+		t.Errorf("Error = %q, want %q", response.Error, "body is required")
 	}
 }
 
@@ -800,6 +824,7 @@ func Test_handleValidateChirp_ProfanityTooLong_Returns400(t *testing.T) {
 	chirp := strings.Repeat("a", 132) + " kerfuffle" // 132 + 1 + 9 = 142 chars (too long)
 	reqBody := `{"body":"` + chirp + `"}`
 	req := httptest.NewRequest(http.MethodPost, "/api/validate_chirp", strings.NewReader(reqBody))
+	req.Header.Set("Accept", "application/json") // legacy error shape
 	rec := httptest.NewRecorder()
 
 	httpserver.HandleValidateChirp(rec, req)
@@ -883,3 +908,3167 @@ func Test_handleValidateChirp_ProfaneWordWithPunctuation_NotCleaned(t *testing.T
 		t.Errorf("CleanedBody = %q, want %q", response.CleanedBody, expectedBody)
 	}
 }
+
+// Phase 4: Chirp CRUD API Tests
+
+// testJWTSecret signs access tokens for servers built in tests
+const testJWTSecret = "test-secret"
+
+// newChirpServer returns a server backed by a fresh in-memory chirp store
+func newChirpServer(t *testing.T) (*httpserver.Server, *store.MemoryChirpStore) {
+	t.Helper()
+	chirps := store.NewMemoryChirpStore()
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithChirpStore(chirps),
+		httpserver.WithJWTSecret(testJWTSecret),
+		httpserver.WithAdminAPIKey(testAdminKey),
+	)
+	return server, chirps
+}
+
+// authorize adds an access token for userID to req
+func authorize(t *testing.T, req *http.Request, userID string) *http.Request {
+	t.Helper()
+	token, err := auth.MakeJWT(userID, testJWTSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT error: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+type chirpJSON struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Body      string    `json:"body"`
+	UserID    string    `json:"user_id"`
+}
+
+func Test_handleCreateChirp_ValidChirp_Returns201AndSavesCleanedBody(t *testing.T) {
+	server, chirps := newChirpServer(t)
+
+	reqBody := `{"body":"What a kerfuffle"}`
+	req := authorize(t, httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(reqBody)), "user-1")
+	rec := httptest.NewRecorder()
+
+	server.Mux().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Status code = %d, want %d", rec.Code, http.StatusCreated)
+	}
+
+	var response chirpJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response.ID == "" || response.CreatedAt.IsZero() || response.UpdatedAt.IsZero() {
+		t.Errorf("Expected ID and timestamps in response, got %+v", response)
+	}
+	if response.Body != "What a ****" {
+		t.Errorf("Body = %q, want %q", response.Body, "What a ****")
+	}
+	if response.UserID != "user-1" {
+		t.Errorf("UserID = %q, want %q", response.UserID, "user-1")
+	}
+
+	saved, err := chirps.Get(context.Background(), response.ID)
+	if err != nil {
+		t.Fatalf("Expected chirp to be saved, got error: %v", err)
+	}
+	if saved.Body != "What a ****" {
+		t.Errorf("Saved body = %q, want %q", saved.Body, "What a ****")
+	}
+}
+
+func Test_handleCreateChirp_TooLong_Returns400AndSavesNothing(t *testing.T) {
+	server, chirps := newChirpServer(t)
+
+	reqBody := `{"body":"` + strings.Repeat("a", 141) + `"}`
+	req := authorize(t, httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(reqBody)), "user-1")
+	rec := httptest.NewRecorder()
+
+	server.Mux().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	saved, err := chirps.List(context.Background())
+	if err != nil {
+		t.Fatalf("List error: %v", err)
+	}
+	if len(saved) != 0 {
+		t.Errorf("Saved %d chirps, want 0", len(saved))
+	}
+}
+
+func Test_handleListChirps_ReturnsAllChirps(t *testing.T) {
+	server, chirps := newChirpServer(t)
+
+	// An empty store lists as an empty array rather than null
+	rec := httptest.NewRecorder()
+	server.Mux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps", nil))
+	if strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("Empty list body = %q, want %q", rec.Body.String(), "[]")
+	}
+
+	for _, body := range []string{"first", "second"} {
+		if _, err := chirps.Create(context.Background(), store.Chirp{Body: body}); err != nil {
+			t.Fatalf("Create error: %v", err)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	server.Mux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var response []chirpJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(response) != 2 {
+		t.Errorf("Listed %d chirps, want 2", len(response))
+	}
+}
+
+func Test_handleGetChirp_ExistingChirp_Returns200(t *testing.T) {
+	server, chirps := newChirpServer(t)
+	created, err := chirps.Create(context.Background(), store.Chirp{Body: "hello", UserID: "user-1"})
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.Mux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps/"+created.ID, nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var response chirpJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response.ID != created.ID || response.Body != "hello" {
+		t.Errorf("Response = %+v, want chirp %q with body %q", response, created.ID, "hello")
+	}
+}
+
+func Test_handleGetChirp_UnknownID_Returns404(t *testing.T) {
+	server, _ := newChirpServer(t)
+
+	rec := httptest.NewRecorder()
+	server.Mux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/chirps/missing", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func Test_handleDeleteChirp_ExistingChirp_Returns204(t *testing.T) {
+	server, chirps := newChirpServer(t)
+	created, err := chirps.Create(context.Background(), store.Chirp{Body: "bye", UserID: "user-1"})
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.Mux().ServeHTTP(rec, authorize(t, httptest.NewRequest(http.MethodDelete, "/api/chirps/"+created.ID, nil), "user-1"))
+
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if _, err := chirps.Get(context.Background(), created.ID); err == nil {
+		t.Error("Expected chirp to be deleted")
+	}
+
+	// Deleting again reports the chirp as missing
+	rec = httptest.NewRecorder()
+	server.Mux().ServeHTTP(rec, authorize(t, httptest.NewRequest(http.MethodDelete, "/api/chirps/"+created.ID, nil), "user-1"))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Second delete status code = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func Test_chirpsRoutes_WrongMethod_Returns405(t *testing.T) {
+	server, _ := newChirpServer(t)
+
+	rec := httptest.NewRecorder()
+	server.Mux().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/chirps/some-id", nil))
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if allow := rec.Header().Get("Allow"); allow != "DELETE, GET, HEAD" {
+		t.Errorf("Allow header = %q, want %q", allow, "DELETE, GET, HEAD")
+	}
+}
+
+// Phase 5: User Registration Tests
+
+// fastPasswordParams keeps password hashing cheap in handler tests
+var fastPasswordParams = auth.PasswordParams{Iterations: 1000, SaltLength: 16, KeyLength: 32}
+
+func newUserServer(t *testing.T) (*httpserver.Server, *store.MemoryUserStore) {
+	t.Helper()
+	users := store.NewMemoryUserStore()
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithUserStore(users),
+		httpserver.WithPasswordParams(fastPasswordParams),
+	)
+	return server, users
+}
+
+func Test_handleCreateUser_ValidRequest_Returns201WithoutPassword(t *testing.T) {
+	server, users := newUserServer(t)
+
+	reqBody := `{"email":"Walt@Example.com","password":"04234"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(reqBody))
+	rec := httptest.NewRecorder()
+
+	server.Mux().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Status code = %d, want %d", rec.Code, http.StatusCreated)
+	}
+
+	var response map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	for _, key := range []string{"id", "created_at", "updated_at", "email"} {
+		if _, ok := response[key]; !ok {
+			t.Errorf("Expected %q in response %v", key, response)
+		}
+	}
+	if response["email"] != "walt@example.com" {
+		t.Errorf("email = %v, want %q", response["email"], "walt@example.com")
+	}
+
+	saved, err := users.GetByEmail(context.Background(), "walt@example.com")
+	if err != nil {
+		t.Fatalf("Expected user to be saved, got error: %v", err)
+	}
+	if strings.Contains(rec.Body.String(), saved.HashedPassword) || strings.Contains(rec.Body.String(), "password") {
+		t.Errorf("Response leaks password data: %s", rec.Body.String())
+	}
+	if err := auth.CheckPasswordHash("04234", saved.HashedPassword); err != nil {
+		t.Errorf("Saved hash does not match password: %v", err)
+	}
+}
+
+func Test_handleCreateUser_DuplicateEmail_Returns409(t *testing.T) {
+	server, _ := newUserServer(t)
+
+	for i, want := range []int{http.StatusCreated, http.StatusConflict} {
+		reqBody := `{"email":"walt@example.com","password":"04234"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(reqBody))
+		rec := httptest.NewRecorder()
+
+		server.Mux().ServeHTTP(rec, req)
+
+		if rec.Code != want {
+			t.Errorf("Request %d status code = %d, want %d", i+1, rec.Code, want)
+		}
+	}
+}
+
+func Test_handleCreateUser_InvalidInput_Returns400(t *testing.T) {
+	tests := []struct {
+		name    string
+		reqBody string
+	}{
+		{"malformed JSON", `{"email":`},
+		{"missing password", `{"email":"walt@example.com"}`},
+		{"missing email", `{"password":"04234"}`},
+		{"invalid email", `{"email":"not-an-email","password":"04234"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newUserServer(t)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(tt.reqBody))
+			rec := httptest.NewRecorder()
+
+			server.Mux().ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("Status code = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+// Phase 6: Authentication Tests
+
+func Test_handleCreateChirp_WithoutToken_Returns401(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+	}{
+		{"no header", ""},
+		{"wrong scheme", "Basic dXNlcjpwYXNz"},
+		{"bad token", "Bearer not-a-jwt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, chirps := newChirpServer(t)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(`{"body":"hello"}`))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+
+			server.Mux().ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("Status code = %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+			if saved, _ := chirps.List(context.Background()); len(saved) != 0 {
+				t.Errorf("Saved %d chirps, want 0", len(saved))
+			}
+		})
+	}
+}
+
+func Test_handleDeleteChirp_OtherAuthor_Returns403(t *testing.T) {
+	server, chirps := newChirpServer(t)
+	created, err := chirps.Create(context.Background(), store.Chirp{Body: "mine", UserID: "user-1"})
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.Mux().ServeHTTP(rec, authorize(t, httptest.NewRequest(http.MethodDelete, "/api/chirps/"+created.ID, nil), "user-2"))
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if _, err := chirps.Get(context.Background(), created.ID); err != nil {
+		t.Errorf("Expected chirp to survive, got error: %v", err)
+	}
+}
+
+// loginTokens is the token pair returned by /api/login and /api/refresh
+type loginTokens struct {
+	ID           string `json:"id"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// postWithBearer sends a POST with an optional bearer token and JSON body to server
+func postWithBearer(server *httpserver.Server, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	server.Mux().ServeHTTP(rec, req)
+	return rec
+}
+
+// registerAndLogin creates a user on server and logs them in
+func registerAndLogin(t *testing.T, server *httpserver.Server) loginTokens {
+	t.Helper()
+	credentials := `{"email":"walt@example.com","password":"04234"}`
+	if rec := postWithBearer(server, "/api/users", "", credentials); rec.Code != http.StatusCreated {
+		t.Fatalf("Register status code = %d, want %d", rec.Code, http.StatusCreated)
+	}
+
+	rec := postWithBearer(server, "/api/login", "", credentials)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Login status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if strings.Contains(rec.Body.String(), "password") {
+		t.Errorf("Login response leaks password data: %s", rec.Body.String())
+	}
+
+	var tokens loginTokens
+	if err := json.Unmarshal(rec.Body.Bytes(), &tokens); err != nil {
+		t.Fatalf("Failed to unmarshal login response: %v", err)
+	}
+	if tokens.ID == "" || tokens.Token == "" || tokens.RefreshToken == "" {
+		t.Fatalf("Login response missing fields: %s", rec.Body.String())
+	}
+	return tokens
+}
+
+func Test_handleLogin_ValidCredentials_TokenAuthorizesChirps(t *testing.T) {
+	server, _ := newUserServer(t)
+	tokens := registerAndLogin(t, server)
+
+	rec := postWithBearer(server, "/api/chirps", tokens.Token, `{"body":"hello"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Create chirp status code = %d, want %d", rec.Code, http.StatusCreated)
+	}
+
+	var chirp chirpJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &chirp); err != nil {
+		t.Fatalf("Failed to unmarshal chirp: %v", err)
+	}
+	if chirp.UserID != tokens.ID {
+		t.Errorf("Chirp UserID = %q, want %q", chirp.UserID, tokens.ID)
+	}
+}
+
+func Test_handleLogin_WrongCredentials_Returns401(t *testing.T) {
+	server, _ := newUserServer(t)
+	registerAndLogin(t, server)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"wrong password", `{"email":"walt@example.com","password":"nope"}`},
+		{"unknown email", `{"email":"jesse@example.com","password":"04234"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postWithBearer(server, "/api/login", "", tt.body)
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("Status code = %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func Test_handleLogin_UnknownEmail_TakesAsLongAsWrongPassword(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithPasswordParams(auth.PasswordParams{Iterations: 200_000, SaltLength: 16, KeyLength: 32}),
+	)
+	registerAndLogin(t, server)
+
+	// fastest returns the quickest of a few logins so a slow scheduler can't fake a match
+	fastest := func(body string) time.Duration {
+		best := time.Duration(math.MaxInt64)
+		for range 3 {
+			start := time.Now()
+			if rec := postWithBearer(server, "/api/login", "", body); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("Status code = %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+			best = min(best, time.Since(start))
+		}
+		return best
+	}
+	wrongPassword := fastest(`{"email":"walt@example.com","password":"nope"}`)
+	unknownEmail := fastest(`{"email":"jesse@example.com","password":"04234"}`)
+
+	if unknownEmail < wrongPassword/2 {
+		t.Errorf("Unknown email took %v, wrong password %v; want about the same", unknownEmail, wrongPassword)
+	}
+}
+
+func Test_handleRefresh_RotatesRefreshToken(t *testing.T) {
+	server, _ := newUserServer(t)
+	tokens := registerAndLogin(t, server)
+
+	rec := postWithBearer(server, "/api/refresh", tokens.RefreshToken, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Refresh status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var refreshed loginTokens
+	if err := json.Unmarshal(rec.Body.Bytes(), &refreshed); err != nil {
+		t.Fatalf("Failed to unmarshal refresh response: %v", err)
+	}
+	if refreshed.Token == "" || refreshed.RefreshToken == "" || refreshed.RefreshToken == tokens.RefreshToken {
+		t.Errorf("Expected a new token pair, got %s", rec.Body.String())
+	}
+
+	// The old refresh token was rotated out and can't be used again
+	if rec := postWithBearer(server, "/api/refresh", tokens.RefreshToken, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Reused refresh status code = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	// The new access token works
+	if rec := postWithBearer(server, "/api/chirps", refreshed.Token, `{"body":"hi"}`); rec.Code != http.StatusCreated {
+		t.Errorf("Create chirp with refreshed token status code = %d, want %d", rec.Code, http.StatusCreated)
+	}
+}
+
+// slowUserStore delays Get so concurrent requests overlap
+type slowUserStore struct {
+	store.UserStore
+}
+
+func (s slowUserStore) Get(ctx context.Context, id string) (store.User, error) {
+	time.Sleep(20 * time.Millisecond)
+	return s.UserStore.Get(ctx, id)
+}
+
+func Test_handleRefresh_ConcurrentReuse_OnlyOneSucceeds(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithUserStore(slowUserStore{store.NewMemoryUserStore()}),
+		httpserver.WithPasswordParams(fastPasswordParams),
+	)
+	tokens := registerAndLogin(t, server)
+
+	const attempts = 20
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- postWithBearer(server, "/api/refresh", tokens.RefreshToken, "").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	succeeded := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusUnauthorized:
+		default:
+			t.Errorf("Refresh status code = %d, want %d or %d", code, http.StatusOK, http.StatusUnauthorized)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d concurrent refreshes with the same token succeeded, want 1", succeeded)
+	}
+}
+
+func Test_handleRevoke_RevokedTokenCannotRefresh(t *testing.T) {
+	server, _ := newUserServer(t)
+	tokens := registerAndLogin(t, server)
+
+	if rec := postWithBearer(server, "/api/revoke", tokens.RefreshToken, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("Revoke status code = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec := postWithBearer(server, "/api/refresh", tokens.RefreshToken, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Refresh after revoke status code = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := postWithBearer(server, "/api/revoke", "unknown", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Revoke unknown token status code = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+// Phase 7: Configurable Profanity Filter Tests
+
+func Test_ProfanityFilter_ReplacementStrategies(t *testing.T) {
+	filter, err := httpserver.NewProfanityFilter(httpserver.StaticProfanityWords([]httpserver.ProfanityWord{
+		{Word: "kerfuffle", Strategy: httpserver.ReplaceMask},
+		{Word: "sharbert", Strategy: httpserver.ReplaceKeepFirst},
+		{Word: "fornax", Strategy: httpserver.ReplaceToken, Token: "[redacted]"},
+		{Word: "darn"},
+	}))
+	if err != nil {
+		t.Fatalf("NewProfanityFilter error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"full mask", "a kerfuffle here", "a ********* here"},
+		{"first letter kept", "Sharbert again", "S******* again"},
+		{"fixed token", "fornax fornax", "[redacted] [redacted]"},
+		{"default token", "oh darn", "oh ****"},
+		{"punctuation still a word character", "darn!", "darn!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filter.Clean(tt.input); got != tt.expected {
+				t.Errorf("Clean(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_ProfanityFilter_TokenMatchingWord_DoesNotLoop(t *testing.T) {
+	filter, err := httpserver.NewProfanityFilter(httpserver.StaticProfanityWords([]httpserver.ProfanityWord{
+		{Word: "fornax", Token: "fornax"},
+	}))
+	if err != nil {
+		t.Fatalf("NewProfanityFilter error: %v", err)
+	}
+
+	if got := filter.Clean("fornax fornax"); got != "fornax fornax" {
+		t.Errorf("Clean = %q, want %q", got, "fornax fornax")
+	}
+}
+
+func Test_ParseProfanityList(t *testing.T) {
+	list := `# moderation list
+kerfuffle
+
+sharbert = mask
+fornax = first
+darn = token [oops]
+`
+	words, err := httpserver.ParseProfanityList(strings.NewReader(list))
+	if err != nil {
+		t.Fatalf("ParseProfanityList error: %v", err)
+	}
+
+	want := []httpserver.ProfanityWord{
+		{Word: "kerfuffle", Strategy: httpserver.ReplaceToken},
+		{Word: "sharbert", Strategy: httpserver.ReplaceMask},
+		{Word: "fornax", Strategy: httpserver.ReplaceKeepFirst},
+		{Word: "darn", Strategy: httpserver.ReplaceToken, Token: "[oops]"},
+	}
+	if len(words) != len(want) {
+		t.Fatalf("Parsed %d words, want %d: %+v", len(words), len(want), words)
+	}
+	for i := range want {
+		if words[i] != want[i] {
+			t.Errorf("Word %d = %+v, want %+v", i, words[i], want[i])
+		}
+	}
+}
+
+func Test_ParseProfanityList_InvalidEntry_ReturnsError(t *testing.T) {
+	for _, list := range []string{"kerfuffle = shout", "two words", "= mask"} {
+		if _, err := httpserver.ParseProfanityList(strings.NewReader(list)); err == nil {
+			t.Errorf("ParseProfanityList(%q) error = nil, want error", list)
+		}
+	}
+}
+
+func Test_ProfanityFile_Reload_PicksUpChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profanity.txt")
+	if err := os.WriteFile(path, []byte("kerfuffle\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+
+	filter, err := httpserver.NewProfanityFilter(httpserver.ProfanityFile(path))
+	if err != nil {
+		t.Fatalf("NewProfanityFilter error: %v", err)
+	}
+	if got := filter.Clean("kerfuffle sharbert"); got != "**** sharbert" {
+		t.Errorf("Before reload Clean = %q, want %q", got, "**** sharbert")
+	}
+
+	if err := os.WriteFile(path, []byte("sharbert = mask\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+	if err := filter.Reload(); err != nil {
+		t.Fatalf("Reload error: %v", err)
+	}
+	if got := filter.Clean("kerfuffle sharbert"); got != "kerfuffle ********" {
+		t.Errorf("After reload Clean = %q, want %q", got, "kerfuffle ********")
+	}
+
+	// A broken list is rejected and the previous one stays active
+	if err := os.WriteFile(path, []byte("sharbert = shout\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+	if err := filter.Reload(); err == nil {
+		t.Error("Expected reload of invalid list to fail")
+	}
+	if got := filter.Clean("sharbert"); got != "********" {
+		t.Errorf("After failed reload Clean = %q, want %q", got, "********")
+	}
+}
+
+func Test_ProfanityEnv_ParsesCommaSeparatedList(t *testing.T) {
+	t.Setenv("CHIRPY_TEST_PROFANITY", "kerfuffle, fornax = first")
+
+	filter, err := httpserver.NewProfanityFilter(httpserver.ProfanityEnv("CHIRPY_TEST_PROFANITY"))
+	if err != nil {
+		t.Fatalf("NewProfanityFilter error: %v", err)
+	}
+	if got := filter.Clean("kerfuffle fornax sharbert"); got != "**** f***** sharbert" {
+		t.Errorf("Clean = %q, want %q", got, "**** f***** sharbert")
+	}
+}
+
+func Test_handleValidateChirp_Server_UsesConfiguredFilter(t *testing.T) {
+	filter, err := httpserver.NewProfanityFilter(httpserver.StaticProfanityWords([]httpserver.ProfanityWord{
+		{Word: "darn", Strategy: httpserver.ReplaceKeepFirst},
+	}))
+	if err != nil {
+		t.Fatalf("NewProfanityFilter error: %v", err)
+	}
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithProfanityFilter(filter))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/validate_chirp", strings.NewReader(`{"body":"darn kerfuffle"}`))
+	rec := httptest.NewRecorder()
+	server.Mux().ServeHTTP(rec, req)
+
+	var response struct {
+		CleanedBody string `json:"cleaned_body"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response.CleanedBody != "d*** kerfuffle" {
+		t.Errorf("CleanedBody = %q, want %q", response.CleanedBody, "d*** kerfuffle")
+	}
+}
+
+// Phase 8: Strict Profanity Matching Tests
+
+func newStrictFilter(t *testing.T, words []httpserver.ProfanityWord) *httpserver.ProfanityFilter {
+	t.Helper()
+	filter, err := httpserver.NewProfanityFilter(httpserver.StaticProfanityWords(words), httpserver.StrictProfanityMatching())
+	if err != nil {
+		t.Fatalf("NewProfanityFilter error: %v", err)
+	}
+	return filter
+}
+
+func Test_ProfanityFilter_Strict_PunctuationIsBoundary(t *testing.T) {
+	filter := newStrictFilter(t, httpserver.DefaultProfanityWords)
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"exclamation mark", "Sharbert!", "****!"},
+		{"period", "kerfuffle.", "****."},
+		{"comma", "fornax, really", "****, really"},
+		{"quoted", `"kerfuffle"`, `"****"`},
+		{"hyphenated", "fornax-like", "****-like"},
+		{"still whole words only", "kerfuffled sharbertson", "kerfuffled sharbertson"},
+		{"spacing preserved", "  kerfuffle\tfornax  ", "  ****\t****  "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filter.Clean(tt.input); got != tt.expected {
+				t.Errorf("Clean(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_ProfanityFilter_Strict_FoldsUnicodeAndLeetspeak(t *testing.T) {
+	filter := newStrictFilter(t, httpserver.DefaultProfanityWords)
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"full-width letters", "ｋｅｒｆｕｆｆｌｅ"},
+		{"Cyrillic homoglyphs", "kеrfufflе"},
+		{"Cyrillic a", "shаrbert"},
+		{"combining marks", "ke\u0301rfu\u0308ffle"},
+		{"precomposed accents", "kérfüffle"},
+		{"leetspeak", "k3rfuffl3"},
+		{"leetspeak symbols", "$h@rbert"},
+		{"mathematical bold", "𝐟𝐨𝐫𝐧𝐚𝐱"},
+		{"ligature", "kerfuﬄe"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filter.Clean(tt.input + "!"); got != "****!" {
+				t.Errorf("Clean(%q) = %q, want %q", tt.input+"!", got, "****!")
+			}
+		})
+	}
+}
+
+func Test_ProfanityFilter_Strict_MaskCountsVisibleCharacters(t *testing.T) {
+	filter := newStrictFilter(t, []httpserver.ProfanityWord{
+		{Word: "fornax", Strategy: httpserver.ReplaceMask},
+		{Word: "sharbert", Strategy: httpserver.ReplaceKeepFirst},
+	})
+
+	if got := filter.Clean("fo\u0301rnax?"); got != "******?" {
+		t.Errorf("Clean = %q, want %q", got, "******?")
+	}
+	if got := filter.Clean("(Ｓharbert)"); got != "(Ｓ*******)" {
+		t.Errorf("Clean = %q, want %q", got, "(Ｓ*******)")
+	}
+}
+
+func Test_ProfanityFilter_NotStrict_KeepsWhitespaceBoundaries(t *testing.T) {
+	filter, err := httpserver.NewProfanityFilter(httpserver.StaticProfanityWords(httpserver.DefaultProfanityWords))
+	if err != nil {
+		t.Fatalf("NewProfanityFilter error: %v", err)
+	}
+
+	for _, input := range []string{"Kerfuffle!", "k3rfuffl3", "ｋｅｒｆｕｆｆｌｅ"} {
+		if got := filter.Clean(input); got != input {
+			t.Errorf("Clean(%q) = %q, want unchanged", input, got)
+		}
+	}
+}
+
+// Phase 9: Prometheus Metrics Tests
+
+// testAdminKey is the admin API key of servers that tests scrape
+const testAdminKey = "test-admin-key"
+
+// scrape fetches /admin/metrics/prometheus from server, which must use testAdminKey
+func scrape(t *testing.T, server *httpserver.Server) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/admin/metrics/prometheus", nil)
+	req.Header.Set("Authorization", "ApiKey "+testAdminKey)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Scrape status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+	return rec.Body.String()
+}
+
+func Test_metrics_RecordsRequestsByRoutePattern(t *testing.T) {
+	server, chirps := newChirpServer(t)
+	created, err := chirps.Create(context.Background(), store.Chirp{Body: "hello"})
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+
+	for _, path := range []string{"/api/chirps/" + created.ID, "/api/chirps/missing", "/nowhere"} {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	body := scrape(t, server)
+	for _, want := range []string{
+		`chirpy_http_requests_total{method="GET",route="GET /api/chirps/{id}",code="200"} 1`,
+		`chirpy_http_requests_total{method="GET",route="GET /api/chirps/{id}",code="404"} 1`,
+		`chirpy_http_requests_total{method="GET",route="unmatched",code="404"} 1`,
+		`chirpy_http_request_duration_seconds_count{method="GET",route="GET /api/chirps/{id}"} 2`,
+		`chirpy_http_requests_in_flight 1`, // the scrape itself
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Metrics missing %q\n%s", want, body)
+		}
+	}
+}
+
+func Test_metrics_UnknownMethods_ShareOneSeries(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithAdminAPIKey(testAdminKey))
+
+	for i := range 50 {
+		req := httptest.NewRequest("MADEUP"+strconv.Itoa(i), "/api/chirps", nil)
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	body := scrape(t, server)
+	if strings.Contains(body, "MADEUP") {
+		t.Errorf("Metrics label made-up methods\n%s", body)
+	}
+	want := `chirpy_http_requests_total{method="other",route="unmatched",code="405"} 50`
+	if !strings.Contains(body, want) {
+		t.Errorf("Metrics missing %q\n%s", want, body)
+	}
+}
+
+func Test_metrics_RecordsChirpValidationOutcomes(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithAdminAPIKey(testAdminKey))
+
+	for _, reqBody := range []string{
+		`{"body":"fine"}`,
+		`{"body":"a kerfuffle"}`,
+		`{"body":"` + strings.Repeat("a", 141) + `"}`,
+		`{broken`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/validate_chirp", strings.NewReader(reqBody))
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	body := scrape(t, server)
+	for _, outcome := range []string{"accepted", "cleaned", "too_long", "invalid_json"} {
+		want := `chirpy_chirp_validations_total{outcome="` + outcome + `"} 1`
+		if !strings.Contains(body, want) {
+			t.Errorf("Metrics missing %q\n%s", want, body)
+		}
+	}
+}
+
+func Test_metrics_SharedRegistry_IncludesCallerMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	registry.NewCounter("custom_total", "Registered by the caller.").Inc()
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithMetricsRegistry(registry), httpserver.WithAdminAPIKey(testAdminKey))
+
+	if server.Metrics() != registry {
+		t.Error("Expected Metrics() to return the configured registry")
+	}
+	if body := scrape(t, server); !strings.Contains(body, "custom_total 1") {
+		t.Errorf("Metrics missing caller metric\n%s", body)
+	}
+}
+
+// Phase 10: Request Logging Tests
+
+// newLoggedServer returns a server whose request logs are captured as JSON in buf
+func newLoggedServer(t *testing.T, buf *bytes.Buffer) *httpserver.Server {
+	t.Helper()
+	logger, err := httpserver.NewLogger(buf, "info", "json")
+	if err != nil {
+		t.Fatalf("NewLogger error: %v", err)
+	}
+	return httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithLogger(logger), httpserver.WithAdminAPIKey(testAdminKey))
+}
+
+func Test_logRequests_WritesOneJSONLinePerRequest(t *testing.T) {
+	var buf bytes.Buffer
+	server := newLoggedServer(t, &buf)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/healthz", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Logged %d lines, want 1:\n%s", len(lines), buf.String())
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Log line is not JSON: %v", err)
+	}
+	want := map[string]any{
+		"msg":         "request",
+		"method":      "GET",
+		"path":        "/api/healthz",
+		"route":       "GET /api/healthz",
+		"status":      float64(200),
+		"bytes":       float64(2),
+		"remote_addr": "192.0.2.1:1234",
+		"request_id":  rec.Header().Get("X-Request-ID"),
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("Log %s = %v, want %v", key, entry[key], value)
+		}
+	}
+	if _, ok := entry["duration"]; !ok {
+		t.Error("Expected duration in log line")
+	}
+}
+
+func Test_withRequestID_GeneratesOrPropagatesID(t *testing.T) {
+	server := newLoggedServer(t, &bytes.Buffer{})
+
+	// Without a header the server assigns an ID
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/healthz", nil))
+	generated := rec.Header().Get("X-Request-ID")
+	if len(generated) != 32 {
+		t.Errorf("Generated request ID = %q, want 32 hex characters", generated)
+	}
+
+	// A well-formed client ID is kept
+	req := httptest.NewRequest(http.MethodGet, "/api/healthz", nil)
+	req.Header.Set("X-Request-ID", "client-abc-123")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); got != "client-abc-123" {
+		t.Errorf("Propagated request ID = %q, want %q", got, "client-abc-123")
+	}
+
+	// A malformed client ID is replaced
+	req = httptest.NewRequest(http.MethodGet, "/api/healthz", nil)
+	req.Header.Set("X-Request-ID", "bad id\twith spaces")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); got == "bad id\twith spaces" || got == "" {
+		t.Errorf("Request ID = %q, want a fresh ID", got)
+	}
+}
+
+func Test_NewLogger_LevelAndFormat(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := httpserver.NewLogger(&buf, "warn", "text")
+	if err != nil {
+		t.Fatalf("NewLogger error: %v", err)
+	}
+
+	logger.Info("hidden")
+	logger.Warn("shown")
+	if strings.Contains(buf.String(), "hidden") || !strings.Contains(buf.String(), "msg=shown") {
+		t.Errorf("Log output = %q, want only the warning in text format", buf.String())
+	}
+
+	if _, err := httpserver.NewLogger(&buf, "loud", "json"); err == nil {
+		t.Error("Expected error for unknown level")
+	}
+	if _, err := httpserver.NewLogger(&buf, "info", "xml"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+// Phase 11: Panic Recovery Tests
+
+func Test_recoverPanics_Returns500JSONAndLogs(t *testing.T) {
+	var buf bytes.Buffer
+	server := newLoggedServer(t, &buf)
+	server.Mux().HandleFunc("GET /boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("something broke")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/boom", nil)
+	req.Header.Set("X-Request-ID", "panic-test")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content-Type = %q, want %q", ct, "application/problem+json")
+	}
+	var response struct {
+		Code      string `json:"code"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.Code != "internal_error" || response.RequestID != "panic-test" {
+		t.Errorf("Body = %q, want internal_error problem for panic-test (%v)", rec.Body.String(), err)
+	}
+
+	logs := buf.String()
+	for _, want := range []string{`"msg":"panic serving request"`, `"request_id":"panic-test"`, `"panic":"something broke"`, "goroutine"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("Logs missing %q:\n%s", want, logs)
+		}
+	}
+	if !strings.Contains(logs, `"status":500`) {
+		t.Errorf("Request log should record status 500:\n%s", logs)
+	}
+
+	if body := scrape(t, server); !strings.Contains(body, "chirpy_http_panics_total 1") {
+		t.Errorf("Metrics missing panic count\n%s", body)
+	}
+}
+
+func Test_recoverPanics_AfterHeaderWritten_AbortsResponse(t *testing.T) {
+	server := newLoggedServer(t, &bytes.Buffer{})
+	server.Mux().HandleFunc("GET /halfway", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("partial"))
+		http.NewResponseController(w).Flush()
+		panic("too late")
+	})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/halfway")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Status code = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+	if body, err := io.ReadAll(resp.Body); err == nil {
+		t.Errorf("Read complete body %q, want the response cut off", body)
+	}
+	if body := scrape(t, server); !strings.Contains(body, "chirpy_http_panics_total 1") {
+		t.Errorf("Panic not counted\n%s", body)
+	}
+}
+
+func Test_recoverPanics_ErrAbortHandler_IsRepanicked(t *testing.T) {
+	server := newLoggedServer(t, &bytes.Buffer{})
+	server.Mux().HandleFunc("GET /abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recover() = %v, want http.ErrAbortHandler", err)
+		}
+	}()
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abort", nil))
+}
+
+// Phase 12: Server Configuration Tests
+
+func Test_WithMaxChirpLength_AppliesToValidateAndCreate(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithJWTSecret(testJWTSecret),
+		httpserver.WithMaxChirpLength(10),
+	)
+	body := `{"body": "eleven char"}`
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/validate_chirp", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("validate_chirp status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, authorize(t, httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(body)), "user-1"))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("create chirp status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/validate_chirp", strings.NewReader(`{"body": "ten chars!"}`)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("validate_chirp at the limit status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+// Phase 13: TLS Tests
+
+func Test_EnsureSelfSignedCert_GeneratesThenReuses(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "certs")
+
+	certFile, keyFile, err := httpserver.EnsureSelfSignedCert(dir, "localhost", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("EnsureSelfSignedCert error: %v", err)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadX509KeyPair error: %v", err)
+	}
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		if err := cert.Leaf.VerifyHostname(host); err != nil {
+			t.Errorf("Certificate doesn't cover %s: %v", host, err)
+		}
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Key file mode = %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+
+	first, _ := os.ReadFile(certFile)
+	if _, _, err := httpserver.EnsureSelfSignedCert(dir, "localhost", "127.0.0.1"); err != nil {
+		t.Fatalf("EnsureSelfSignedCert error: %v", err)
+	}
+	second, _ := os.ReadFile(certFile)
+	if !bytes.Equal(first, second) {
+		t.Error("Cached certificate should be reused on the next start")
+	}
+
+	if _, _, err := httpserver.EnsureSelfSignedCert(dir, "staging.internal"); err != nil {
+		t.Fatalf("EnsureSelfSignedCert error: %v", err)
+	}
+	third, _ := os.ReadFile(certFile)
+	if bytes.Equal(second, third) {
+		t.Error("Certificate should be regenerated when the hosts change")
+	}
+}
+
+func Test_WithHSTS_SetOnlyOverTLS(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithHSTS(365*24*time.Hour))
+
+	ts := httptest.NewTLSServer(server)
+	defer ts.Close()
+	resp, err := ts.Client().Get(ts.URL + "/api/healthz")
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("Strict-Transport-Security"); got != "max-age=31536000" {
+		t.Errorf("Strict-Transport-Security = %q, want %q", got, "max-age=31536000")
+	}
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/healthz", nil))
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("Plain HTTP response should not carry HSTS, got %q", got)
+	}
+}
+
+func Test_redirectToHTTPS_KeepsHostPathAndQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		tlsAddr  string
+		host     string
+		target   string
+		expected string
+	}{
+		{"custom port", ":8443", "example.com:8080", "/api/chirps?author_id=1", "https://example.com:8443/api/chirps?author_id=1"},
+		{"default port", ":443", "example.com", "/app/", "https://example.com/app/"},
+		{"IPv6 host", ":443", "[::1]:8080", "/", "https://[::1]/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			req.Host = tt.host
+			rec := httptest.NewRecorder()
+			httpserver.RedirectToHTTPSForTest(tt.tlsAddr).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusPermanentRedirect {
+				t.Errorf("Status code = %d, want %d", rec.Code, http.StatusPermanentRedirect)
+			}
+			if got := rec.Header().Get("Location"); got != tt.expected {
+				t.Errorf("Location = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_ListenAndServe_WithTLSMissingFiles_ReturnsError(t *testing.T) {
+	dir := t.TempDir()
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithAddr("127.0.0.1:0"),
+		httpserver.WithTLS(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")),
+	)
+	if err := server.ListenAndServe(); err == nil || errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Expected certificate load error, got %v", err)
+	}
+}
+
+// Phase 14: Rate Limiting Tests
+
+func Test_rateLimiter_RefillsAndEvictsIdleBuckets(t *testing.T) {
+	now := time.Unix(0, 0)
+	clock := func() time.Time { return now }
+	limiter := httpserver.NewRateLimiterForTest(httpserver.RateLimit{Requests: 2, Per: time.Minute}, clock)
+
+	for i := range 2 {
+		if ok, _ := limiter.Allow("a"); !ok {
+			t.Fatalf("Request %d should be allowed", i+1)
+		}
+	}
+	ok, retryAfter := limiter.Allow("a")
+	if ok {
+		t.Fatal("Third request should be limited")
+	}
+	if retryAfter != 30*time.Second {
+		t.Errorf("Retry-After = %s, want 30s", retryAfter)
+	}
+	if ok, _ := limiter.Allow("b"); !ok {
+		t.Error("Other clients should have their own bucket")
+	}
+
+	now = now.Add(30 * time.Second)
+	if ok, _ := limiter.Allow("a"); !ok {
+		t.Error("Bucket should refill one token after 30s")
+	}
+
+	now = now.Add(2 * time.Minute)
+	limiter.Allow("c")
+	if got := limiter.Buckets(); got != 1 {
+		t.Errorf("Buckets = %d after idle clients refilled, want 1", got)
+	}
+}
+
+func Test_WithRateLimit_Returns429WithHeaders(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithRateLimit(httpserver.RateLimitWrite, httpserver.RateLimit{Requests: 2, Per: time.Hour}),
+		httpserver.WithAdminAPIKey(testAdminKey),
+	)
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/validate_chirp", strings.NewReader(`{"body": "hi"}`))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := send("192.0.2.1:1234")
+	if first.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d", first.Code, http.StatusOK)
+	}
+	if first.Header().Get("RateLimit-Limit") != "2" || first.Header().Get("RateLimit-Remaining") != "1" {
+		t.Errorf("RateLimit headers = %v", first.Header())
+	}
+	if got := first.Header().Get("RateLimit-Policy"); got != "2;w=3600" {
+		t.Errorf("RateLimit-Policy = %q, want %q", got, "2;w=3600")
+	}
+
+	send("192.0.2.1:1235")
+	limited := send("192.0.2.1:1236")
+	if limited.Code != http.StatusTooManyRequests {
+		t.Fatalf("Status code = %d, want %d", limited.Code, http.StatusTooManyRequests)
+	}
+	if got := limited.Header().Get("Retry-After"); got != "1800" {
+		t.Errorf("Retry-After = %q, want %q", got, "1800")
+	}
+	if limited.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("RateLimit-Remaining = %q, want 0", limited.Header().Get("RateLimit-Remaining"))
+	}
+
+	if rec := send("192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("Other client status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("Read routes should not share the write limit: %d %v", rec.Code, rec.Header())
+	}
+
+	if body := scrape(t, server); !strings.Contains(body, `chirpy_rate_limited_requests_total{group="write"} 1`) {
+		t.Errorf("Metrics missing rate limited count\n%s", body)
+	}
+}
+
+func Test_WithRateLimit_KeysAuthenticatedUsersByUserID(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithJWTSecret(testJWTSecret),
+		httpserver.WithRateLimit(httpserver.RateLimitWrite, httpserver.RateLimit{Requests: 1, Per: time.Hour}),
+	)
+	send := func(remoteAddr, userID string) int {
+		req := authorize(t, httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(`{"body": "hi"}`)), userID)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send("192.0.2.1:1", "user-1"); code != http.StatusCreated {
+		t.Fatalf("Status code = %d, want %d", code, http.StatusCreated)
+	}
+	if code := send("192.0.2.1:1", "user-2"); code != http.StatusCreated {
+		t.Errorf("Second user behind the same IP: status code = %d, want %d", code, http.StatusCreated)
+	}
+	if code := send("192.0.2.9:1", "user-1"); code != http.StatusTooManyRequests {
+		t.Errorf("Same user from another IP: status code = %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func Test_WithRateLimit_RoutesInGroup_ShareLimit(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithRateLimit(httpserver.RateLimitWrite, httpserver.RateLimit{Requests: 2, Per: time.Hour}),
+	)
+	send := func(method, path string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"body": "hi"}`))
+		req.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := range 2 {
+		if code := send(http.MethodPost, "/api/validate_chirp"); code != http.StatusOK {
+			t.Fatalf("Request %d status code = %d, want %d", i+1, code, http.StatusOK)
+		}
+	}
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPost, "/api/chirps"},
+		{http.MethodDelete, "/api/chirps/some-id"},
+	} {
+		if code := send(route.method, route.path); code != http.StatusTooManyRequests {
+			t.Errorf("%s %s status code = %d, want %d once the write group is drained", route.method, route.path, code, http.StatusTooManyRequests)
+		}
+	}
+}
+
+func Test_WithRateLimit_IPv6Clients_KeyedBy64(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithRateLimit(httpserver.RateLimitRead, httpserver.RateLimit{Requests: 1, Per: time.Hour}),
+	)
+	send := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send("[2001:db8::1]:1234"); code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d", code, http.StatusOK)
+	}
+	if code := send("[2001:db8::2]:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("Other address in the same /64: status code = %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := send("[2001:db8:0:1::1]:1234"); code != http.StatusOK {
+		t.Errorf("Address in another /64: status code = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func Test_WithTrustedProxies_UsesForwardedClient(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithRateLimit(httpserver.RateLimitRead, httpserver.RateLimit{Requests: 1, Per: time.Hour}),
+		httpserver.WithTrustedProxies(netip.MustParsePrefix("10.0.0.0/8")),
+	)
+	send := func(remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send("10.0.0.1:1", "198.51.100.1"); code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d", code, http.StatusOK)
+	}
+	if code := send("10.0.0.2:1", "198.51.100.2, 10.0.0.5"); code != http.StatusOK {
+		t.Errorf("Different client via the proxy: status code = %d, want %d", code, http.StatusOK)
+	}
+	if code := send("10.0.0.1:1", "203.0.113.7, 198.51.100.1"); code != http.StatusTooManyRequests {
+		t.Errorf("Spoofed leftmost entry should not change the client: status code = %d", code)
+	}
+	if code := send("198.51.100.3:1", "198.51.100.4"); code != http.StatusOK {
+		t.Errorf("Untrusted peer: status code = %d, want %d", code, http.StatusOK)
+	}
+	if code := send("198.51.100.3:1", "198.51.100.5"); code != http.StatusTooManyRequests {
+		t.Errorf("X-Forwarded-For from an untrusted peer must be ignored: status code = %d", code)
+	}
+}
+
+// Phase 15: Request Body Decoding Tests
+
+func Test_decodeJSON_RejectsBadBodies(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		code        string
+		message     string
+	}{
+		{"unknown field", "application/json", `{"body": "hi", "extra": 1}`, http.StatusBadRequest, "unknown_field", `Unknown field "extra"`},
+		{"trailing data", "application/json", `{"body": "hi"} {"body": "again"}`, http.StatusBadRequest, "invalid_json", "Request body must contain a single JSON value"},
+		{"trailing garbage", "", `{"body": "hi"}garbage`, http.StatusBadRequest, "invalid_json", "Request body must contain a single JSON value"},
+		{"wrong type", "", `{"body": 42}`, http.StatusBadRequest, "invalid_json", "Invalid JSON: body must be a string"},
+		{"not an object", "", `["hi"]`, http.StatusBadRequest, "invalid_json", "Request body must be a JSON object"},
+		{"truncated", "", `{"body": "hi"`, http.StatusBadRequest, "invalid_json", "Invalid JSON: unexpected end of input"},
+		{"empty body", "", ``, http.StatusBadRequest, "invalid_json", "Request body must not be empty"},
+		{"blank chirp", "", `{"body": "   "}`, http.StatusBadRequest, "validation_failed", "body is required"},
+		{"form content type", "application/x-www-form-urlencoded", `body=hi`, http.StatusUnsupportedMediaType, "unsupported_media_type", "Content-Type must be application/json"},
+		{"text content type", "text/plain", `{"body": "hi"}`, http.StatusUnsupportedMediaType, "unsupported_media_type", "Content-Type must be application/json"},
+		{"too large", "", `{"body": "` + strings.Repeat("a", 1<<20) + `"}`, http.StatusRequestEntityTooLarge, "body_too_large", "Request body must not be larger than 1048576 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/validate_chirp", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			httpserver.HandleValidateChirp(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("Status code = %d, want %d", rec.Code, tt.status)
+			}
+			var response struct {
+				Status int    `json:"status"`
+				Code   string `json:"code"`
+				Detail string `json:"detail"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal error response: %v", err)
+			}
+			if response.Status != tt.status || response.Code != tt.code {
+				t.Errorf("Problem status/code = %d/%q, want %d/%q", response.Status, response.Code, tt.status, tt.code)
+			}
+			if response.Detail != tt.message {
+				t.Errorf("Detail = %q, want %q", response.Detail, tt.message)
+			}
+		})
+	}
+}
+
+func Test_decodeJSON_AcceptsJSONContentTypes(t *testing.T) {
+	for _, ct := range []string{"application/json", "application/json; charset=utf-8", "application/merge-patch+json"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/validate_chirp", strings.NewReader(`{"body": "hi"}`))
+		req.Header.Set("Content-Type", ct)
+		rec := httptest.NewRecorder()
+		httpserver.HandleValidateChirp(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("Content-Type %q: status code = %d, want %d", ct, rec.Code, http.StatusOK)
+		}
+	}
+}
+
+func Test_handleCreateUser_MissingFields_ListsEach(t *testing.T) {
+	server, _ := newUserServer(t)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(`{}`)))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if !strings.Contains(rec.Body.String(), "email is required; password is required") {
+		t.Errorf("Body = %q, want both missing fields", rec.Body.String())
+	}
+}
+
+// Phase 16: Problem Details Tests
+
+func Test_RespondWithError_WritesProblemDetails(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler())
+	body := `{"body": "` + strings.Repeat("a", 141) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/validate_chirp", strings.NewReader(body))
+	req.Header.Set("X-Request-ID", "problem-test")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content-Type = %q, want %q", ct, "application/problem+json")
+	}
+	var got map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to unmarshal problem: %v", err)
+	}
+	want := map[string]any{
+		"type":       "/problems/chirp_too_long",
+		"title":      "Chirp is too long",
+		"status":     float64(http.StatusBadRequest),
+		"detail":     "Chirp is too long",
+		"instance":   "/api/validate_chirp",
+		"code":       "chirp_too_long",
+		"request_id": "problem-test",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+}
+
+func Test_RespondWithError_NegotiatesLegacyShape(t *testing.T) {
+	tests := []struct {
+		accept string
+		legacy bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", true},
+		{"text/html, application/json;q=0.9", true},
+		{"application/problem+json, application/json", false},
+		{"application/json, application/problem+json;q=0", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/validate_chirp", strings.NewReader(`{`))
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			httpserver.HandleValidateChirp(rec, req)
+
+			var response struct {
+				Error string `json:"error"`
+				Code  string `json:"code"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal error response: %v", err)
+			}
+			ct := rec.Header().Get("Content-Type")
+			if tt.legacy && (ct != "application/json" || response.Error == "" || response.Code != "") {
+				t.Errorf("Expected legacy error, got %s %s", ct, rec.Body.String())
+			}
+			if !tt.legacy && (ct != "application/problem+json" || response.Code != "invalid_json") {
+				t.Errorf("Expected problem details, got %s %s", ct, rec.Body.String())
+			}
+		})
+	}
+}
+
+func Test_UnmatchedRoutes_ReturnProblemDetails(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler())
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/healthz", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if allow := rec.Header().Get("Allow"); allow != "GET, HEAD" {
+		t.Errorf("Allow = %q, want %q", allow, "GET, HEAD")
+	}
+	if !strings.Contains(rec.Body.String(), `"code":"method_not_allowed"`) {
+		t.Errorf("Body = %q, want method_not_allowed problem", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/nowhere", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content-Type = %q, want %q", ct, "application/problem+json")
+	}
+	if !strings.Contains(rec.Body.String(), `"code":"not_found"`) {
+		t.Errorf("Body = %q, want not_found problem", rec.Body.String())
+	}
+}
+
+// Phase 17: OpenAPI Document Tests
+
+// fetchOpenAPI returns the server's decoded /api/openapi.json
+func fetchOpenAPI(t *testing.T, server *httpserver.Server) map[string]any {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to unmarshal OpenAPI document: %v", err)
+	}
+	return doc
+}
+
+// lookup walks doc along keys, failing the test if a key is missing
+func lookup(t *testing.T, doc any, keys ...string) any {
+	t.Helper()
+	for i, key := range keys {
+		m, ok := doc.(map[string]any)
+		if !ok || m[key] == nil {
+			t.Fatalf("OpenAPI document has no %s", strings.Join(keys[:i+1], "."))
+		}
+		doc = m[key]
+	}
+	return doc
+}
+
+func Test_NewWithConfig_AllRoutesDocumented(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler())
+	if undocumented := server.Mux().Undocumented(); len(undocumented) > 0 {
+		t.Errorf("Routes registered without documentation: %v", undocumented)
+	}
+}
+
+func Test_OpenAPI_DescribesRegisteredRoutes(t *testing.T) {
+	doc := fetchOpenAPI(t, httpserver.NewWithConfig(http.NotFoundHandler()))
+
+	if doc["openapi"] != "3.1.0" {
+		t.Errorf("openapi = %v, want 3.1.0", doc["openapi"])
+	}
+	for _, path := range []string{"/", "/app/{path}", "/api/healthz", "/api/validate_chirp", "/api/users", "/api/login", "/api/chirps", "/api/chirps/{id}"} {
+		lookup(t, doc, "paths", path)
+	}
+
+	del := lookup(t, doc, "paths", "/api/chirps/{id}", "delete").(map[string]any)
+	if del["security"] == nil {
+		t.Error("DELETE /api/chirps/{id} should require a bearer token")
+	}
+	param := lookup(t, del, "parameters").([]any)[0].(map[string]any)
+	if param["name"] != "id" || param["in"] != "path" {
+		t.Errorf("Parameter = %v, want path parameter id", param)
+	}
+	lookup(t, del, "responses", "403", "content", "application/problem+json")
+
+	ref := lookup(t, doc, "paths", "/api/chirps", "post", "requestBody", "content", "application/json", "schema", "$ref")
+	if ref != "#/components/schemas/CreateChirpRequest" {
+		t.Errorf("Request schema = %v, want CreateChirpRequest", ref)
+	}
+	items := lookup(t, doc, "paths", "/api/chirps", "get", "responses", "200", "content", "application/json", "schema", "items", "$ref")
+	if items != "#/components/schemas/Chirp" {
+		t.Errorf("List items = %v, want Chirp", items)
+	}
+
+	if format := lookup(t, doc, "components", "schemas", "Chirp", "properties", "created_at", "format"); format != "date-time" {
+		t.Errorf("created_at format = %v, want date-time", format)
+	}
+	login := lookup(t, doc, "components", "schemas", "LoginResponse", "properties").(map[string]any)
+	for _, field := range []string{"id", "email", "token", "refresh_token"} {
+		if login[field] == nil {
+			t.Errorf("LoginResponse is missing %s", field)
+		}
+	}
+	if _, leaked := login["hashed_password"]; leaked {
+		t.Error("LoginResponse must not document hashed_password")
+	}
+}
+
+func Test_OpenAPI_IncludesRoutesAddedThroughMux(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler())
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	server.Mux().HandleFunc("GET /extra/{name...}", noop, httpserver.RouteDoc{Summary: "Extra route"})
+	server.Mux().HandleFunc("GET /secret", noop)
+
+	doc := fetchOpenAPI(t, server)
+	if summary := lookup(t, doc, "paths", "/extra/{name}", "get", "summary"); summary != "Extra route" {
+		t.Errorf("summary = %v, want %q", summary, "Extra route")
+	}
+	if _, ok := doc["paths"].(map[string]any)["/secret"]; ok {
+		t.Error("Undocumented routes should not appear in the document")
+	}
+	if got := server.Mux().Undocumented(); len(got) != 1 || got[0] != "GET /secret" {
+		t.Errorf("Undocumented() = %v, want [GET /secret]", got)
+	}
+}
+
+func Test_APIExplorer_ServesHTML(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler())
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/docs", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q, want text/html", ct)
+	}
+	if !strings.Contains(rec.Body.String(), `fetch("/api/openapi.json")`) {
+		t.Error("Explorer should load /api/openapi.json")
+	}
+}
+
+// Phase 18: Embedded Static Asset Tests
+
+func Test_New_ServesOnlyEmbeddedAssets(t *testing.T) {
+	server := httpserver.New()
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/", http.StatusOK, "Welcome to Chirpy"},
+		{"/app/", http.StatusOK, "Welcome to Chirpy"},
+		{"/app/assets/logo.png", http.StatusOK, ""},
+		{"/app/main.go", http.StatusNotFound, ""},
+		{"/app/go.mod", http.StatusNotFound, ""},
+		{"/app/.git/config", http.StatusNotFound, ""},
+		{"/app/web/web.go", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Errorf("Status code = %d, want %d", rec.Code, tt.status)
+			}
+			if !strings.Contains(rec.Body.String(), tt.body) {
+				t.Errorf("Body = %q, want it to contain %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func Test_WithStaticFS_ServesHomePageFromFS(t *testing.T) {
+	static := fstest.MapFS{"index.html": {Data: []byte("<h1>Dev build</h1>")}}
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithStaticFS(static))
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "<h1>Dev build</h1>" {
+		t.Errorf("GET / = %d %q, want the index.html from the FS", rec.Code, rec.Body.String())
+	}
+}
+
+// Phase 19: Caching and Compression Tests
+
+// newStaticServer serves files under /app with FileServer and the given options
+func newStaticServer(files fstest.MapFS, opts ...httpserver.FileServerOption) *httpserver.Server {
+	return httpserver.NewWithConfig(http.StripPrefix("/app", httpserver.FileServer(files, opts...)), httpserver.WithAdminAPIKey(testAdminKey))
+}
+
+func Test_FileServer_ETag_AnswersConditionalRequestWith304(t *testing.T) {
+	files := fstest.MapFS{"style.css": {Data: []byte("body { color: red }")}}
+	server := newStaticServer(files)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/app/style.css", nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(etag, `"`) {
+		t.Fatalf("GET = %d with ETag %q, want 200 with a strong ETag", rec.Code, etag)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/app/style.css", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("Conditional GET = %d with %d body bytes, want 304 and no body", rec.Code, rec.Body.Len())
+	}
+
+	if body := scrape(t, server); !strings.Contains(body, `chirpy_http_not_modified_total{route="/app/"} 1`) {
+		t.Errorf("Metrics missing the 304\n%s", body)
+	}
+}
+
+func Test_FileServer_ChangedFile_GetsNewETag(t *testing.T) {
+	files := fstest.MapFS{"app.js": {Data: []byte("v1"), ModTime: time.Unix(1, 0)}}
+	server := newStaticServer(files)
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/app/app.js", nil))
+		return rec
+	}
+	first := get().Header().Get("ETag")
+	if again := get().Header().Get("ETag"); again != first {
+		t.Errorf("ETag changed from %s to %s without a change to the file", first, again)
+	}
+
+	files["app.js"] = &fstest.MapFile{Data: []byte("v2"), ModTime: time.Unix(2, 0)}
+	rec := get()
+	if rec.Header().Get("ETag") == first || rec.Body.String() != "v2" {
+		t.Errorf("After a change got ETag %s and body %q, want a new ETag and v2", rec.Header().Get("ETag"), rec.Body.String())
+	}
+}
+
+func Test_FileServer_CachePolicies_SetCacheControlByExtension(t *testing.T) {
+	files := fstest.MapFS{
+		"index.html": {Data: []byte("<p>home</p>")},
+		"logo.png":   {Data: []byte("png")},
+		"app.js":     {Data: []byte("js")},
+	}
+	server := newStaticServer(files, httpserver.CachePolicies(httpserver.CacheControl{".js": "public, max-age=31536000, immutable"}))
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/app/", "no-cache"},
+		{"/app/logo.png", "public, max-age=3600"},
+		{"/app/app.js", "public, max-age=31536000, immutable"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if got := rec.Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("%s: Cache-Control = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func Test_FileServer_Precompressed_ServesAcceptedSibling(t *testing.T) {
+	files := fstest.MapFS{
+		"app.js":    {Data: []byte("plain")},
+		"app.js.gz": {Data: []byte("gzipped")},
+		"app.js.br": {Data: []byte("brotli")},
+	}
+	server := newStaticServer(files)
+
+	tests := []struct {
+		acceptEncoding string
+		body           string
+		encoding       string
+	}{
+		{"", "plain", ""},
+		{"gzip", "gzipped", "gzip"},
+		{"gzip, br", "brotli", "br"},
+		{"br;q=0, gzip", "gzipped", "gzip"},
+		{"*;q=0", "plain", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/app/app.js", nil)
+		if tt.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+
+		if rec.Body.String() != tt.body || rec.Header().Get("Content-Encoding") != tt.encoding {
+			t.Errorf("Accept-Encoding %q: got %q encoded as %q, want %q as %q", tt.acceptEncoding,
+				rec.Body.String(), rec.Header().Get("Content-Encoding"), tt.body, tt.encoding)
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/javascript") {
+			t.Errorf("Accept-Encoding %q: Content-Type = %q, want text/javascript", tt.acceptEncoding, ct)
+		}
+		if rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: Vary = %q, want Accept-Encoding", tt.acceptEncoding, rec.Header().Get("Vary"))
+		}
+	}
+}
+
+func Test_withCompression_LargeTextResponse_IsGzipped(t *testing.T) {
+	page := strings.Repeat("<p>chirp</p>", 200)
+	files := fstest.MapFS{"index.html": {Data: []byte(page)}}
+	server := newStaticServer(files)
+
+	req := httptest.NewRequest(http.MethodGet, "/app/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", rec.Header().Get("Content-Encoding"))
+	}
+	if etag := rec.Header().Get("ETag"); !strings.HasPrefix(etag, `W/"`) {
+		t.Errorf("ETag = %q, want a weak ETag for the compressed body", etag)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader error: %v", err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil || string(body) != page {
+		t.Errorf("Decompressed body mismatch (err %v)", err)
+	}
+}
+
+func Test_withCompression_SkipsSmallBinaryAndUnacceptedResponses(t *testing.T) {
+	large := strings.Repeat("x", 2048)
+	files := fstest.MapFS{
+		"small.txt": {Data: []byte("tiny")},
+		"large.txt": {Data: []byte(large)},
+		"image.png": {Data: []byte(large)},
+	}
+	server := newStaticServer(files)
+
+	tests := []struct {
+		path           string
+		acceptEncoding string
+	}{
+		{"/app/small.txt", "gzip"},
+		{"/app/image.png", "gzip"},
+		{"/app/large.txt", ""},
+		{"/app/large.txt", "gzip;q=0"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+			t.Errorf("%s with Accept-Encoding %q: Content-Encoding = %q, want none", tt.path, tt.acceptEncoding, enc)
+		}
+	}
+}
+
+func Test_withCompression_APIResponse_IsGzipped(t *testing.T) {
+	server, chirps := newChirpServer(t)
+	for range 20 {
+		if _, err := chirps.Create(context.Background(), store.Chirp{Body: "a chirp long enough to be worth compressing"}); err != nil {
+			t.Fatalf("Create error: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Got %q encoded as %q, want gzipped application/json",
+			rec.Header().Get("Content-Type"), rec.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader error: %v", err)
+	}
+	var got []store.Chirp
+	if err := json.NewDecoder(zr).Decode(&got); err != nil || len(got) != 20 {
+		t.Errorf("Decoded %d chirps (err %v), want 20", len(got), err)
+	}
+}
+
+// Phase 20: Hardened Static Handler Tests
+
+// spaFiles is a small single-page app with a dot file and a directory without an index
+var spaFiles = fstest.MapFS{
+	"index.html":         {Data: []byte("<div id=app></div>")},
+	"assets/app.js":      {Data: []byte("render()")},
+	"assets/.secret":     {Data: []byte("token")},
+	".env":               {Data: []byte("SECRET=1")},
+	"docs/index.html":    {Data: []byte("<h1>Docs</h1>")},
+	"docs/guide/a.txt":   {Data: []byte("a")},
+	".git/config":        {Data: []byte("[core]")},
+	"images/.DS_Store":   {Data: []byte("junk")},
+	"images/logo.png":    {Data: []byte("png")},
+	"images/sub/pic.png": {Data: []byte("png")},
+}
+
+func Test_FileServer_Directories_NotListedByDefault(t *testing.T) {
+	server := newStaticServer(spaFiles)
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/app/images/", http.StatusNotFound, ""},
+		{"/app/docs/", http.StatusOK, "<h1>Docs</h1>"},
+		{"/app/images/logo.png", http.StatusOK, "png"},
+		{"/app/.env", http.StatusNotFound, ""},
+		{"/app/.git/config", http.StatusNotFound, ""},
+		{"/app/assets/.secret", http.StatusNotFound, ""},
+		{"/app/missing", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("%s = %d %q, want %d containing %q", tt.path, rec.Code, rec.Body.String(), tt.status, tt.body)
+		}
+		if tt.status == http.StatusNotFound && rec.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s: Content-Type = %q, want problem details", tt.path, rec.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func Test_FileServer_Redirects_MatchFileServer(t *testing.T) {
+	server := newStaticServer(spaFiles)
+
+	tests := []struct {
+		path     string
+		location string
+	}{
+		{"/app/docs", "docs/"},
+		{"/app/docs/index.html", "./"},
+		{"/app/images/logo.png/", "../logo.png"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != tt.location {
+			t.Errorf("%s = %d to %q, want 301 to %q", tt.path, rec.Code, rec.Header().Get("Location"), tt.location)
+		}
+	}
+}
+
+func Test_FileServer_DirectoryListings_ListsWithoutDotfiles(t *testing.T) {
+	server := newStaticServer(spaFiles, httpserver.DirectoryListings())
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/app/images/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{`<a href="logo.png">logo.png</a>`, `<a href="sub/">sub/</a>`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Listing missing %q\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "DS_Store") {
+		t.Errorf("Listing shows a dot file\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/app/.git/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Listing a dot directory = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func Test_FileServer_SPAFallback_ServesIndexForClientRoutes(t *testing.T) {
+	server := newStaticServer(spaFiles, httpserver.SPAFallback())
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{http.MethodGet, "/app/chirps/42", http.StatusOK, "<div id=app></div>"},
+		{http.MethodGet, "/app/settings", http.StatusOK, "<div id=app></div>"},
+		{http.MethodGet, "/app/assets/app.js", http.StatusOK, "render()"},
+		{http.MethodGet, "/app/assets/missing.js", http.StatusNotFound, ""},
+		{http.MethodGet, "/app/.env", http.StatusNotFound, ""},
+		{http.MethodPost, "/app/chirps/42", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("%s %s = %d %q, want %d containing %q", tt.method, tt.path, rec.Code, rec.Body.String(), tt.status, tt.body)
+		}
+	}
+}
+
+// Phase 21: Admin Route Protection Tests
+
+// getAdmin sends GET path to server with the given Authorization header
+func getAdmin(server *httpserver.Server, path, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec
+}
+
+func Test_requireAdmin_Credentials_DecideAccess(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithJWTSecret(testJWTSecret),
+		httpserver.WithAdminAPIKey(testAdminKey),
+	)
+	adminToken, err := auth.MakeJWTWithRole("admin-1", auth.RoleAdmin, testJWTSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWTWithRole error: %v", err)
+	}
+	userToken, err := auth.MakeJWT("user-1", testJWTSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT error: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		code          string
+	}{
+		{"no credentials", "", http.StatusUnauthorized, "unauthorized"},
+		{"bad token", "Bearer not-a-jwt", http.StatusUnauthorized, "invalid_token"},
+		{"wrong api key", "ApiKey nope", http.StatusUnauthorized, "invalid_token"},
+		{"user token", "Bearer " + userToken, http.StatusForbidden, "forbidden"},
+		{"admin token", "Bearer " + adminToken, http.StatusOK, ""},
+		{"api key", "ApiKey " + testAdminKey, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := getAdmin(server, "/admin/metrics/prometheus", tt.authorization)
+			if rec.Code != tt.status {
+				t.Errorf("Status code = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.code != "" && !strings.Contains(rec.Body.String(), `"code":"`+tt.code+`"`) {
+				t.Errorf("Body = %s, want code %s", rec.Body.String(), tt.code)
+			}
+		})
+	}
+}
+
+func Test_requireAdmin_NoAPIKeyConfigured_RejectsAPIKeys(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler())
+	if rec := getAdmin(server, "/admin/metrics/prometheus", "ApiKey "); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Empty API key: status code = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := getAdmin(server, "/admin/metrics/prometheus", "ApiKey anything"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func Test_requireAdmin_CoversRoutesAddedThroughMux(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithAdminAPIKey(testAdminKey))
+	server.Mux().HandleFunc("POST /admin/purge", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/purge", nil)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Without credentials: status code = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/purge", nil)
+	req.Header.Set("Authorization", "ApiKey "+testAdminKey)
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("With API key: status code = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
+
+func Test_WithAdmins_LoginIssuesAdminToken(t *testing.T) {
+	users := store.NewMemoryUserStore()
+	hash, err := fastPasswordParams.Hash("s3cret")
+	if err != nil {
+		t.Fatalf("Hash error: %v", err)
+	}
+	admin, err := users.Create(context.Background(), store.User{Email: "ops@example.com", HashedPassword: hash})
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+	admins := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithPasswordParams(fastPasswordParams),
+		httpserver.WithUserStore(users),
+		httpserver.WithAdmins(admin.ID),
+	)
+	rec := postWithBearer(admins, "/api/login", "", `{"email":"ops@example.com","password":"s3cret"}`)
+	var tokens loginTokens
+	if err := json.Unmarshal(rec.Body.Bytes(), &tokens); err != nil || tokens.Token == "" {
+		t.Fatalf("Login body = %s (%v)", rec.Body.String(), err)
+	}
+	if rec := getAdmin(admins, "/admin/metrics/prometheus", "Bearer "+tokens.Token); rec.Code != http.StatusOK {
+		t.Errorf("Admin login: status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// The refreshed access token keeps the role
+	rec = postWithBearer(admins, "/api/refresh", tokens.RefreshToken, "")
+	var refreshed loginTokens
+	if err := json.Unmarshal(rec.Body.Bytes(), &refreshed); err != nil || refreshed.Token == "" {
+		t.Fatalf("Refresh body = %s (%v)", rec.Body.String(), err)
+	}
+	if rec := getAdmin(admins, "/admin/metrics/prometheus", "Bearer "+refreshed.Token); rec.Code != http.StatusOK {
+		t.Errorf("Refreshed token: status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// Admin status follows the user ID, so no email registered later gets it
+	tokens = registerAndLogin(t, admins)
+	if rec := getAdmin(admins, "/admin/metrics/prometheus", "Bearer "+tokens.Token); rec.Code != http.StatusForbidden {
+		t.Errorf("Regular login: status code = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func Test_requireAdmin_WritesAuditLog(t *testing.T) {
+	log := openAuditLog(t)
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithAdminAPIKey(testAdminKey),
+		httpserver.WithAuditLog(log),
+	)
+
+	getAdmin(server, "/admin/metrics/prometheus", "")
+	getAdmin(server, "/admin/metrics/prometheus", "ApiKey "+testAdminKey)
+	getAdmin(server, "/api/healthz", "")
+	req := httptest.NewRequest(http.MethodPost, "/admin/reset", nil)
+	req.Header.Set("Authorization", "ApiKey "+testAdminKey)
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries, err := log.Query(audit.Filter{})
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Got %d audit entries, want 2 (allowed reads and non-admin routes aren't audited): %+v", len(entries), entries)
+	}
+	if e := entries[0]; e.Actor != "anonymous" || e.Details["allowed"] != "false" || e.Details["status"] != "401" {
+		t.Errorf("First entry = %+v, want a denied 401", e)
+	}
+	if e := entries[1]; e.Actor != "api-key" || e.Details["allowed"] != "true" || e.Details["method"] != "POST" || e.Target != "/admin/reset" || e.RequestID == "" {
+		t.Errorf("Second entry = %+v, want an allowed api-key POST", e)
+	}
+}
+
+func Test_requireAdmin_RepeatedDenials_CollapsedInAuditLog(t *testing.T) {
+	log := openAuditLog(t)
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithAdminAPIKey(testAdminKey),
+		httpserver.WithAuditLog(log),
+	)
+	send := func(remoteAddr, authorization string) {
+		req := httptest.NewRequest(http.MethodGet, "/admin/metrics/prometheus", nil)
+		req.RemoteAddr = remoteAddr
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for range 50 {
+		send("192.0.2.1:1234", "")
+		send("[2001:db8::1]:1234", "ApiKey wrong")
+		send("[2001:db8::2]:1234", "")
+	}
+	send("192.0.2.1:1234", "ApiKey "+testAdminKey)
+	send("192.0.2.2:1234", "")
+
+	entries, err := log.Query(audit.Filter{})
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Details["client_ip"]+" "+e.Details["allowed"])
+	}
+	// Addresses in the same IPv6 /64 count as one client
+	want := []string{"192.0.2.1 false", "2001:db8::1 false", "192.0.2.2 false"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Audited %q, want %q", got, want)
+	}
+}
+
+func Test_deniedAudit_OneEntryPerClientAndWindow(t *testing.T) {
+	now := time.Unix(0, 0)
+	denied := httpserver.NewDeniedAuditForTest(func() time.Time { return now })
+
+	if ok, _ := denied.Allow("a"); !ok {
+		t.Fatal("First denial should be audited")
+	}
+	for range 3 {
+		if ok, _ := denied.Allow("a"); ok {
+			t.Fatal("Denials inside the window should be left out")
+		}
+	}
+	if ok, _ := denied.Allow("b"); !ok {
+		t.Error("Other clients should be audited separately")
+	}
+
+	now = now.Add(time.Minute)
+	ok, suppressed := denied.Allow("a")
+	if !ok || suppressed != 3 {
+		t.Errorf("Allow after the window = %v, %d; want the entry to report 3 left out", ok, suppressed)
+	}
+
+	if got := httpserver.ClientBlockForTest("2001:db8:0:0:abcd::1"); got != "2001:db8::/64" {
+		t.Errorf("IPv6 key = %q, want its /64", got)
+	}
+}
+
+func Test_OpenAPI_AdminRoutes_DocumentAdminSecurity(t *testing.T) {
+	doc := fetchOpenAPI(t, httpserver.NewWithConfig(http.NotFoundHandler()))
+
+	op := lookup(t, doc, "paths", "/admin/metrics/prometheus", "get").(map[string]any)
+	if security, _ := json.Marshal(op["security"]); string(security) != `[{"bearerAuth":[]},{"adminApiKey":[]}]` {
+		t.Errorf("security = %s, want bearer token or admin API key", security)
+	}
+	lookup(t, op, "responses", "401")
+	lookup(t, op, "responses", "403")
+	lookup(t, doc, "components", "securitySchemes", "adminApiKey")
+}
+
+// Phase 22: Audit Log Tests
+
+// openAuditLog opens an audit log in a temporary directory
+func openAuditLog(t *testing.T) *audit.Log {
+	t.Helper()
+	log, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatalf("audit.Open error: %v", err)
+	}
+	t.Cleanup(func() { log.Close() })
+	return log
+}
+
+// newAuditedServer returns a chirp server that records to a fresh audit log
+func newAuditedServer(t *testing.T) (*httpserver.Server, *store.MemoryChirpStore, *audit.Log) {
+	t.Helper()
+	chirps := store.NewMemoryChirpStore()
+	log := openAuditLog(t)
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithChirpStore(chirps),
+		httpserver.WithJWTSecret(testJWTSecret),
+		httpserver.WithAdminAPIKey(testAdminKey),
+		httpserver.WithAuditLog(log),
+	)
+	return server, chirps, log
+}
+
+func Test_Audit_ChirpModeration_IsRecorded(t *testing.T) {
+	server, chirps, log := newAuditedServer(t)
+
+	for _, body := range []string{"What a kerfuffle", "Nothing to see"} {
+		req := authorize(t, httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(`{"body":"`+body+`"}`)), "user-1")
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Create %q: status code = %d", body, rec.Code)
+		}
+	}
+	list, _ := chirps.List(context.Background())
+	req := authorize(t, httptest.NewRequest(http.MethodDelete, "/api/chirps/"+list[1].ID, nil), "user-1")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Delete: status code = %d", rec.Code)
+	}
+
+	entries, err := log.Query(audit.Filter{})
+	if err != nil {
+		t.Fatalf("Query error: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Got %d entries, want a cleaned chirp and a deletion: %+v", len(entries), entries)
+	}
+	cleaned := entries[0]
+	if cleaned.Action != audit.ActionChirpCleaned || cleaned.Actor != "user-1" || cleaned.Target != list[0].ID ||
+		cleaned.Details["original"] != "What a kerfuffle" || cleaned.Details["cleaned"] != "What a ****" {
+		t.Errorf("Cleaned entry = %+v", cleaned)
+	}
+	deleted := entries[1]
+	if deleted.Action != audit.ActionChirpDelete || deleted.Actor != "user-1" || deleted.Target != list[1].ID || deleted.Details["body"] != "Nothing to see" {
+		t.Errorf("Delete entry = %+v", deleted)
+	}
+}
+
+func Test_Audit_Endpoint_FiltersEntries(t *testing.T) {
+	server, _, log := newAuditedServer(t)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, e := range []audit.Entry{
+		{Actor: "alice", Action: audit.ActionChirpDelete},
+		{Actor: "bob", Action: audit.ActionChirpDelete},
+		{Actor: "alice", Action: audit.ActionChirpCleaned},
+		{Actor: "alice", Action: audit.ActionChirpDelete},
+	} {
+		e.Time = base.Add(time.Duration(i) * time.Hour)
+		if _, err := log.Record(e); err != nil {
+			t.Fatalf("Record error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []int64
+	}{
+		{"?actor=alice", []int64{1, 3, 4}},
+		{"?actor=alice&action=chirp.delete", []int64{1, 4}},
+		{"?since=2026-01-01T01:00:00Z&until=2026-01-01T03:00:00Z", []int64{2, 3}},
+		{"?action=chirp.delete&limit=2", []int64{2, 4}},
+	}
+	for _, tt := range tests {
+		rec := getAdmin(server, "/admin/audit"+tt.query, "ApiKey "+testAdminKey)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status code = %d, body %s", tt.query, rec.Code, rec.Body.String())
+		}
+		var entries []audit.Entry
+		if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+			t.Fatalf("%s: decode error: %v", tt.query, err)
+		}
+		var got []int64
+		for _, e := range entries {
+			got = append(got, e.Seq)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got entries %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func Test_Audit_Endpoint_InvalidQuery_Returns400(t *testing.T) {
+	server, _, _ := newAuditedServer(t)
+
+	for _, query := range []string{"?since=yesterday", "?until=2026-01-01", "?limit=0", "?limit=5000", "?limit=x"} {
+		rec := getAdmin(server, "/admin/audit"+query, "ApiKey "+testAdminKey)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status code = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+		if !strings.Contains(rec.Body.String(), `"code":"invalid_query"`) {
+			t.Errorf("%s: body = %s, want code invalid_query", query, rec.Body.String())
+		}
+	}
+}
+
+func Test_Audit_Endpoint_WithoutLog_Returns404(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithAdminAPIKey(testAdminKey))
+
+	if rec := getAdmin(server, "/admin/audit", "ApiKey "+testAdminKey); rec.Code != http.StatusNotFound {
+		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+// Phase 23: Health Check Tests
+
+// getHealth requests path from server and decodes the health report
+func getHealth(t *testing.T, server *httpserver.Server, path string) (int, httpserver.HealthReport) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var report httpserver.HealthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("%s: decode error: %v; body %s", path, err, rec.Body.String())
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("%s: Cache-Control = %q, want no-store", path, cc)
+	}
+	return rec.Code, report
+}
+
+// checkResult returns the result named name from report
+func checkResult(t *testing.T, report httpserver.HealthReport, name string) httpserver.HealthCheckResult {
+	t.Helper()
+	for _, result := range report.Checks {
+		if result.Name == name {
+			return result
+		}
+	}
+	t.Fatalf("No %q check in %+v", name, report.Checks)
+	return httpserver.HealthCheckResult{}
+}
+
+func Test_livez_readyz_Healthy_Return200(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler())
+
+	code, report := getHealth(t, server, "/api/livez")
+	if code != http.StatusOK || report.Status != httpserver.HealthOK || len(report.Checks) != 0 {
+		t.Errorf("livez = %d %+v, want 200 ok with no checks", code, report)
+	}
+
+	code, report = getHealth(t, server, "/api/readyz")
+	if code != http.StatusOK || report.Status != httpserver.HealthOK {
+		t.Errorf("readyz = %d %+v, want 200 ok", code, report)
+	}
+	for _, name := range []string{"shutdown", "profanity_list"} {
+		if result := checkResult(t, report, name); result.Status != httpserver.HealthOK || result.LatencyMS < 0 {
+			t.Errorf("%s check = %+v, want ok", name, result)
+		}
+	}
+}
+
+func Test_readyz_FailingCheck_Returns503WithDetail(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler())
+	server.Health().AddLivenessCheck("heartbeat", func(ctx context.Context) error { return nil })
+	server.Health().AddReadinessCheck("queue", func(ctx context.Context) error { return errors.New("queue is full") })
+
+	code, report := getHealth(t, server, "/api/livez")
+	if code != http.StatusOK || len(report.Checks) != 1 || report.Checks[0].Name != "heartbeat" {
+		t.Errorf("livez = %d %+v, want 200 with only the liveness check", code, report)
+	}
+
+	code, report = getHealth(t, server, "/api/readyz")
+	if code != http.StatusServiceUnavailable || report.Status != httpserver.HealthFail {
+		t.Errorf("readyz = %d %+v, want 503 fail", code, report)
+	}
+	if result := checkResult(t, report, "queue"); result.Status != httpserver.HealthFail || result.Error != "queue is full" {
+		t.Errorf("queue check = %+v, want its error", result)
+	}
+	if result := checkResult(t, report, "heartbeat"); result.Status != httpserver.HealthOK {
+		t.Errorf("heartbeat check = %+v, want ok", result)
+	}
+}
+
+func Test_readyz_SlowCheck_TimesOut(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler())
+	server.Health().AddReadinessCheck("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	code, report := getHealth(t, server, "/api/readyz")
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Status code = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	if result := checkResult(t, report, "slow"); result.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("slow check = %+v, want a deadline error", result)
+	}
+}
+
+func Test_readyz_AfterShutdown_Returns503(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler())
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+
+	code, report := getHealth(t, server, "/api/readyz")
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Status code = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	if result := checkResult(t, report, "shutdown"); result.Status != httpserver.HealthFail {
+		t.Errorf("shutdown check = %+v, want fail", result)
+	}
+	// The process is still healthy while it drains
+	if code, _ := getHealth(t, server, "/api/livez"); code != http.StatusOK {
+		t.Errorf("livez status code = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func Test_readyz_FailedProfanityReload_Returns503(t *testing.T) {
+	fail := false
+	filter, err := httpserver.NewProfanityFilter(func() ([]httpserver.ProfanityWord, error) {
+		if fail {
+			return nil, errors.New("list unreadable")
+		}
+		return httpserver.DefaultProfanityWords, nil
+	})
+	if err != nil {
+		t.Fatalf("NewProfanityFilter error: %v", err)
+	}
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithProfanityFilter(filter))
+
+	fail = true
+	filter.Reload()
+	code, report := getHealth(t, server, "/api/readyz")
+	if result := checkResult(t, report, "profanity_list"); code != http.StatusServiceUnavailable || !strings.Contains(result.Error, "list unreadable") {
+		t.Errorf("readyz = %d %+v, want 503 with the reload error", code, result)
+	}
+
+	fail = false
+	filter.Reload()
+	if code, _ := getHealth(t, server, "/api/readyz"); code != http.StatusOK {
+		t.Errorf("After a good reload status code = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func Test_readyz_FileStoreDirectoryGone_Returns503(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatalf("Mkdir error: %v", err)
+	}
+	chirps, err := store.NewFileChirpStore(filepath.Join(dir, "chirps.json"))
+	if err != nil {
+		t.Fatalf("NewFileChirpStore error: %v", err)
+	}
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithChirpStore(chirps))
+
+	if code, report := getHealth(t, server, "/api/readyz"); code != http.StatusOK || checkResult(t, report, "chirps").Status != httpserver.HealthOK {
+		t.Fatalf("readyz = %d %+v, want 200 with a passing chirps check", code, report)
+	}
+
+	os.RemoveAll(dir)
+	code, report := getHealth(t, server, "/api/readyz")
+	if code != http.StatusServiceUnavailable || checkResult(t, report, "chirps").Status != httpserver.HealthFail {
+		t.Errorf("readyz = %d %+v, want 503 with a failing chirps check", code, report)
+	}
+}
+
+// Phase 24: Shutdown Hook and Draining Tests
+
+// freeAddr returns a loopback address with a port that was free a moment ago
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startServer runs server in the background and waits until it accepts connections
+func startServer(t *testing.T, server *httpserver.Server, addr string) {
+	t.Helper()
+	go server.ListenAndServe()
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Server on %s didn't start", addr)
+}
+
+func Test_Shutdown_HooksRunInOrderAfterInFlightRequests(t *testing.T) {
+	addr := freeAddr(t)
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithAddr(addr))
+	started := make(chan struct{})
+	server.Mux().HandleFunc("GET /slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	var mu sync.Mutex
+	var events []string
+	event := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, name)
+	}
+	for _, name := range []string{"first", "second", "third"} {
+		server.OnShutdown(name, time.Second, func(ctx context.Context) error {
+			event(name)
+			return nil
+		})
+	}
+	startServer(t, server, addr)
+
+	responded := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "done" {
+				err = fmt.Errorf("body = %q", body)
+			}
+		}
+		event("response")
+		responded <- err
+	}()
+	<-started
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+	if err := <-responded; err != nil {
+		t.Errorf("In-flight request failed: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"response", "first", "second", "third"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("Events = %v, want %v", events, want)
+	}
+}
+
+func Test_Shutdown_HookTimeout_ReportedAndLaterHooksRun(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler())
+	block := make(chan struct{})
+	defer close(block)
+	server.OnShutdown("stuck", 50*time.Millisecond, func(ctx context.Context) error {
+		<-block // ignores ctx
+		return nil
+	})
+	server.OnShutdown("failing", 0, func(ctx context.Context) error {
+		return errors.New("flush failed")
+	})
+	ran := false
+	server.OnShutdown("last", 0, func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+
+	start := time.Now()
+	err := server.Shutdown(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took %s, want the stuck hook abandoned after its timeout", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "shutdown hook stuck") ||
+		!strings.Contains(err.Error(), "shutdown hook failing: flush failed") {
+		t.Errorf("Shutdown error = %v, want both hook errors", err)
+	}
+	if !ran {
+		t.Error("Expected the hook after the failures to run")
+	}
+}
+
+func Test_Shutdown_CalledTwice_RunsHooksOnce(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler())
+	calls := 0
+	server.OnShutdown("count", 0, func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := server.Shutdown(context.Background()); err != nil {
+			t.Errorf("Shutdown %d error: %v", i+1, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Hook ran %d times, want 1", calls)
+	}
+}
+
+func Test_Shutdown_DrainPeriod_KeepsServingWhileNotReady(t *testing.T) {
+	addr := freeAddr(t)
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithAddr(addr),
+		httpserver.WithDrainPeriod(300*time.Millisecond),
+	)
+	startServer(t, server, addr)
+
+	done := make(chan error, 1)
+	go func() { done <- server.Shutdown(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+
+	resp, err := http.Get("http://" + addr + "/api/readyz")
+	if err != nil {
+		t.Fatalf("readyz during the drain period: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("readyz status code = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	resp, err = http.Get("http://" + addr + "/api/healthz")
+	if err != nil {
+		t.Fatalf("healthz during the drain period: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("healthz status code = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown error: %v", err)
+	}
+	if _, err := http.Get("http://" + addr + "/api/healthz"); err == nil {
+		t.Error("Expected the server to stop accepting connections after the drain period")
+	}
+}
+
+func Test_Close_CutsOffInFlightRequests(t *testing.T) {
+	addr := freeAddr(t)
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithAddr(addr))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	server.Mux().HandleFunc("GET /hang", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	hookRan := false
+	server.OnShutdown("hook", 0, func(ctx context.Context) error {
+		hookRan = true
+		return nil
+	})
+	startServer(t, server, addr)
+
+	failed := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/hang")
+		if err == nil {
+			resp.Body.Close()
+		}
+		failed <- err
+	}()
+	<-started
+
+	server.Close()
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Error("Expected the in-flight request to fail")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close didn't cut off the in-flight request")
+	}
+	if hookRan {
+		t.Error("Close ran a shutdown hook")
+	}
+}
+
+// Phase 25: Listener Handoff Tests
+
+func Test_Serve_GivenListener_ServesRequests(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	server := httpserver.NewWithConfig(http.NotFoundHandler())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(l) }()
+
+	resp, err := http.Get("http://" + l.Addr().String() + "/api/healthz")
+	if err != nil {
+		t.Fatalf("healthz error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("healthz status code = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown error: %v", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Serve error = %v, want %v", err, http.ErrServerClosed)
+	}
+}
+
+func Test_HandOff_SkipsDrainPeriod(t *testing.T) {
+	addr := freeAddr(t)
+	server := httpserver.NewWithConfig(http.NotFoundHandler(),
+		httpserver.WithAddr(addr),
+		httpserver.WithDrainPeriod(5*time.Second),
+	)
+	hookRan := false
+	server.OnShutdown("hook", 0, func(ctx context.Context) error {
+		hookRan = true
+		return nil
+	})
+	startServer(t, server, addr)
+
+	start := time.Now()
+	if err := server.HandOff(context.Background()); err != nil {
+		t.Fatalf("HandOff error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("HandOff took %s, want the drain period skipped", elapsed)
+	}
+	if !hookRan {
+		t.Error("Expected HandOff to run the shutdown hooks")
+	}
+}
+
+func Test_ListenAndServe_AfterShutdown_ReturnsServerClosed(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithAddr(freeAddr(t)))
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("ListenAndServe error = %v, want %v", err, http.ErrServerClosed)
+	}
+}
+
+// Phase 26: Chirp Stream Tests
+
+// sseEvent is one event read from a Server-Sent Events stream
+type sseEvent struct {
+	id, event, data string
+	// heartbeats counts the comment lines read before the event
+	heartbeats int
+}
+
+// startStreamServer runs a chirp server with a small stream buffer and fast heartbeats
+// and returns it with its address
+func startStreamServer(t *testing.T, opts ...httpserver.Option) (*httpserver.Server, string) {
+	t.Helper()
+	addr := freeAddr(t)
+	opts = append([]httpserver.Option{
+		httpserver.WithAddr(addr),
+		httpserver.WithJWTSecret(testJWTSecret),
+		httpserver.WithChirpStream(2, 50*time.Millisecond),
+	}, opts...)
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), opts...)
+	startServer(t, server, addr)
+	t.Cleanup(func() { server.Close() })
+	return server, addr
+}
+
+// openChirpStream connects to /api/chirps/stream with query and, unless empty, a
+// Last-Event-ID header
+func openChirpStream(t *testing.T, addr, query, lastEventID string) *bufio.Reader {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/api/chirps/stream"+query, nil)
+	if err != nil {
+		t.Fatalf("NewRequest error: %v", err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("Stream request error: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Stream status code = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// nextEvent reads the next event from stream, counting the comments before it
+func nextEvent(t *testing.T, stream *bufio.Reader) sseEvent {
+	t.Helper()
+	var e sseEvent
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Reading stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if e.event != "" {
+				return e
+			}
+		case strings.HasPrefix(line, ":"):
+			e.heartbeats++
+		default:
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "id":
+				e.id = value
+			case "event":
+				e.event = value
+			case "data":
+				e.data = value
+			}
+		}
+	}
+}
+
+// postChirp creates a chirp by userID on server
+func postChirp(t *testing.T, server *httpserver.Server, userID, body string) {
+	t.Helper()
+	req := authorize(t, httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(`{"body":"`+body+`"}`)), userID)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Create chirp status code = %d, want %d", rec.Code, http.StatusCreated)
+	}
+}
+
+func Test_ChirpStream_NewChirp_SentAsEventAfterServerTimeouts(t *testing.T) {
+	// The stream has to outlive read and write timeouts meant for ordinary requests
+	server, addr := startStreamServer(t, httpserver.WithTimeouts(100*time.Millisecond, 100*time.Millisecond, time.Second))
+	stream := openChirpStream(t, addr, "", "")
+
+	time.Sleep(300 * time.Millisecond)
+	postChirp(t, server, "user-1", "Hello, stream")
+
+	e := nextEvent(t, stream)
+	if !strings.HasSuffix(e.id, "-1") || e.event != "chirp" {
+		t.Errorf("Event id = %q, event = %q; want <epoch>-1 and chirp", e.id, e.event)
+	}
+	var chirp chirpJSON
+	if err := json.Unmarshal([]byte(e.data), &chirp); err != nil {
+		t.Fatalf("Event data %q is not a chirp: %v", e.data, err)
+	}
+	if chirp.Body != "Hello, stream" || chirp.UserID != "user-1" || chirp.ID == "" {
+		t.Errorf("Event chirp = %+v", chirp)
+	}
+	if e.heartbeats == 0 {
+		t.Error("Expected heartbeats while the stream was idle")
+	}
+}
+
+func Test_ChirpStream_Filters_SelectChirps(t *testing.T) {
+	server, addr := startStreamServer(t)
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"author", "?author_id=user-2", []string{"Hello #go", "No tags here"}},
+		{"hashtag is case-insensitive", "?hashtag=%23GO", []string{"#Go rocks", "Hello #go"}},
+		{"author and hashtag", "?author_id=user-2&hashtag=go", []string{"Hello #go"}},
+	}
+	streams := make([]*bufio.Reader, len(tests))
+	for i, tt := range tests {
+		streams[i] = openChirpStream(t, addr, tt.query, "")
+	}
+
+	postChirp(t, server, "user-1", "#Go rocks")
+	postChirp(t, server, "user-1", "#gopher only")
+	postChirp(t, server, "user-2", "Hello #go")
+	postChirp(t, server, "user-2", "No tags here")
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for range tt.want {
+				var chirp chirpJSON
+				json.Unmarshal([]byte(nextEvent(t, streams[i]).data), &chirp)
+				got = append(got, chirp.Body)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Got chirps %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ChirpStream_LastEventID_ResumesFromBuffer(t *testing.T) {
+	server, addr := startStreamServer(t)
+	live := openChirpStream(t, addr, "", "")
+	for _, body := range []string{"one", "two", "three"} {
+		postChirp(t, server, "user-1", body)
+	}
+	// Event IDs are the process's epoch and a sequence number
+	epoch, seq, _ := strings.Cut(nextEvent(t, live).id, "-")
+	if seq != "1" {
+		t.Fatalf("First event sequence number = %q, want 1", seq)
+	}
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		want        []string
+	}{
+		// The buffer holds two chirps, so the first one is gone
+		{"behind the buffer", epoch + "-0", []string{"2", "3"}},
+		{"inside the buffer", epoch + "-2", []string{"3"}},
+		{"past the last event", epoch + "-99", []string{"2", "3"}},
+		{"from an earlier process", "0-2", []string{"2", "3"}},
+		{"no header", "", nil},
+		{"not an event ID", "abc", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := openChirpStream(t, addr, "", tt.lastEventID)
+			var got []string
+			for range tt.want {
+				got = append(got, strings.TrimPrefix(nextEvent(t, stream).id, epoch+"-"))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Replayed event sequence numbers %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	stream := openChirpStream(t, addr, "", epoch+"-3")
+	postChirp(t, server, "user-1", "four")
+	if e := nextEvent(t, stream); e.id != epoch+"-4" {
+		t.Errorf("Event id = %q after catching up, want %s-4", e.id, epoch)
+	}
+}
+
+func Test_ChirpStream_DeletedChirp_NotReplayed(t *testing.T) {
+	server, addr := startStreamServer(t)
+	live := openChirpStream(t, addr, "", "")
+	postChirp(t, server, "user-1", "keep")
+	postChirp(t, server, "user-1", "regret")
+	nextEvent(t, live)
+	var regret chirpJSON
+	json.Unmarshal([]byte(nextEvent(t, live).data), &regret)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, authorize(t, httptest.NewRequest(http.MethodDelete, "/api/chirps/"+regret.ID, nil), "user-1"))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Delete status code = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	// Replay everything buffered, then a new chirp, which has to come right after "keep"
+	stream := openChirpStream(t, addr, "", "0-0")
+	postChirp(t, server, "user-1", "after")
+	var got []string
+	for range 2 {
+		var chirp chirpJSON
+		json.Unmarshal([]byte(nextEvent(t, stream).data), &chirp)
+		got = append(got, chirp.Body)
+	}
+	if want := []string{"keep", "after"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got chirps %q, want %q", got, want)
+	}
+}
+
+func Test_ChirpStream_InvalidHashtag_Returns400(t *testing.T) {
+	server, _ := newChirpServer(t)
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps/stream?hashtag=no+spaces", nil)
+	rec := httptest.NewRecorder()
+
+	server.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), httpserver.CodeInvalidQuery) {
+		t.Errorf("Got %d %s, want 400 %s", rec.Code, rec.Body.String(), httpserver.CodeInvalidQuery)
+	}
+}
+
+// connectChirpStream opens /api/chirps/stream through a proxy for the client at
+// forwardedFor; the body is closed when the test ends
+func connectChirpStream(t *testing.T, addr, forwardedFor string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/api/chirps/stream", nil)
+	if err != nil {
+		t.Fatalf("NewRequest error: %v", err)
+	}
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("Stream request error: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func Test_ChirpStream_OverLimits_Returns503UntilAStreamCloses(t *testing.T) {
+	_, addr := startStreamServer(t,
+		httpserver.WithStreamLimits(3, 2),
+		httpserver.WithTrustedProxies(netip.MustParsePrefix("127.0.0.0/8")),
+	)
+
+	tests := []struct {
+		name   string
+		client string
+		want   int
+	}{
+		{"first from client", "192.0.2.1", http.StatusOK},
+		{"second from client", "192.0.2.1", http.StatusOK},
+		{"over the client limit", "192.0.2.1", http.StatusServiceUnavailable},
+		{"other client", "192.0.2.2", http.StatusOK},
+		{"over the total limit", "192.0.2.3", http.StatusServiceUnavailable},
+	}
+	var first *http.Response
+	for _, tt := range tests {
+		resp := connectChirpStream(t, addr, tt.client)
+		if first == nil {
+			first = resp
+		}
+		if resp.StatusCode != tt.want {
+			t.Fatalf("%s: status code = %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+		if tt.want == http.StatusServiceUnavailable {
+			body, _ := io.ReadAll(resp.Body)
+			if !strings.Contains(string(body), httpserver.CodeUnavailable) {
+				t.Errorf("%s: body = %s, want code %s", tt.name, body, httpserver.CodeUnavailable)
+			}
+		}
+	}
+
+	// The server notices the closed connection asynchronously
+	first.Body.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for connectChirpStream(t, addr, "192.0.2.1").StatusCode != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a closed stream to free its place")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_Shutdown_EndsChirpStreams(t *testing.T) {
+	// No heartbeats, so the stream sits idle past its last write deadline
+	server, addr := startStreamServer(t,
+		httpserver.WithTimeouts(time.Second, 100*time.Millisecond, time.Second),
+		httpserver.WithChirpStream(2, time.Minute),
+	)
+	stream := openChirpStream(t, addr, "", "")
+	time.Sleep(300 * time.Millisecond)
+
+	start := time.Now()
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took %s, want open streams ended at once", elapsed)
+	}
+	if _, err := io.ReadAll(stream); err != nil {
+		t.Errorf("Stream ended with %v, want a clean end", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps/stream", nil)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Stream after shutdown status code = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,139 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ShepBook/chirpy/internal/metrics"
+)
+
+// Chirp validation outcomes recorded by chirpy_chirp_validations_total
+const (
+	outcomeAccepted    = "accepted"
+	outcomeCleaned     = "cleaned"
+	outcomeTooLong     = "too_long"
+	outcomeInvalidJSON = "invalid_json"
+)
+
+// serverMetrics are the metrics a Server records about itself
+type serverMetrics struct {
+	registry         *metrics.Registry
+	requests         *metrics.CounterVec
+	duration         *metrics.HistogramVec
+	inFlight         *metrics.Gauge
+	chirpValidations *metrics.CounterVec
+	panics           *metrics.Counter
+	rateLimited      *metrics.CounterVec
+	notModified      *metrics.CounterVec
+}
+
+func newServerMetrics(registry *metrics.Registry) *serverMetrics {
+	return &serverMetrics{
+		registry: registry,
+		requests: registry.NewCounterVec("chirpy_http_requests_total",
+			"HTTP requests handled, by method, route pattern and status code.",
+			"method", "route", "code"),
+		duration: registry.NewHistogramVec("chirpy_http_request_duration_seconds",
+			"Time spent handling HTTP requests, by method and route pattern.",
+			metrics.DefBuckets, "method", "route"),
+		inFlight: registry.NewGauge("chirpy_http_requests_in_flight",
+			"HTTP requests currently being handled."),
+		chirpValidations: registry.NewCounterVec("chirpy_chirp_validations_total",
+			"Chirps run through validation, by outcome.",
+			"outcome"),
+		panics: registry.NewCounter("chirpy_http_panics_total",
+			"Panics recovered while handling HTTP requests."),
+		rateLimited: registry.NewCounterVec("chirpy_rate_limited_requests_total",
+			"Requests rejected with 429 by the rate limiter, by route group.",
+			"group"),
+		notModified: registry.NewCounterVec("chirpy_http_not_modified_total",
+			"Conditional requests answered with 304 because the client's cached copy is current, by route pattern.",
+			"route"),
+	}
+}
+
+// instrument records request counts, status codes, latency and in-flight requests.
+// Requests are labelled with the ServeMux pattern that matched rather than the raw
+// path, and with a known method or "other", so clients can't blow up the number of series.
+func (m *serverMetrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		start := time.Now()
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		method := metricMethod(r.Method)
+		m.requests.WithLabelValues(method, route, strconv.Itoa(rec.status)).Inc()
+		if rec.status == http.StatusNotModified {
+			m.notModified.WithLabelValues(route).Inc()
+		}
+		m.duration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// metricMethod returns method if it is a standard HTTP method and "other" if not
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// statusRecorder remembers the status code and body size written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	return &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Flush keeps streaming responses working through the recorder
+func (rec *statusRecorder) Flush() {
+	rec.wroteHeader = true
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+// Hijack keeps connection upgrades working through the recorder
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http: response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
@@ -0,0 +1,293 @@
+package http
+
+import (
+	_ "embed"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// openAPIVersion is the version of the Chirpy API described by /api/openapi.json
+const openAPIVersion = "1.0.0"
+
+//go:embed openapi_explorer.html
+var openAPIExplorer []byte
+
+// handleOpenAPI serves the OpenAPI document for every documented route, including
+// routes added through Mux() after the server was created
+func (server *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, buildOpenAPI(server.mux.Routes()))
+}
+
+// handleAPIExplorer serves a small HTML page that renders /api/openapi.json
+func handleAPIExplorer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPIExplorer)
+}
+
+// wildcardPattern matches ServeMux wildcards: {name}, {name...} and {$}
+var wildcardPattern = regexp.MustCompile(`\{([^}.]*)(\.\.\.)?\}`)
+
+// buildOpenAPI generates an OpenAPI 3.1 document from routes. Undocumented routes are left out.
+func buildOpenAPI(routes []Route) map[string]any {
+	schemas := schemaSet{}
+	paths := map[string]any{}
+
+	for _, route := range routes {
+		if route.Doc == nil {
+			continue
+		}
+		doc := route.Doc
+
+		method, path, ok := strings.Cut(route.Pattern, " ")
+		if !ok {
+			method, path = http.MethodGet, route.Pattern
+		}
+		path, params := openAPIPath(path)
+
+		op := map[string]any{
+			"summary":     doc.Summary,
+			"operationId": operationID(method, path),
+			"responses":   openAPIResponses(doc, strings.HasPrefix(path, AdminPrefix), schemas),
+		}
+		if doc.Description != "" {
+			op["description"] = doc.Description
+		}
+		if len(doc.Tags) > 0 {
+			op["tags"] = doc.Tags
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		switch {
+		case strings.HasPrefix(path, AdminPrefix):
+			op["security"] = []any{
+				map[string]any{"bearerAuth": []string{}},
+				map[string]any{"adminApiKey": []string{}},
+			}
+		case doc.Auth:
+			op["security"] = []any{map[string]any{"bearerAuth": []string{}}}
+		}
+		if doc.Request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": schemas.schemaFor(reflect.TypeOf(doc.Request))},
+				},
+			}
+		}
+
+		item, _ := paths[path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[path] = item
+		}
+		item[strings.ToLower(method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "Chirpy API",
+			"version": openAPIVersion,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"adminApiKey": map[string]any{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        "Authorization",
+					"description": `The admin API key as "ApiKey <key>".`,
+				},
+			},
+		},
+	}
+}
+
+// openAPIPath turns a ServeMux path into an OpenAPI path template and its path
+// parameters. "/{$}" becomes "/", and subtree patterns ending in "/" get a {path} parameter.
+func openAPIPath(path string) (string, []any) {
+	if strings.HasSuffix(path, "/") && path != "/" {
+		path += "{path...}"
+	}
+
+	var params []any
+	path = wildcardPattern.ReplaceAllStringFunc(path, func(m string) string {
+		name := wildcardPattern.FindStringSubmatch(m)[1]
+		if name == "$" {
+			return ""
+		}
+		params = append(params, map[string]any{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+		return "{" + name + "}"
+	})
+	if path == "" {
+		path = "/"
+	}
+	return path, params
+}
+
+// operationID builds a stable identifier such as "getApiChirpsId" from a method and path
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	upper := true
+	for _, r := range path {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	if path == "/" {
+		b.WriteString("Root")
+	}
+	return b.String()
+}
+
+// openAPIResponses lists the documented responses and errors; admin routes also get
+// the 401 and 403 answered by requireAdmin
+func openAPIResponses(doc *RouteDoc, admin bool, schemas schemaSet) map[string]any {
+	responses := map[string]any{}
+	for _, resp := range doc.Responses {
+		out := map[string]any{"description": resp.Description}
+		if resp.Body != nil {
+			contentType := resp.ContentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			out["content"] = map[string]any{
+				contentType: map[string]any{"schema": schemas.schemaFor(reflect.TypeOf(resp.Body))},
+			}
+		}
+		responses[strconv.Itoa(resp.Status)] = out
+	}
+
+	errorStatuses := append([]int(nil), doc.Errors...)
+	if admin {
+		errorStatuses = append(errorStatuses, http.StatusUnauthorized, http.StatusForbidden)
+	}
+	sort.Ints(errorStatuses)
+	for _, status := range errorStatuses {
+		responses[strconv.Itoa(status)] = map[string]any{
+			"description": http.StatusText(status),
+			"content": map[string]any{
+				"application/problem+json": map[string]any{"schema": schemas.schemaFor(reflect.TypeOf(problem{}))},
+				"application/json":         map[string]any{"schema": schemas.schemaFor(reflect.TypeOf(errorResponse{}))},
+			},
+		}
+	}
+	return responses
+}
+
+// schemaSet collects the component schemas of named struct types, keyed by exported type name
+type schemaSet map[string]any
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the JSON Schema for t, adding named structs to the set and
+// referring to them with $ref
+func (s schemaSet) schemaFor(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		elem := s.schemaFor(t.Elem())
+		if typ, ok := elem["type"].(string); ok {
+			elem["type"] = []string{typ, "null"}
+			return elem
+		}
+		return map[string]any{"oneOf": []any{elem, map[string]any{"type": "null"}}}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": s.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, ok := s[name]; !ok {
+			s[name] = map[string]any{} // placeholder so recursive types terminate
+			s[name] = s.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// structSchema describes a struct's JSON encoding. Fields without omitempty are
+// required, and embedded structs without a JSON name are flattened as encoding/json does.
+func (s schemaSet) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := range t.NumField() {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				addFields(field.Type)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = s.schemaFor(field.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// schemaName exports a type name, so validateChirpRequest becomes ValidateChirpRequest
+func schemaName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
@@ -0,0 +1,163 @@
+package http
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Machine-readable error codes sent in the "code" member of problem details.
+// Each code always comes with the same status and title.
+const (
+	CodeInvalidJSON          = "invalid_json"
+	CodeUnknownField         = "unknown_field"
+	CodeValidationFailed     = "validation_failed"
+	CodeInvalidQuery         = "invalid_query"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeChirpTooLong         = "chirp_too_long"
+	CodeInvalidEmail         = "invalid_email"
+	CodeEmailTaken           = "email_taken"
+	CodeUnauthorized         = "unauthorized"
+	CodeInvalidToken         = "invalid_token"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeRateLimited          = "rate_limited"
+	CodeInternal             = "internal_error"
+	CodeUnavailable          = "unavailable"
+)
+
+// problemTitles is the short, fixed summary of each error code
+var problemTitles = map[string]string{
+	CodeInvalidJSON:          "Request body is not valid JSON",
+	CodeUnknownField:         "Request body has an unknown field",
+	CodeValidationFailed:     "Request body failed validation",
+	CodeInvalidQuery:         "Invalid query parameter",
+	CodeBodyTooLarge:         "Request body is too large",
+	CodeUnsupportedMediaType: "Unsupported request content type",
+	CodeChirpTooLong:         "Chirp is too long",
+	CodeInvalidEmail:         "Invalid email address",
+	CodeEmailTaken:           "Email is already registered",
+	CodeUnauthorized:         "Authentication required",
+	CodeInvalidToken:         "Invalid or expired token",
+	CodeInvalidCredentials:   "Incorrect email or password",
+	CodeForbidden:            "Forbidden",
+	CodeNotFound:             "Not found",
+	CodeMethodNotAllowed:     "Method not allowed",
+	CodeRateLimited:          "Too many requests",
+	CodeInternal:             "Internal server error",
+	CodeUnavailable:          "Service unavailable",
+}
+
+// problemTypeBase prefixes the code to form the problem type, a relative URI as
+// allowed by RFC 9457
+const problemTypeBase = "/problems/"
+
+// problem is an RFC 9457 problem details object with Chirpy's extension members
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// RespondWithError writes an error as application/problem+json. Clients whose Accept
+// header asks for application/json but not application/problem+json get the legacy
+// {"error": detail} body instead.
+func RespondWithError(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	if wantsLegacyErrors(r) {
+		respondWithJSON(w, status, errorResponse{Error: detail})
+		return
+	}
+
+	title, ok := problemTitles[code]
+	if !ok {
+		title = http.StatusText(status)
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem{
+		Type:      problemTypeBase + code,
+		Title:     title,
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: RequestIDFromContext(r.Context()),
+	})
+}
+
+// wantsLegacyErrors reports whether r accepts application/json but not
+// application/problem+json, which is how clients written before problem details ask
+func wantsLegacyErrors(r *http.Request) bool {
+	legacy := false
+	for _, value := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(value, ",") {
+			mediaType, params, err := mime.ParseMediaType(mediaRange)
+			if err != nil {
+				continue
+			}
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				continue
+			}
+			switch mediaType {
+			case "application/problem+json":
+				return false
+			case "application/json":
+				legacy = true
+			}
+		}
+	}
+	return legacy
+}
+
+// withProblemFallback answers requests that match no route with problem details
+// instead of the mux's plain-text 404 and 405 responses
+func withProblemFallback(router *Router) http.Handler {
+	mux := router.mux
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		// Let the mux decide between 404 and 405 and fill in Allow, then replace its body
+		capture := &headerCapture{header: make(http.Header)}
+		h.ServeHTTP(capture, r)
+		switch capture.status {
+		case http.StatusMethodNotAllowed:
+			w.Header().Set("Allow", capture.header.Get("Allow"))
+			RespondWithError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+				r.Method+" is not allowed on "+r.URL.Path)
+		default:
+			RespondWithError(w, r, http.StatusNotFound, CodeNotFound, "No route matches "+r.URL.Path)
+		}
+	})
+}
+
+// headerCapture records the headers and status a handler writes and discards the body
+type headerCapture struct {
+	header http.Header
+	status int
+}
+
+func (c *headerCapture) Header() http.Header { return c.header }
+
+func (c *headerCapture) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *headerCapture) Write(b []byte) (int, error) {
+	c.WriteHeader(http.StatusOK)
+	return len(b), nil
+}
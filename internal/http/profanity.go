@@ -0,0 +1,341 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ReplacementStrategy decides how a matched profane word is rewritten
+type ReplacementStrategy int
+
+const (
+	// ReplaceToken swaps the word for a fixed token, "****" unless the word sets its own
+	ReplaceToken ReplacementStrategy = iota
+	// ReplaceMask swaps every character of the word for an asterisk
+	ReplaceMask
+	// ReplaceKeepFirst keeps the first character and masks the rest
+	ReplaceKeepFirst
+)
+
+// defaultReplacementToken is what ReplaceToken writes when a word has no token of its own
+const defaultReplacementToken = "****"
+
+// ProfanityWord is a single entry of a profanity list
+type ProfanityWord struct {
+	Word     string
+	Strategy ReplacementStrategy
+	Token    string
+}
+
+// DefaultProfanityWords is the list used when no word list is configured
+var DefaultProfanityWords = []ProfanityWord{
+	{Word: "kerfuffle"},
+	{Word: "sharbert"},
+	{Word: "fornax"},
+}
+
+// ProfanityLoader returns the current profanity list; ProfanityFilter calls it
+// on construction and again on every Reload
+type ProfanityLoader func() ([]ProfanityWord, error)
+
+// StaticProfanityWords returns a loader that always yields words
+func StaticProfanityWords(words []ProfanityWord) ProfanityLoader {
+	return func() ([]ProfanityWord, error) {
+		return words, nil
+	}
+}
+
+// ProfanityFile returns a loader that parses the word list at path with ParseProfanityList
+func ProfanityFile(path string) ProfanityLoader {
+	return func() ([]ProfanityWord, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("open profanity list: %w", err)
+		}
+		defer f.Close()
+		return ParseProfanityList(f)
+	}
+}
+
+// ProfanityEnv returns a loader that parses the environment variable name,
+// with entries separated by commas or newlines
+func ProfanityEnv(name string) ProfanityLoader {
+	return func() ([]ProfanityWord, error) {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("profanity list: %s is not set", name)
+		}
+		return ParseProfanityList(strings.NewReader(strings.ReplaceAll(value, ",", "\n")))
+	}
+}
+
+// ParseProfanityList reads one entry per line. Blank lines and lines starting with
+// # are ignored. An entry is a word optionally followed by "=" and a strategy:
+//
+//	kerfuffle            replaced with ****
+//	sharbert = mask      replaced with ********
+//	fornax = first       replaced with f*****
+//	darn = token [oops]  replaced with [oops]
+func ParseProfanityList(r io.Reader) ([]ProfanityWord, error) {
+	var words []ProfanityWord
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		wordPart, strategyPart, _ := strings.Cut(line, "=")
+		word := ProfanityWord{Word: strings.TrimSpace(wordPart)}
+		if word.Word == "" || strings.ContainsFunc(word.Word, isSpace) {
+			return nil, fmt.Errorf("profanity list line %d: invalid word %q", lineNum, wordPart)
+		}
+
+		name, token, _ := strings.Cut(strings.TrimSpace(strategyPart), " ")
+		switch name {
+		case "", "token":
+			word.Strategy = ReplaceToken
+			word.Token = strings.TrimSpace(token)
+		case "mask":
+			word.Strategy = ReplaceMask
+		case "first":
+			word.Strategy = ReplaceKeepFirst
+		default:
+			return nil, fmt.Errorf("profanity list line %d: unknown strategy %q", lineNum, name)
+		}
+		words = append(words, word)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read profanity list: %w", err)
+	}
+	return words, nil
+}
+
+// ProfanityFilter replaces profane words in chirps. The compiled matcher is built
+// once per load and swapped atomically, so Clean never compiles a regex and can
+// run concurrently with Reload.
+type ProfanityFilter struct {
+	load    ProfanityLoader
+	strict  bool
+	matcher atomic.Pointer[profanityMatcher]
+	// reloadErr is the error of the last Reload, nil after a successful one
+	reloadErr atomic.Pointer[error]
+}
+
+// ProfanityOption configures a ProfanityFilter
+type ProfanityOption func(*ProfanityFilter)
+
+// StrictProfanityMatching makes the filter treat punctuation as a word boundary and
+// compare words after folding Unicode look-alikes, accents and leetspeak, so
+// "Kerfuffle!", "ｋｅｒｆｕｆｆｌｅ" and "k3rfuffl3" are all caught
+func StrictProfanityMatching() ProfanityOption {
+	return func(filter *ProfanityFilter) {
+		filter.strict = true
+	}
+}
+
+// profanityMatcher is an immutable compiled form of a profanity list
+type profanityMatcher struct {
+	re    *regexp.Regexp
+	words map[string]ProfanityWord
+	// folded indexes the words by foldWord for strict matching
+	folded map[string]ProfanityWord
+}
+
+// NewProfanityFilter loads and compiles the list returned by load
+func NewProfanityFilter(load ProfanityLoader, opts ...ProfanityOption) (*ProfanityFilter, error) {
+	filter := &ProfanityFilter{load: load}
+	for _, opt := range opts {
+		opt(filter)
+	}
+	if err := filter.Reload(); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// mustProfanityFilter is NewProfanityFilter for lists known to be valid
+func mustProfanityFilter(load ProfanityLoader) *ProfanityFilter {
+	filter, err := NewProfanityFilter(load)
+	if err != nil {
+		panic(err)
+	}
+	return filter
+}
+
+// defaultProfanityFilter cleans chirps when no filter is configured
+var defaultProfanityFilter = mustProfanityFilter(StaticProfanityWords(DefaultProfanityWords))
+
+// Reload calls the loader again and swaps in the new list. On error the
+// previous list stays active.
+func (f *ProfanityFilter) Reload() error {
+	err := f.reload()
+	if err != nil {
+		f.reloadErr.Store(&err)
+	} else {
+		f.reloadErr.Store(nil)
+	}
+	return err
+}
+
+func (f *ProfanityFilter) reload() error {
+	words, err := f.load()
+	if err != nil {
+		return err
+	}
+	matcher, err := compileProfanity(words)
+	if err != nil {
+		return err
+	}
+	f.matcher.Store(matcher)
+	return nil
+}
+
+// CheckReload is a HealthCheck that fails while the last Reload has failed, since the
+// filter is then still using an older list
+func (f *ProfanityFilter) CheckReload(ctx context.Context) error {
+	if err := f.reloadErr.Load(); err != nil {
+		return fmt.Errorf("profanity list reload failed: %w", *err)
+	}
+	return nil
+}
+
+// compileProfanity builds the regex and lookup table for words
+func compileProfanity(words []ProfanityWord) (*profanityMatcher, error) {
+	matcher := &profanityMatcher{
+		words:  make(map[string]ProfanityWord, len(words)),
+		folded: make(map[string]ProfanityWord, len(words)),
+	}
+	if len(words) == 0 {
+		return matcher, nil
+	}
+
+	alternatives := make([]string, 0, len(words))
+	for _, word := range words {
+		key := strings.ToLower(word.Word)
+		if _, dup := matcher.words[key]; dup {
+			continue
+		}
+		matcher.words[key] = word
+		matcher.folded[foldWord(word.Word)] = word
+		alternatives = append(alternatives, regexp.QuoteMeta(key))
+	}
+
+	// (?i) makes it case-insensitive
+	// (^|\s) ensures the word starts after whitespace or at string start
+	// ($|\s) ensures the word ends before whitespace or at string end
+	re, err := regexp.Compile(`(?i)(^|\s)(` + strings.Join(alternatives, "|") + `)($|\s)`)
+	if err != nil {
+		return nil, fmt.Errorf("compile profanity list: %w", err)
+	}
+	matcher.re = re
+	return matcher, nil
+}
+
+// Clean replaces every listed word that stands on its own between whitespace,
+// or between any non-word characters in strict mode
+func (f *ProfanityFilter) Clean(text string) string {
+	matcher := f.matcher.Load()
+	if matcher.re == nil {
+		return text
+	}
+	if f.strict {
+		return matcher.cleanStrict(text)
+	}
+
+	// Search from just past the last replacement so a replacement token can never be
+	// matched again, while its trailing whitespace still counts as the next word's boundary
+	result := text
+	for pos := 0; pos <= len(result); {
+		match := matcher.re.FindStringSubmatchIndex(result[pos:])
+		if match == nil {
+			break
+		}
+		// match[4] and match[5] are the start and end of the profane word (group 2)
+		start, end := pos+match[4], pos+match[5]
+		replacement := matcher.replace(result[start:end])
+		result = result[:start] + replacement + result[end:]
+		pos = start + len(replacement)
+	}
+	return result
+}
+
+// cleanStrict splits text into runs of word characters and replaces each run whose
+// folded form is listed. Only the run itself is rewritten, so punctuation and
+// spacing around it are kept exactly as typed.
+func (m *profanityMatcher) cleanStrict(text string) string {
+	var b strings.Builder
+	b.Grow(len(text))
+
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		word := text[start:end]
+		if entry, ok := m.folded[foldWord(word)]; ok {
+			b.WriteString(replaceWith(entry, word))
+		} else {
+			b.WriteString(word)
+		}
+		start = -1
+	}
+
+	for i, r := range text {
+		if isStrictWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		flush(i)
+		b.WriteRune(r)
+	}
+	flush(len(text))
+	return b.String()
+}
+
+// replace returns the replacement for word according to its list entry
+func (m *profanityMatcher) replace(word string) string {
+	return replaceWith(m.words[strings.ToLower(word)], word)
+}
+
+// replaceWith rewrites word according to entry; masks count visible characters,
+// so combining marks don't add extra asterisks
+func replaceWith(entry ProfanityWord, word string) string {
+	switch entry.Strategy {
+	case ReplaceMask:
+		return strings.Repeat("*", visibleRuneCount(word))
+	case ReplaceKeepFirst:
+		first, size := utf8.DecodeRuneInString(word)
+		return string(first) + strings.Repeat("*", visibleRuneCount(word[size:]))
+	default:
+		if entry.Token != "" {
+			return entry.Token
+		}
+		return defaultReplacementToken
+	}
+}
+
+// visibleRuneCount counts the runes of s that aren't combining marks
+func visibleRuneCount(s string) int {
+	n := 0
+	for _, r := range s {
+		if !unicode.Is(unicode.Mn, r) {
+			n++
+		}
+	}
+	return n
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' || r == '\v'
+}
@@ -0,0 +1,112 @@
+package http
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Strict profanity matching compares words after folding away the tricks people
+// use to sneak words past a filter. The standard library has no Unicode
+// normalization tables, so foldWord implements the NFKC compatibility mappings that
+// matter for evasion (full-width, mathematical and circled letters, ligatures)
+// together with accent stripping, homoglyph and leetspeak substitution.
+
+// homoglyphs maps lowercase letters from other scripts that render like Latin letters
+var homoglyphs = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'һ': 'h', 'і': 'i', 'ї': 'i', 'ј': 'j',
+	'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'ѕ': 's', 'т': 't',
+	'у': 'y', 'х': 'x', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ү': 'y',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o',
+	'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+	// Latin look-alikes
+	'ı': 'i', 'ɡ': 'g', 'ɑ': 'a', 'ℓ': 'l',
+}
+
+// leetspeak maps digits and symbols commonly typed in place of letters
+var leetspeak = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '$': 's',
+}
+
+// accented maps precomposed Latin letters to their base letter, which NFKD followed
+// by dropping combining marks would produce
+var accented = map[rune]rune{}
+
+func init() {
+	for base, letters := range map[rune]string{
+		'a': "àáâãäåāăą", 'c': "çćĉċč", 'd': "ďđ", 'e': "èéêëēĕėęě",
+		'g': "ĝğġģ", 'h': "ĥħ", 'i': "ìíîïĩīĭį", 'j': "ĵ", 'k': "ķ",
+		'l': "ĺļľŀł", 'n': "ñńņňŉ", 'o': "òóôõöøōŏő", 'r': "ŕŗř",
+		's': "śŝşš", 't': "ţťŧ", 'u': "ùúûüũūŭůűų", 'w': "ŵ",
+		'y': "ýÿŷ", 'z': "źżž",
+	} {
+		for _, r := range letters {
+			accented[r] = base
+		}
+	}
+}
+
+// ligatures maps compatibility ligatures to the letters they stand for
+var ligatures = map[rune]string{
+	'ﬀ': "ff", 'ﬁ': "fi", 'ﬂ': "fl", 'ﬃ': "ffi", 'ﬄ': "ffl", 'ﬅ': "st", 'ﬆ': "st",
+	'æ': "ae", 'œ': "oe", 'ĳ': "ij",
+}
+
+// isStrictWordRune reports whether r is part of a word in strict mode; everything
+// else, including punctuation, is a word boundary
+func isStrictWordRune(r rune) bool {
+	if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) {
+		return true
+	}
+	_, ok := leetspeak[r]
+	return ok
+}
+
+// foldWord returns the canonical form of a word for strict matching
+func foldWord(word string) string {
+	var b strings.Builder
+	b.Grow(len(word))
+	for _, r := range word {
+		// Combining marks (accents typed as separate characters) carry no letter
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		r = unicode.ToLower(foldCompat(r))
+		if s, ok := ligatures[r]; ok {
+			b.WriteString(s)
+			continue
+		}
+		if base, ok := accented[r]; ok {
+			r = base
+		} else if latin, ok := homoglyphs[r]; ok {
+			r = latin
+		} else if letter, ok := leetspeak[r]; ok {
+			r = letter
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// foldCompat applies the NFKC compatibility mappings for letter and digit variants
+func foldCompat(r rune) rune {
+	switch {
+	case r >= 0xFF01 && r <= 0xFF5E: // full-width ASCII
+		return r - 0xFEE0
+	case r >= 0x1D400 && r <= 0x1D6A3: // mathematical alphanumeric letters, 52 per style
+		i := (r - 0x1D400) % 52
+		if i < 26 {
+			return 'A' + i
+		}
+		return 'a' + i - 26
+	case r >= 0x1D7CE && r <= 0x1D7FF: // mathematical digits, 10 per style
+		return '0' + (r-0x1D7CE)%10
+	case r >= 0x24B6 && r <= 0x24CF: // circled capital letters
+		return 'A' + r - 0x24B6
+	case r >= 0x24D0 && r <= 0x24E9: // circled small letters
+		return 'a' + r - 0x24D0
+	}
+	return r
+}
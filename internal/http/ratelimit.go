@@ -0,0 +1,265 @@
+package http
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ShepBook/chirpy/internal/auth"
+)
+
+// Route groups that can be given their own rate limit with WithRateLimit
+const (
+	// RateLimitAuth covers POST /api/users, /api/login, /api/refresh and /api/revoke
+	RateLimitAuth = "auth"
+	// RateLimitWrite covers POST /api/validate_chirp and creating or deleting chirps
+	RateLimitWrite = "write"
+	// RateLimitRead covers listing and fetching chirps
+	RateLimitRead = "read"
+)
+
+// RateLimit allows Requests per Per on average, with bursts of up to Burst
+// requests. Burst defaults to Requests. The zero value means no limit.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+// WithRateLimit limits each client to limit on the routes in group
+func WithRateLimit(group string, limit RateLimit) Option {
+	return func(server *Server) {
+		if server.rateLimits == nil {
+			server.rateLimits = make(map[string]RateLimit)
+		}
+		server.rateLimits[group] = limit
+	}
+}
+
+// WithTrustedProxies makes the rate limiter take the client address from
+// X-Forwarded-For when the connection comes from one of proxies
+func WithTrustedProxies(proxies ...netip.Prefix) Option {
+	return func(server *Server) {
+		server.trustedProxies = proxies
+	}
+}
+
+// newRateLimiters builds one limiter per group with a limit, shared by every route in the group
+func newRateLimiters(limits map[string]RateLimit) map[string]*rateLimiter {
+	limiters := make(map[string]*rateLimiter)
+	for group, limit := range limits {
+		if limit.enabled() {
+			limiters[group] = newRateLimiter(limit, time.Now)
+		}
+	}
+	return limiters
+}
+
+// rateLimited wraps next in the limiter for group, or returns it unchanged if the group has no limit
+func (server *Server) rateLimited(group string, next http.HandlerFunc) http.HandlerFunc {
+	limiter, ok := server.rateLimiters[group]
+	if !ok {
+		return next
+	}
+	limit := server.rateLimits[group]
+	policy := strconv.Itoa(limit.Requests) + ";w=" + strconv.Itoa(int(math.Ceil(limit.Per.Seconds())))
+	rejected := server.metrics.rateLimited.WithLabelValues(group)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := limiter.allow(server.rateLimitKey(r))
+
+		h := w.Header()
+		h.Set("RateLimit-Policy", policy)
+		h.Set("RateLimit-Limit", strconv.Itoa(limiter.capacity))
+		h.Set("RateLimit-Remaining", strconv.Itoa(d.remaining))
+		h.Set("RateLimit-Reset", ceilSeconds(d.reset))
+		if !d.allowed {
+			rejected.Inc()
+			h.Set("Retry-After", ceilSeconds(d.retryAfter))
+			RespondWithError(w, r, http.StatusTooManyRequests, CodeRateLimited, "Too many requests")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// rateLimitKey identifies the client: the user for requests with a valid access
+// token, otherwise the client's address, or its /64 for IPv6 so hosts can't get
+// around the limit by moving through their block
+func (server *Server) rateLimitKey(r *http.Request) string {
+	if token, err := auth.GetBearerToken(r.Header); err == nil {
+		if userID, err := auth.ValidateJWT(token, server.jwtSecret); err == nil {
+			return "user:" + userID
+		}
+	}
+	return "ip:" + clientBlock(clientIP(r, server.trustedProxies))
+}
+
+// ClientIP returns the address of the client that sent r, honouring X-Forwarded-For
+// from the proxies set with WithTrustedProxies
+func (server *Server) ClientIP(r *http.Request) string {
+	return clientIP(r, server.trustedProxies)
+}
+
+// clientIP returns the address of the client that sent r. When the connection comes
+// from a trusted proxy, X-Forwarded-For is read right to left and the first address
+// that isn't a trusted proxy is used, so clients can't spoof it by prepending entries.
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	client := addrPort.Addr().Unmap()
+	if !isTrusted(client, trusted) {
+		return client.String()
+	}
+
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap()
+		if !isTrusted(client, trusted) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// clientBlock groups IPv6 clients by /64, the block a single host usually gets
+func clientBlock(clientIP string) string {
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil || !addr.Is6() {
+		return clientIP
+	}
+	prefix, _ := addr.Prefix(64)
+	return prefix.String()
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// rateLimiter keeps one token bucket per client. Buckets that have refilled
+// completely are indistinguishable from new ones, so they are swept from the map
+// at most once per sweepInterval to keep memory bounded by the active clients.
+type rateLimiter struct {
+	rate          float64 // tokens per second
+	capacity      int
+	sweepInterval time.Duration
+	now           func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateDecision is the outcome of one rateLimiter.allow call
+type rateDecision struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration // until the bucket is full again
+	retryAfter time.Duration // until the next request would be allowed
+}
+
+func newRateLimiter(limit RateLimit, now func() time.Time) *rateLimiter {
+	capacity := limit.Burst
+	if capacity <= 0 {
+		capacity = limit.Requests
+	}
+	rate := float64(limit.Requests) / limit.Per.Seconds()
+	fill := time.Duration(float64(capacity) / rate * float64(time.Second))
+	return &rateLimiter{
+		rate:          rate,
+		capacity:      capacity,
+		sweepInterval: max(fill, time.Minute),
+		now:           now,
+		buckets:       make(map[string]*tokenBucket),
+		lastSweep:     now(),
+	}
+}
+
+// allow takes a token from key's bucket if one is available
+func (l *rateLimiter) allow(key string) rateDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= l.sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.capacity), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.updated = now
+
+	d := rateDecision{}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.allowed = true
+	} else {
+		d.retryAfter = l.durationFor(1 - b.tokens)
+	}
+	d.remaining = int(b.tokens)
+	d.reset = l.durationFor(float64(l.capacity) - b.tokens)
+	return d
+}
+
+// refill returns b's token count at now
+func (l *rateLimiter) refill(b *tokenBucket, now time.Time) float64 {
+	elapsed := now.Sub(b.updated).Seconds()
+	return math.Min(float64(l.capacity), b.tokens+elapsed*l.rate)
+}
+
+// durationFor returns how long it takes to accumulate tokens
+func (l *rateLimiter) durationFor(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// sweep drops every bucket that has refilled completely
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.capacity) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// len returns the number of buckets currently tracked
+func (l *rateLimiter) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
@@ -0,0 +1,44 @@
+package http
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverPanics turns a panic in next into a logged, counted 500 response with the
+// standard JSON error body, instead of a dropped connection. A panic after the
+// response has started still drops the connection, once it is logged.
+func (server *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := newStatusRecorder(w)
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// http.ErrAbortHandler is net/http's way of aborting a response on purpose
+			if e, ok := err.(error); ok && errors.Is(e, http.ErrAbortHandler) {
+				panic(err)
+			}
+
+			server.metrics.panics.Inc()
+			server.logger.LogAttrs(r.Context(), slog.LevelError, "panic serving request",
+				slog.String("request_id", RequestIDFromContext(r.Context())),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Any("panic", err),
+				slog.String("stack", string(debug.Stack())),
+			)
+
+			// Once the handler has started the response the status can't change. Aborting
+			// the connection keeps the client from taking the cut-off body as complete.
+			if rec.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			RespondWithError(rec, r, http.StatusInternalServerError, CodeInternal, "Internal server error")
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,168 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/ShepBook/chirpy/internal/audit"
+	"github.com/ShepBook/chirpy/internal/store"
+)
+
+// Documentation for the routes registered by NewWithConfig, served at /api/openapi.json
+
+var homeDoc = RouteDoc{
+	Summary:   "Home page",
+	Tags:      []string{"app"},
+	Responses: []Response{{Status: http.StatusOK, Description: "The home page", ContentType: "text/html", Body: ""}},
+}
+
+var appDoc = RouteDoc{
+	Summary:     "Static web app",
+	Description: "Serves the files of the web app with content-hash ETags; every request counts as a fileserver hit.",
+	Tags:        []string{"app"},
+	Responses: []Response{
+		{Status: http.StatusOK, Description: "The requested file"},
+		{Status: http.StatusNotModified, Description: "The cached copy named by If-None-Match is current"},
+	},
+	Errors: []int{http.StatusNotFound},
+}
+
+var healthzDoc = RouteDoc{
+	Summary:   "Check that the server is up",
+	Tags:      []string{"health"},
+	Responses: []Response{{Status: http.StatusOK, Description: "The server is up", ContentType: "text/plain", Body: "OK"}},
+}
+
+var livezDoc = RouteDoc{
+	Summary:     "Check whether the server should be restarted",
+	Description: "Runs the liveness checks and reports each one with its latency.",
+	Tags:        []string{"health"},
+	Responses: []Response{
+		{Status: http.StatusOK, Description: "Every check passed", Body: HealthReport{}},
+		{Status: http.StatusServiceUnavailable, Description: "A check failed", Body: HealthReport{}},
+	},
+}
+
+var readyzDoc = RouteDoc{
+	Summary:     "Check whether the server should receive traffic",
+	Description: "Runs the liveness and readiness checks, such as the stores and the profanity list. Fails as soon as shutdown begins.",
+	Tags:        []string{"health"},
+	Responses: []Response{
+		{Status: http.StatusOK, Description: "Every check passed", Body: HealthReport{}},
+		{Status: http.StatusServiceUnavailable, Description: "A check failed or the server is shutting down", Body: HealthReport{}},
+	},
+}
+
+var validateChirpDoc = RouteDoc{
+	Summary:     "Validate a chirp without saving it",
+	Description: "Checks the length limit and returns the body with profane words replaced.",
+	Tags:        []string{"chirps"},
+	Request:     validateChirpRequest{},
+	Responses:   []Response{{Status: http.StatusOK, Description: "The chirp is valid", Body: validateChirpResponse{}}},
+	Errors:      []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusTooManyRequests},
+}
+
+var createUserDoc = RouteDoc{
+	Summary:   "Register a user",
+	Tags:      []string{"users"},
+	Request:   createUserRequest{},
+	Responses: []Response{{Status: http.StatusCreated, Description: "The new user", Body: userResponse{}}},
+	Errors:    []int{http.StatusBadRequest, http.StatusConflict, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusTooManyRequests, http.StatusInternalServerError},
+}
+
+var loginDoc = RouteDoc{
+	Summary:     "Log in",
+	Description: "Returns the user with a one-hour access token and a 60-day refresh token.",
+	Tags:        []string{"auth"},
+	Request:     loginRequest{},
+	Responses:   []Response{{Status: http.StatusOK, Description: "The user and a new token pair", Body: loginResponse{}}},
+	Errors:      []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusTooManyRequests, http.StatusInternalServerError},
+}
+
+var refreshDoc = RouteDoc{
+	Summary:     "Exchange a refresh token for a new token pair",
+	Description: "The bearer token is a refresh token. It is revoked and replaced by the one returned.",
+	Tags:        []string{"auth"},
+	Auth:        true,
+	Responses:   []Response{{Status: http.StatusOK, Description: "A new token pair", Body: refreshResponse{}}},
+	Errors:      []int{http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusInternalServerError},
+}
+
+var revokeDoc = RouteDoc{
+	Summary:     "Revoke a refresh token",
+	Description: "The bearer token is the refresh token to revoke.",
+	Tags:        []string{"auth"},
+	Auth:        true,
+	Responses:   []Response{{Status: http.StatusNoContent, Description: "The token is revoked"}},
+	Errors:      []int{http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusInternalServerError},
+}
+
+var createChirpDoc = RouteDoc{
+	Summary:     "Post a chirp",
+	Description: "Validates and cleans the chirp like /api/validate_chirp, then saves it as written by the caller.",
+	Tags:        []string{"chirps"},
+	Auth:        true,
+	Request:     createChirpRequest{},
+	Responses:   []Response{{Status: http.StatusCreated, Description: "The saved chirp", Body: store.Chirp{}}},
+	Errors:      []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusTooManyRequests, http.StatusInternalServerError},
+}
+
+var listChirpsDoc = RouteDoc{
+	Summary:   "List chirps",
+	Tags:      []string{"chirps"},
+	Responses: []Response{{Status: http.StatusOK, Description: "Every chirp, oldest first", Body: []store.Chirp{}}},
+	Errors:    []int{http.StatusTooManyRequests, http.StatusInternalServerError},
+}
+
+var chirpStreamDoc = RouteDoc{
+	Summary: "Stream new chirps",
+	Description: "Sends each chirp created from now on as a Server-Sent Event named chirp, with an event ID. " +
+		"author_id and hashtag limit the stream to one author or one hashtag. A Last-Event-ID header first replays " +
+		"the recent chirps after that ID, or all of them for an ID from before a server restart; deleted chirps " +
+		"are left out. Idle streams get a comment line as a heartbeat, and every stream ends " +
+		"when the server shuts down. Past the limit on open streams, overall or per client, new ones get 503.",
+	Tags:      []string{"chirps"},
+	Responses: []Response{{Status: http.StatusOK, Description: "An endless stream of chirp events", ContentType: "text/event-stream", Body: ""}},
+	Errors:    []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusServiceUnavailable},
+}
+
+var getChirpDoc = RouteDoc{
+	Summary:   "Get a chirp",
+	Tags:      []string{"chirps"},
+	Responses: []Response{{Status: http.StatusOK, Description: "The chirp", Body: store.Chirp{}}},
+	Errors:    []int{http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+}
+
+var deleteChirpDoc = RouteDoc{
+	Summary:   "Delete one of your chirps",
+	Tags:      []string{"chirps"},
+	Auth:      true,
+	Responses: []Response{{Status: http.StatusNoContent, Description: "The chirp is deleted"}},
+	Errors:    []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError},
+}
+
+var prometheusDoc = RouteDoc{
+	Summary:   "Server metrics in the Prometheus text format",
+	Tags:      []string{"admin"},
+	Responses: []Response{{Status: http.StatusOK, Description: "Current metric values", ContentType: "text/plain; version=0.0.4", Body: ""}},
+}
+
+var auditDoc = RouteDoc{
+	Summary: "Read the audit log",
+	Description: "Returns audit entries, oldest first. Query parameters narrow them down: actor and action match exactly, " +
+		"since and until (RFC 3339) bound the time, and limit (default 100, at most 1000) keeps the newest matches.",
+	Tags:      []string{"admin"},
+	Responses: []Response{{Status: http.StatusOK, Description: "The matching entries", Body: []audit.Entry{}}},
+	Errors:    []int{http.StatusBadRequest, http.StatusInternalServerError},
+}
+
+var openAPIDoc = RouteDoc{
+	Summary:   "This OpenAPI document",
+	Tags:      []string{"docs"},
+	Responses: []Response{{Status: http.StatusOK, Description: "OpenAPI 3.1 document", Body: map[string]any{}}},
+}
+
+var apiExplorerDoc = RouteDoc{
+	Summary:   "Browse the API",
+	Tags:      []string{"docs"},
+	Responses: []Response{{Status: http.StatusOK, Description: "HTML page rendering /api/openapi.json", ContentType: "text/html", Body: ""}},
+}
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"net/http"
+	"sync"
+)
+
+// RouteDoc describes a route for the generated OpenAPI document
+type RouteDoc struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Auth marks routes that need an "Authorization: Bearer" token
+	Auth bool
+	// Request is an example value whose type defines the JSON request body, or nil for none
+	Request any
+	// Responses lists the successful responses
+	Responses []Response
+	// Errors lists the status codes the route answers with problem details
+	Errors []int
+}
+
+// Response describes one response of a route
+type Response struct {
+	Status      int
+	Description string
+	// ContentType defaults to application/json when Body is set
+	ContentType string
+	// Body is an example value whose type defines the response schema, or nil for no body
+	Body any
+}
+
+// Route is a pattern registered on a Router and its documentation, if any
+type Route struct {
+	Pattern string
+	Doc     *RouteDoc
+}
+
+// Router is the ServeMux behind a Server. It records every pattern registered on it
+// along with its RouteDoc so the OpenAPI document can be generated from the routes.
+type Router struct {
+	mux *http.ServeMux
+
+	mu     sync.Mutex
+	routes []Route
+}
+
+func newRouter() *Router {
+	return &Router{mux: http.NewServeMux()}
+}
+
+// Handle registers handler for pattern, documented by doc
+func (rt *Router) Handle(pattern string, handler http.Handler, doc ...RouteDoc) {
+	rt.mux.Handle(pattern, handler)
+
+	route := Route{Pattern: pattern}
+	if len(doc) > 0 {
+		route.Doc = &doc[0]
+	}
+	rt.mu.Lock()
+	rt.routes = append(rt.routes, route)
+	rt.mu.Unlock()
+}
+
+// HandleFunc registers handler for pattern, documented by doc
+func (rt *Router) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request), doc ...RouteDoc) {
+	rt.Handle(pattern, http.HandlerFunc(handler), doc...)
+}
+
+// ServeHTTP dispatches the request to the matching handler without the Server's middleware
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+// Routes returns the registered routes in registration order
+func (rt *Router) Routes() []Route {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return append([]Route(nil), rt.routes...)
+}
+
+// Undocumented returns the patterns registered without a RouteDoc
+func (rt *Router) Undocumented() []string {
+	var patterns []string
+	for _, route := range rt.Routes() {
+		if route.Doc == nil {
+			patterns = append(patterns, route.Pattern)
+		}
+	}
+	return patterns
+}
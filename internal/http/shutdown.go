@@ -0,0 +1,130 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultShutdownHookTimeout bounds a shutdown hook registered without a timeout
+const DefaultShutdownHookTimeout = 5 * time.Second
+
+// shutdownHook is a function OnShutdown registered
+type shutdownHook struct {
+	name    string
+	timeout time.Duration
+	run     func(ctx context.Context) error
+}
+
+// WithDrainPeriod makes Shutdown keep serving for d after readiness starts failing,
+// so load balancers notice and stop sending new requests before connections close
+func WithDrainPeriod(d time.Duration) Option {
+	return func(server *Server) {
+		server.drainPeriod = d
+	}
+}
+
+// OnShutdown registers run to be called by Shutdown once in-flight requests have
+// finished, such as flushing metrics, closing stores or stopping background workers.
+// Hooks run one at a time in the order they were registered, each with its own
+// timeout (DefaultShutdownHookTimeout when timeout is 0), and run even when the
+// Shutdown context has ended so their work isn't lost.
+func (server *Server) OnShutdown(name string, timeout time.Duration, run func(ctx context.Context) error) {
+	if timeout <= 0 {
+		timeout = DefaultShutdownHookTimeout
+	}
+	server.hooksMu.Lock()
+	defer server.hooksMu.Unlock()
+	server.hooks = append(server.hooks, shutdownHook{name: name, timeout: timeout, run: run})
+}
+
+// Shutdown stops the server gracefully: readiness fails at once, requests are still
+// served for the drain period, then the listeners close and in-flight requests get
+// until ctx ends to finish before their connections are cut. The shutdown hooks run
+// last. Only the first call does any of this; later calls wait for it and return its result.
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.shutdownOnce.Do(func() {
+		server.shutdownErr = server.shutdown(ctx)
+	})
+	return server.shutdownErr
+}
+
+// HandOff is Shutdown for when another process has taken over the listeners. The
+// drain period is skipped: the sockets stay open in the new process, and closing them
+// here at once leaves new connections queued for it.
+func (server *Server) HandOff(ctx context.Context) error {
+	server.handingOff.Store(true)
+	return server.Shutdown(ctx)
+}
+
+func (server *Server) shutdown(ctx context.Context) error {
+	server.shuttingDown.Store(true)
+
+	if server.drainPeriod > 0 && !server.handingOff.Load() {
+		server.logger.Info("draining before shutdown", "drain_period", server.drainPeriod.String())
+		select {
+		case <-time.After(server.drainPeriod):
+		case <-ctx.Done():
+		}
+	}
+
+	err := server.httpSrv.Shutdown(ctx)
+	if server.redirectSrv != nil {
+		err = errors.Join(err, server.redirectSrv.Shutdown(ctx))
+	}
+	if err != nil {
+		// Requests still running when ctx ended are cut off rather than left behind
+		server.Close()
+	}
+
+	var hookErrs []error
+	server.hooksMu.Lock()
+	hooks := append([]shutdownHook(nil), server.hooks...)
+	server.hooksMu.Unlock()
+	for _, hook := range hooks {
+		if err := server.runHook(context.WithoutCancel(ctx), hook); err != nil {
+			hookErrs = append(hookErrs, err)
+		}
+	}
+	if len(hookErrs) == 0 {
+		return err
+	}
+	return errors.Join(append([]error{err}, hookErrs...)...)
+}
+
+// runHook calls hook with its timeout. A hook that ignores its context is abandoned
+// when the timeout passes.
+func (server *Server) runHook(ctx context.Context, hook shutdownHook) error {
+	ctx, cancel := context.WithTimeout(ctx, hook.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- hook.run(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		server.logger.Error("shutdown hook failed", "hook", hook.name, "duration", time.Since(start).String(), "error", err)
+		return fmt.Errorf("shutdown hook %s: %w", hook.name, err)
+	}
+	server.logger.Info("shutdown hook finished", "hook", hook.name, "duration", time.Since(start).String())
+	return nil
+}
+
+// Close stops the server immediately, closing the listeners and every connection
+// without waiting for requests to finish or running the shutdown hooks. It is the
+// forced alternative to Shutdown.
+func (server *Server) Close() error {
+	server.shuttingDown.Store(true)
+	server.stream.close()
+	if server.redirectSrv == nil {
+		return server.httpSrv.Close()
+	}
+	return errors.Join(server.httpSrv.Close(), server.redirectSrv.Close())
+}
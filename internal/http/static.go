@@ -0,0 +1,351 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheControl maps file extensions such as ".css" to the Cache-Control header sent
+// with them. "*" covers extensions without an entry; an empty value sends no header.
+type CacheControl map[string]string
+
+// DefaultCacheControl makes browsers revalidate HTML on every load, so pages pick up
+// new assets right away, and lets everything else be cached for an hour
+var DefaultCacheControl = CacheControl{
+	".html": "no-cache",
+	"*":     "public, max-age=3600",
+}
+
+// maxCachedFileSize is the largest file whose contents FileServer keeps in memory
+const maxCachedFileSize = 1 << 20
+
+// precompressed lists the sibling files FileServer looks for, in order of preference
+var precompressed = []struct {
+	ext    string
+	coding string
+}{
+	{".br", "br"},
+	{".gz", "gzip"},
+}
+
+// FileServerOption configures a handler made by FileServer
+type FileServerOption func(*fileServer)
+
+// CachePolicies sets Cache-Control per extension; entries in cc replace the
+// matching entries of DefaultCacheControl
+func CachePolicies(cc CacheControl) FileServerOption {
+	return func(s *fileServer) {
+		for ext, value := range cc {
+			s.cacheControl[strings.ToLower(ext)] = value
+		}
+	}
+}
+
+// DirectoryListings lists the files of directories that have no index.html instead
+// of answering 404
+func DirectoryListings() FileServerOption {
+	return func(s *fileServer) {
+		s.listings = true
+	}
+}
+
+// SPAFallback serves the top-level index.html for GET requests to missing paths
+// without a file extension, so a client-side routed app can handle them
+func SPAFallback() FileServerOption {
+	return func(s *fileServer) {
+		s.spaFallback = true
+	}
+}
+
+// FileServer serves the files in fsys with content-hash ETags, Cache-Control by
+// extension, and pre-built .br or .gz siblings for clients that accept them. File
+// contents and hashes are kept in memory and only re-read when a file's size or
+// modification time changes. Dot files are never served, and directories without
+// an index.html are 404 unless DirectoryListings is set.
+func FileServer(fsys fs.FS, opts ...FileServerOption) http.Handler {
+	s := &fileServer{
+		fsys:         fsys,
+		cacheControl: make(CacheControl, len(DefaultCacheControl)),
+		files:        make(map[string]*staticFile),
+	}
+	for ext, value := range DefaultCacheControl {
+		s.cacheControl[ext] = value
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type fileServer struct {
+	fsys         fs.FS
+	cacheControl CacheControl
+	listings     bool
+	spaFallback  bool
+
+	mu    sync.RWMutex
+	files map[string]*staticFile
+}
+
+// staticFile is what fileServer remembers about one file
+type staticFile struct {
+	modTime time.Time
+	size    int64
+	etag    string
+	// data is nil for files larger than maxCachedFileSize
+	data []byte
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if hasDotElement(name) {
+		RespondWithError(w, r, http.StatusNotFound, CodeNotFound, "No file at "+r.URL.Path)
+		return
+	}
+	// Like http.FileServer, index.html is only ever served under its directory's URL
+	if strings.HasSuffix(r.URL.Path, "/index.html") {
+		localRedirect(w, r, "./")
+		return
+	}
+	if name == "" {
+		name = "."
+	}
+
+	info, err := fs.Stat(s.fsys, name)
+	switch {
+	case err != nil:
+		if s.spaFallback && (r.Method == http.MethodGet || r.Method == http.MethodHead) && path.Ext(name) == "" {
+			s.serveFile(w, r, "index.html")
+			return
+		}
+		RespondWithError(w, r, http.StatusNotFound, CodeNotFound, "No file at "+r.URL.Path)
+	case info.IsDir():
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			localRedirect(w, r, path.Base(r.URL.Path)+"/")
+			return
+		}
+		if _, err := s.file(path.Join(name, "index.html")); err == nil {
+			s.serveFile(w, r, path.Join(name, "index.html"))
+			return
+		}
+		if s.listings {
+			s.serveListing(w, r, name)
+			return
+		}
+		RespondWithError(w, r, http.StatusNotFound, CodeNotFound, "No file at "+r.URL.Path)
+	case strings.HasSuffix(r.URL.Path, "/"):
+		localRedirect(w, r, "../"+path.Base(name))
+	default:
+		s.serveFile(w, r, name)
+	}
+}
+
+// serveFile sends the regular file name, or its best precompressed sibling
+func (s *fileServer) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	file, err := s.file(name)
+	if err != nil {
+		RespondWithError(w, r, http.StatusNotFound, CodeNotFound, "No file at "+r.URL.Path)
+		return
+	}
+
+	header := w.Header()
+	addVary(header, "Accept-Encoding")
+	if cc := s.cacheControlFor(name); cc != "" {
+		header.Set("Cache-Control", cc)
+	}
+	contentType := mime.TypeByExtension(path.Ext(name))
+
+	served := name
+	for _, sibling := range precompressed {
+		if !acceptsEncoding(r, sibling.coding) {
+			continue
+		}
+		if compressed, err := s.file(name + sibling.ext); err == nil {
+			header.Set("Content-Encoding", sibling.coding)
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			file, served = compressed, name+sibling.ext
+			break
+		}
+	}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	header.Set("ETag", file.etag)
+
+	content, err := s.content(served, file)
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Internal server error")
+		return
+	}
+	if closer, ok := content.(io.Closer); ok {
+		defer closer.Close()
+	}
+	http.ServeContent(w, r, name, file.modTime, content)
+}
+
+// serveListing writes a plain HTML list of the directory dir, leaving out dot files
+func (s *fileServer) serveListing(w http.ResponseWriter, r *http.Request, dir string) {
+	entries, err := fs.ReadDir(s.fsys, dir)
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Internal server error")
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n")
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			name += "/"
+		}
+		link := url.URL{Path: name}
+		fmt.Fprintf(&b, "<a href=\"%s\">%s</a>\n", link.String(), html.EscapeString(name))
+	}
+	b.WriteString("</pre>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, b.String())
+}
+
+// localRedirect sends a permanent redirect to target, relative to the request path,
+// keeping the query string
+func localRedirect(w http.ResponseWriter, r *http.Request, target string) {
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Location", target)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
+
+// hasDotElement reports whether any element of the slash-separated name starts with "."
+func hasDotElement(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// file returns the cached entry for the regular file name, loading it when it is new or changed
+func (s *fileServer) file(name string) (*staticFile, error) {
+	info, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fs.ErrNotExist
+	}
+
+	s.mu.RLock()
+	cached, ok := s.files[name]
+	s.mu.RUnlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached, nil
+	}
+
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	file := &staticFile{modTime: info.ModTime(), size: info.Size()}
+	if info.Size() <= maxCachedFileSize {
+		if file.data, err = io.ReadAll(f); err != nil {
+			return nil, err
+		}
+		hash.Write(file.data)
+		file.size = int64(len(file.data))
+	} else if _, err := io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+	file.etag = `"` + base64.RawURLEncoding.EncodeToString(hash.Sum(nil)[:16]) + `"`
+
+	s.mu.Lock()
+	s.files[name] = file
+	s.mu.Unlock()
+	return file, nil
+}
+
+// content returns the body of file, from memory when it is cached
+func (s *fileServer) content(name string, file *staticFile) (io.ReadSeeker, error) {
+	if file.data != nil {
+		return bytes.NewReader(file.data), nil
+	}
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		f.Close()
+		return nil, &fs.PathError{Op: "seek", Path: name, Err: fs.ErrInvalid}
+	}
+	return content, nil
+}
+
+func (s *fileServer) cacheControlFor(name string) string {
+	if cc, ok := s.cacheControl[strings.ToLower(path.Ext(name))]; ok {
+		return cc
+	}
+	return s.cacheControl["*"]
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of r allows coding.
+// An explicit entry wins over "*", and q=0 refuses a coding.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	accepted := false
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(item, ";")
+			name = strings.TrimSpace(name)
+			q := 1.0
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+			switch {
+			case strings.EqualFold(name, coding):
+				return q > 0
+			case name == "*":
+				accepted = q > 0
+			}
+		}
+	}
+	return accepted
+}
+
+// addVary adds field to the Vary header unless it is already listed
+func addVary(header http.Header, field string) {
+	for _, value := range header.Values("Vary") {
+		for _, existing := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), field) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", field)
+}
@@ -0,0 +1,356 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ShepBook/chirpy/internal/store"
+)
+
+// Defaults for GET /api/chirps/stream unless WithChirpStream or WithStreamLimits say otherwise
+const (
+	DefaultStreamBuffer     = 256
+	DefaultStreamHeartbeat  = 15 * time.Second
+	DefaultMaxStreams       = 1000
+	DefaultMaxClientStreams = 10
+)
+
+// subscriberBuffer is how far a stream may fall behind before it is closed. The
+// client then reconnects and catches up from the ring buffer with Last-Event-ID.
+const subscriberBuffer = 64
+
+// Reasons chirpBroker.subscribe turns a stream away
+var (
+	errStreamsClosed  = errors.New("server is shutting down")
+	errTooManyStreams = errors.New("too many open streams")
+)
+
+// hashtagPattern matches the hashtags in a chirp, and validHashtag a hashtag query
+// parameter without its '#'
+var (
+	hashtagPattern = regexp.MustCompile(`#(\w+)`)
+	validHashtag   = regexp.MustCompile(`^\w+$`)
+)
+
+// WithChirpStream keeps the last bufferSize chirps for clients resuming
+// GET /api/chirps/stream and sends a heartbeat comment on idle streams every heartbeat.
+// Zero values keep the defaults.
+func WithChirpStream(bufferSize int, heartbeat time.Duration) Option {
+	return func(server *Server) {
+		if bufferSize > 0 {
+			server.streamBuffer = bufferSize
+		}
+		if heartbeat > 0 {
+			server.streamHeartbeat = heartbeat
+		}
+	}
+}
+
+// WithStreamLimits caps the open GET /api/chirps/stream connections at total, and at
+// perClient for each client address. Every stream holds a goroutine and a connection
+// for as long as the client stays, so new ones past either limit get 503.
+// Zero values keep the defaults.
+func WithStreamLimits(total, perClient int) Option {
+	return func(server *Server) {
+		if total > 0 {
+			server.maxStreams = total
+		}
+		if perClient > 0 {
+			server.maxClientStreams = perClient
+		}
+	}
+}
+
+// streamEvent is a chirp with its event ID on the stream
+type streamEvent struct {
+	id    uint64
+	chirp store.Chirp
+	// deleted is set once the author deletes the chirp, which is then left out of replays
+	deleted bool
+}
+
+// chirpFilter selects the chirps a stream receives. Zero fields match everything.
+type chirpFilter struct {
+	authorID string
+	// hashtag is lower-case and without the leading '#'
+	hashtag string
+}
+
+// parseChirpFilter reads the author_id and hashtag query parameters
+func parseChirpFilter(query url.Values) (chirpFilter, error) {
+	f := chirpFilter{authorID: query.Get("author_id")}
+	if tag := query.Get("hashtag"); tag != "" {
+		tag = strings.TrimPrefix(tag, "#")
+		if !validHashtag.MatchString(tag) {
+			return chirpFilter{}, errors.New("hashtag must be letters, digits and underscores")
+		}
+		f.hashtag = strings.ToLower(tag)
+	}
+	return f, nil
+}
+
+func (f chirpFilter) match(c store.Chirp) bool {
+	if f.authorID != "" && c.UserID != f.authorID {
+		return false
+	}
+	if f.hashtag == "" {
+		return true
+	}
+	for _, m := range hashtagPattern.FindAllStringSubmatch(c.Body, -1) {
+		if strings.ToLower(m[1]) == f.hashtag {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriber is one open stream
+type subscriber struct {
+	client string
+	filter chirpFilter
+	events chan streamEvent
+}
+
+// chirpBroker fans new chirps out to the open streams and keeps the latest ones in
+// a ring buffer so reconnecting clients can resume where they left off
+type chirpBroker struct {
+	// epoch starts every event ID, so IDs handed out by an earlier process aren't
+	// mistaken for ones from this process, which counts from 1 again
+	epoch string
+
+	mu     sync.Mutex
+	ring   []streamEvent
+	lastID uint64
+	subs   map[*subscriber]struct{}
+	closed bool
+
+	maxStreams       int
+	maxClientStreams int
+	// clients counts the open streams of each client
+	clients map[string]int
+}
+
+func newChirpBroker(size, maxStreams, maxClientStreams int) *chirpBroker {
+	return &chirpBroker{
+		epoch:            strconv.FormatInt(time.Now().UnixNano(), 36),
+		ring:             make([]streamEvent, size),
+		subs:             make(map[*subscriber]struct{}),
+		maxStreams:       maxStreams,
+		maxClientStreams: maxClientStreams,
+		clients:          make(map[string]int),
+	}
+}
+
+// publish gives chirp the next event ID and sends it to the matching streams. A
+// stream whose buffer is full is closed rather than holding up the others.
+func (b *chirpBroker) publish(chirp store.Chirp) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e := streamEvent{id: b.lastID, chirp: chirp}
+	b.ring[(e.id-1)%uint64(len(b.ring))] = e
+	for sub := range b.subs {
+		if !sub.filter.match(chirp) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			b.drop(sub)
+		}
+	}
+}
+
+// forget leaves the chirp with chirpID out of later replays
+func (b *chirpBroker) forget(chirpID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.ring {
+		if b.ring[i].chirp.ID == chirpID {
+			b.ring[i] = streamEvent{id: b.ring[i].id, deleted: true}
+		}
+	}
+}
+
+// subscribe opens a stream for client of the chirps matching filter. It also returns
+// the buffered events after lastEventID, the Last-Event-ID of a reconnecting client;
+// an ID from an earlier process gets everything buffered. It fails once the broker is
+// closed or a stream limit is reached.
+func (b *chirpBroker) subscribe(client, lastEventID string, filter chirpFilter) (*subscriber, []streamEvent, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.closed:
+		return nil, nil, errStreamsClosed
+	case len(b.subs) >= b.maxStreams, b.clients[client] >= b.maxClientStreams:
+		return nil, nil, errTooManyStreams
+	}
+
+	var backlog []streamEvent
+	if lastID, ok := b.resumeAfter(lastEventID); ok {
+		oldest := uint64(1)
+		if b.lastID > uint64(len(b.ring)) {
+			oldest = b.lastID - uint64(len(b.ring)) + 1
+		}
+		for id := max(lastID+1, oldest); id <= b.lastID; id++ {
+			if e := b.ring[(id-1)%uint64(len(b.ring))]; !e.deleted && filter.match(e.chirp) {
+				backlog = append(backlog, e)
+			}
+		}
+	}
+
+	sub := &subscriber{client: client, filter: filter, events: make(chan streamEvent, subscriberBuffer)}
+	b.subs[sub] = struct{}{}
+	b.clients[client]++
+	return sub, backlog, nil
+}
+
+// resumeAfter returns the event ID after which to replay the buffer for lastEventID,
+// and false for a missing or malformed ID. IDs from another epoch, or past the last
+// event, come from an earlier process and give 0. b.mu must be held.
+func (b *chirpBroker) resumeAfter(lastEventID string) (uint64, bool) {
+	epoch, seq, ok := strings.Cut(lastEventID, "-")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if epoch != b.epoch || id > b.lastID {
+		return 0, true
+	}
+	return id, true
+}
+
+// eventID is the SSE id of the event with sequence number id
+func (b *chirpBroker) eventID(id uint64) string {
+	return b.epoch + "-" + strconv.FormatUint(id, 10)
+}
+
+// unsubscribe removes sub if it is still open
+func (b *chirpBroker) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		b.drop(sub)
+	}
+}
+
+// drop closes sub's channel, which ends its stream; b.mu must be held
+func (b *chirpBroker) drop(sub *subscriber) {
+	delete(b.subs, sub)
+	if b.clients[sub.client]--; b.clients[sub.client] == 0 {
+		delete(b.clients, sub.client)
+	}
+	close(sub.events)
+}
+
+// close ends every stream and turns new ones away
+func (b *chirpBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		b.drop(sub)
+	}
+}
+
+// streams returns the number of open streams
+func (b *chirpBroker) streams() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// handleChirpStream sends chirps created from now on as Server-Sent Events, filtered
+// by the author_id and hashtag query parameters. A Last-Event-ID header first replays
+// the buffered chirps after that ID.
+func (server *Server) handleChirpStream(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseChirpFilter(r.URL.Query())
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, CodeInvalidQuery, err.Error())
+		return
+	}
+	client := clientBlock(clientIP(r, server.trustedProxies))
+	sub, backlog, err := server.stream.subscribe(client, r.Header.Get("Last-Event-ID"), filter)
+	switch {
+	case errors.Is(err, errStreamsClosed):
+		RespondWithError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "Server is shutting down")
+		return
+	case err != nil:
+		RespondWithError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "Too many open streams, try again later")
+		return
+	}
+	defer server.stream.unsubscribe(sub)
+
+	// The server's timeouts are sized for ordinary requests. A stream stays open, so
+	// instead each write gets the write timeout and reads are not limited at all.
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return
+	}
+	send := func(msg []byte) error {
+		err := rc.SetWriteDeadline(time.Now().Add(server.writeTimeout))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		if _, err := w.Write(msg); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	// The last deadline may have passed on an idle stream; the response still has to end cleanly
+	defer func() { rc.SetWriteDeadline(time.Now().Add(server.writeTimeout)) }()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	var msg bytes.Buffer
+	for _, e := range backlog {
+		writeStreamEvent(&msg, server.stream.eventID(e.id), e.chirp)
+	}
+	if err := send(msg.Bytes()); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(server.streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		msg.Reset()
+		select {
+		case e, ok := <-sub.events:
+			if !ok {
+				// Shutting down, or too far behind; the client reconnects with Last-Event-ID
+				return
+			}
+			writeStreamEvent(&msg, server.stream.eventID(e.id), e.chirp)
+		case <-heartbeat.C:
+			msg.WriteString(": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		if err := send(msg.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+// writeStreamEvent appends chirp to buf as a "chirp" event with the given ID
+func writeStreamEvent(buf *bytes.Buffer, id string, chirp store.Chirp) {
+	data, _ := json.Marshal(chirp)
+	buf.WriteString("id: " + id + "\n")
+	buf.WriteString("event: chirp\n")
+	buf.WriteString("data: ")
+	buf.Write(data)
+	buf.WriteString("\n\n")
+}
@@ -0,0 +1,240 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	selfSignedCertFile = "cert.pem"
+	selfSignedKeyFile  = "key.pem"
+
+	// selfSignedValidity is how long a generated certificate lasts; it is
+	// regenerated once less than selfSignedRenewBefore remains
+	selfSignedValidity    = 365 * 24 * time.Hour
+	selfSignedRenewBefore = 7 * 24 * time.Hour
+)
+
+// WithTLS makes ListenAndServe speak HTTPS using the PEM certificate and key at the given paths
+func WithTLS(certFile, keyFile string) Option {
+	return func(server *Server) {
+		server.certFile = certFile
+		server.keyFile = keyFile
+	}
+}
+
+// WithSelfSignedTLS makes ListenAndServe speak HTTPS with a self-signed certificate
+// for hosts, generated on first start and cached in dir. Meant for development and staging.
+func WithSelfSignedTLS(dir string, hosts ...string) Option {
+	return func(server *Server) {
+		server.selfSignedDir = dir
+		server.selfSignedHosts = hosts
+	}
+}
+
+// WithHTTPRedirect starts a plain HTTP listener on addr alongside the TLS one that
+// redirects every request to the same URL over HTTPS
+func WithHTTPRedirect(addr string) Option {
+	return func(server *Server) {
+		server.redirectAddr = addr
+	}
+}
+
+// WithHSTS sends Strict-Transport-Security with the given max-age on HTTPS responses
+func WithHSTS(maxAge time.Duration) Option {
+	return func(server *Server) {
+		server.hstsMaxAge = maxAge
+	}
+}
+
+// tlsEnabled reports whether ListenAndServe should serve HTTPS
+func (server *Server) tlsEnabled() bool {
+	return server.certFile != "" || server.selfSignedDir != ""
+}
+
+// WithRedirectListener makes the HTTP redirect of WithHTTPRedirect serve on l, such
+// as a socket inherited from another process, instead of binding its address
+func WithRedirectListener(l net.Listener) Option {
+	return func(server *Server) {
+		server.redirectLn = l
+	}
+}
+
+// ListenAndServeTLS serves HTTPS on the configured address with the given certificate,
+// plus the HTTP redirect listener if one is configured
+func (server *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	ln, err := server.listen(":https")
+	if err != nil {
+		return err
+	}
+	return server.serveTLS(ln, certFile, keyFile)
+}
+
+// serveTLS serves HTTPS on ln with the given certificate, plus the HTTP redirect
+// listener if one is configured
+func (server *Server) serveTLS(ln net.Listener, certFile, keyFile string) error {
+	if server.redirectSrv != nil {
+		redirectLn := server.redirectLn
+		if redirectLn == nil {
+			var err error
+			if redirectLn, err = net.Listen("tcp", server.redirectAddr); err != nil {
+				ln.Close()
+				return err
+			}
+		}
+		go func() {
+			if err := server.redirectSrv.Serve(redirectLn); err != nil && err != http.ErrServerClosed {
+				server.logger.Error("HTTP redirect listener stopped", "error", err)
+			}
+		}()
+	}
+
+	err := server.httpSrv.ServeTLS(ln, certFile, keyFile)
+	if err != nil && err != http.ErrServerClosed && server.redirectSrv != nil {
+		server.redirectSrv.Close()
+	}
+	return err
+}
+
+// serveConfiguredTLS resolves the certificate to use, generating the self-signed one
+// if needed, and serves HTTPS on ln
+func (server *Server) serveConfiguredTLS(ln net.Listener) error {
+	if server.certFile != "" {
+		return server.serveTLS(ln, server.certFile, server.keyFile)
+	}
+	certFile, keyFile, err := EnsureSelfSignedCert(server.selfSignedDir, server.selfSignedHosts...)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	return server.serveTLS(ln, certFile, keyFile)
+}
+
+// withHSTS adds Strict-Transport-Security to responses sent over TLS. Browsers ignore
+// the header over plain HTTP, so it is never sent there.
+func withHSTS(maxAge time.Duration, next http.Handler) http.Handler {
+	value := "max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// redirectToHTTPS sends every request to the same host and path over HTTPS on the
+// port in tlsAddr. 308 keeps the method and body for API clients.
+func redirectToHTTPS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+			host = "[" + host + "]" // bare IPv6 address
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// EnsureSelfSignedCert returns the paths of a self-signed certificate and key cached in
+// dir, generating them if they are missing, unreadable, about to expire or issued for
+// different hosts. The certificate covers hosts, or localhost and the loopback
+// addresses if none are given.
+func EnsureSelfSignedCert(dir string, hosts ...string) (certFile, keyFile string, err error) {
+	certFile = filepath.Join(dir, selfSignedCertFile)
+	keyFile = filepath.Join(dir, selfSignedKeyFile)
+
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && cachedCertUsable(cert.Leaf, hosts) {
+		return certFile, keyFile, nil
+	}
+	certPEM, keyPEM, err := generateSelfSignedCert(hosts, time.Now())
+	if err != nil {
+		return "", "", fmt.Errorf("generate self-signed certificate: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+// cachedCertUsable reports whether leaf still has time left and covers every host
+func cachedCertUsable(leaf *x509.Certificate, hosts []string) bool {
+	if leaf == nil || time.Until(leaf.NotAfter) < selfSignedRenewBefore {
+		return false
+	}
+	for _, host := range hosts {
+		if leaf.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// generateSelfSignedCert creates a P-256 certificate valid from now for hosts, which
+// may be DNS names or IP addresses
+func generateSelfSignedCert(hosts []string, now time.Time) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Chirpy development"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
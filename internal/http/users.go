@@ -0,0 +1,75 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/ShepBook/chirpy/internal/store"
+)
+
+type createUserRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (req createUserRequest) validate() error {
+	return requireFields("email", req.Email, "password", req.Password)
+}
+
+// userResponse is the public view of a store.User; it deliberately has no
+// password field so the hash can never leak into a response
+type userResponse struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Email     string    `json:"email"`
+}
+
+func newUserResponse(user store.User) userResponse {
+	return userResponse{
+		ID:        user.ID,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+		Email:     user.Email,
+	}
+}
+
+// normalizeEmail lowercases and trims an email so lookups are case-insensitive
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// handleCreateUser registers a user with an email and a hashed password
+func (server *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req createUserRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondWithRequestError(w, r, err)
+		return
+	}
+
+	email := normalizeEmail(req.Email)
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		RespondWithError(w, r, http.StatusBadRequest, CodeInvalidEmail, "Invalid email address")
+		return
+	}
+
+	hash, err := server.passwordParams.Hash(req.Password)
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Couldn't hash password")
+		return
+	}
+
+	user, err := server.users.Create(r.Context(), store.User{Email: email, HashedPassword: hash})
+	if errors.Is(err, store.ErrEmailTaken) {
+		RespondWithError(w, r, http.StatusConflict, CodeEmailTaken, "Email is already registered")
+		return
+	}
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, CodeInternal, "Couldn't create user")
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, newUserResponse(user))
+}
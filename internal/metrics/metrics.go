@@ -0,0 +1,370 @@
+// Package metrics is a small in-process metrics registry that renders counters,
+// gauges and histograms in the Prometheus text exposition format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are histogram buckets suited to HTTP latencies in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds every metric family and writes them out in registration order
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	names    map[string]bool
+}
+
+// family is one named metric with all of its label combinations
+type family interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register adds f, panicking on a duplicate name since that is always a programming error
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[f.name()] {
+		panic("metrics: duplicate metric " + f.name())
+	}
+	r.names[f.name()] = true
+	r.families = append(r.families, f)
+}
+
+// WritePrometheus writes every metric in the Prometheus text exposition format
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry in the Prometheus text exposition format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		r.WritePrometheus(w)
+	})
+}
+
+// atomicFloat is a float64 that can be updated without locks
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) Add(delta float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) Set(v float64) { f.bits.Store(math.Float64bits(v)) }
+
+func (f *atomicFloat) Load() float64 { return math.Float64frombits(f.bits.Load()) }
+
+// vec keeps one series per combination of label values
+type vec[T any] struct {
+	metricName string
+	help       string
+	typ        string
+	labelNames []string
+	newSeries  func() *T
+
+	mu     sync.RWMutex
+	series map[string]*labeledSeries[T]
+}
+
+type labeledSeries[T any] struct {
+	values []string
+	metric *T
+}
+
+func newVec[T any](name, help, typ string, labelNames []string, newSeries func() *T) *vec[T] {
+	return &vec[T]{
+		metricName: name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		newSeries:  newSeries,
+		series:     make(map[string]*labeledSeries[T]),
+	}
+}
+
+func (v *vec[T]) name() string { return v.metricName }
+
+// with returns the series for values, creating it on first use
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.metricName, len(v.labelNames), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return s.metric
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[key]; ok {
+		return s.metric
+	}
+	s = &labeledSeries[T]{values: slices.Clone(values), metric: v.newSeries()}
+	v.series[key] = s
+	return s.metric
+}
+
+// sorted returns a stable snapshot of the series ordered by label values
+func (v *vec[T]) sorted() []*labeledSeries[T] {
+	v.mu.RLock()
+	all := make([]*labeledSeries[T], 0, len(v.series))
+	for _, s := range v.series {
+		all = append(all, s)
+	}
+	v.mu.RUnlock()
+	slices.SortFunc(all, func(a, b *labeledSeries[T]) int {
+		return slices.Compare(a.values, b.values)
+	})
+	return all
+}
+
+func (v *vec[T]) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.metricName, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.metricName, v.typ)
+}
+
+// Counter is a value that only goes up
+type Counter struct{ value atomicFloat }
+
+// Inc adds one to the counter
+func (c *Counter) Inc() { c.value.Add(1) }
+
+// Add adds delta, which must not be negative, to the counter
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.value.Add(delta)
+}
+
+// Value returns the current count
+func (c *Counter) Value() float64 { return c.value.Load() }
+
+// CounterVec is a family of counters partitioned by labels
+type CounterVec struct{ *vec[Counter] }
+
+// NewCounterVec registers a counter family with the given label names
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	v := &CounterVec{newVec(name, help, "counter", labelNames, func() *Counter { return &Counter{} })}
+	r.register(v)
+	return v
+}
+
+// NewCounter registers a counter without labels
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).WithLabelValues()
+}
+
+// WithLabelValues returns the counter for the given label values, in label name order
+func (v *CounterVec) WithLabelValues(values ...string) *Counter { return v.with(values) }
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	for _, s := range v.sorted() {
+		writeSample(w, v.metricName, v.labelNames, s.values, "", "", s.metric.Value())
+	}
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct{ value atomicFloat }
+
+// Inc adds one to the gauge
+func (g *Gauge) Inc() { g.value.Add(1) }
+
+// Dec subtracts one from the gauge
+func (g *Gauge) Dec() { g.value.Add(-1) }
+
+// Add adds delta to the gauge
+func (g *Gauge) Add(delta float64) { g.value.Add(delta) }
+
+// Set replaces the gauge value
+func (g *Gauge) Set(v float64) { g.value.Set(v) }
+
+// Value returns the current gauge value
+func (g *Gauge) Value() float64 { return g.value.Load() }
+
+// GaugeVec is a family of gauges partitioned by labels
+type GaugeVec struct{ *vec[Gauge] }
+
+// NewGaugeVec registers a gauge family with the given label names
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	v := &GaugeVec{newVec(name, help, "gauge", labelNames, func() *Gauge { return &Gauge{} })}
+	r.register(v)
+	return v
+}
+
+// NewGauge registers a gauge without labels
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).WithLabelValues()
+}
+
+// WithLabelValues returns the gauge for the given label values, in label name order
+func (v *GaugeVec) WithLabelValues(values ...string) *Gauge { return v.with(values) }
+
+func (v *GaugeVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	for _, s := range v.sorted() {
+		writeSample(w, v.metricName, v.labelNames, s.values, "", "", s.metric.Value())
+	}
+}
+
+// gaugeFunc is a gauge whose value is read from a callback at scrape time
+type gaugeFunc struct {
+	metricName string
+	help       string
+	fn         func() float64
+}
+
+// NewGaugeFunc registers a gauge that reports fn() whenever the registry is written,
+// for values that already live elsewhere
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(gaugeFunc{metricName: name, help: help, fn: fn})
+}
+
+func (g gaugeFunc) name() string { return g.metricName }
+
+func (g gaugeFunc) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", g.metricName, escapeHelp(g.help))
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.metricName)
+	writeSample(w, g.metricName, nil, nil, "", "", g.fn())
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	upperBounds []float64
+	counts      []atomic.Uint64 // one per upper bound, plus +Inf
+	sum         atomicFloat
+}
+
+// Observe records one value
+func (h *Histogram) Observe(v float64) {
+	i, _ := slices.BinarySearch(h.upperBounds, v)
+	h.counts[i].Add(1)
+	h.sum.Add(v)
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	var total uint64
+	for i := range h.counts {
+		total += h.counts[i].Load()
+	}
+	return total
+}
+
+// HistogramVec is a family of histograms partitioned by labels
+type HistogramVec struct{ *vec[Histogram] }
+
+// NewHistogramVec registers a histogram family; buckets are upper bounds in increasing order
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if !slices.IsSorted(buckets) {
+		panic("metrics: histogram buckets must be sorted")
+	}
+	upperBounds := slices.Clone(buckets)
+	v := &HistogramVec{newVec(name, help, "histogram", labelNames, func() *Histogram {
+		return &Histogram{upperBounds: upperBounds, counts: make([]atomic.Uint64, len(upperBounds)+1)}
+	})}
+	r.register(v)
+	return v
+}
+
+// WithLabelValues returns the histogram for the given label values, in label name order
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram { return v.with(values) }
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	for _, s := range v.sorted() {
+		h := s.metric
+		var cumulative uint64
+		for i, bound := range h.upperBounds {
+			cumulative += h.counts[i].Load()
+			writeSample(w, v.metricName+"_bucket", v.labelNames, s.values, "le", formatFloat(bound), float64(cumulative))
+		}
+		cumulative += h.counts[len(h.upperBounds)].Load()
+		writeSample(w, v.metricName+"_bucket", v.labelNames, s.values, "le", "+Inf", float64(cumulative))
+		writeSample(w, v.metricName+"_sum", v.labelNames, s.values, "", "", h.sum.Load())
+		writeSample(w, v.metricName+"_count", v.labelNames, s.values, "", "", float64(cumulative))
+	}
+}
+
+// writeSample writes one exposition line, with an optional extra label such as le
+func writeSample(w *bufio.Writer, name string, labelNames, values []string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labelName, escapeLabelValue(values[i]))
+		}
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(s string) string { return labelValueEscaper.Replace(s) }
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
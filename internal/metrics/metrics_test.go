@@ -0,0 +1,176 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ShepBook/chirpy/internal/metrics"
+)
+
+// render returns the registry's exposition output
+func render(t *testing.T, registry *metrics.Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if err := registry.WritePrometheus(&b); err != nil {
+		t.Fatalf("WritePrometheus error: %v", err)
+	}
+	return b.String()
+}
+
+// Phase 1: Metric Type Tests
+
+func Test_Counter_WritesLabeledSeries(t *testing.T) {
+	registry := metrics.NewRegistry()
+	requests := registry.NewCounterVec("requests_total", "Requests handled.", "method", "code")
+
+	requests.WithLabelValues("GET", "200").Inc()
+	requests.WithLabelValues("GET", "200").Add(2)
+	requests.WithLabelValues("POST", "400").Inc()
+
+	want := `# HELP requests_total Requests handled.
+# TYPE requests_total counter
+requests_total{method="GET",code="200"} 3
+requests_total{method="POST",code="400"} 1
+`
+	if got := render(t, registry); got != want {
+		t.Errorf("Output =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_Counter_NegativeAdd_Panics(t *testing.T) {
+	counter := metrics.NewRegistry().NewCounter("c", "help")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic when decreasing a counter")
+		}
+	}()
+	counter.Add(-1)
+}
+
+func Test_Gauge_GoesUpAndDown(t *testing.T) {
+	registry := metrics.NewRegistry()
+	gauge := registry.NewGauge("in_flight", "In flight.")
+
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+	registry.NewGaugeFunc("answer", "Computed at scrape time.", func() float64 { return 42 })
+
+	want := `# HELP in_flight In flight.
+# TYPE in_flight gauge
+in_flight 1
+# HELP answer Computed at scrape time.
+# TYPE answer gauge
+answer 42
+`
+	if got := render(t, registry); got != want {
+		t.Errorf("Output =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_Histogram_WritesCumulativeBuckets(t *testing.T) {
+	registry := metrics.NewRegistry()
+	latency := registry.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+
+	h := latency.WithLabelValues("/a")
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(3)
+
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 2
+latency_seconds_bucket{route="/a",le="1"} 3
+latency_seconds_bucket{route="/a",le="+Inf"} 4
+latency_seconds_sum{route="/a"} 3.65
+latency_seconds_count{route="/a"} 4
+`
+	if got := render(t, registry); got != want {
+		t.Errorf("Output =\n%s\nwant\n%s", got, want)
+	}
+	if h.Count() != 4 {
+		t.Errorf("Count = %d, want 4", h.Count())
+	}
+}
+
+func Test_LabelValues_AreEscaped(t *testing.T) {
+	registry := metrics.NewRegistry()
+	registry.NewCounterVec("c", "Line one\nline two.", "path").WithLabelValues("a\"b\\c\nd").Inc()
+
+	want := `# HELP c Line one\nline two.
+# TYPE c counter
+c{path="a\"b\\c\nd"} 1
+`
+	if got := render(t, registry); got != want {
+		t.Errorf("Output =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// Phase 2: Registry Tests
+
+func Test_Registry_DuplicateName_Panics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	registry.NewCounter("c", "help")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic on duplicate metric name")
+		}
+	}()
+	registry.NewGauge("c", "help")
+}
+
+func Test_Registry_WrongLabelCount_Panics(t *testing.T) {
+	vec := metrics.NewRegistry().NewCounterVec("c", "help", "a", "b")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic on wrong number of label values")
+		}
+	}()
+	vec.WithLabelValues("only-one")
+}
+
+func Test_Counter_ConcurrentIncrements(t *testing.T) {
+	vec := metrics.NewRegistry().NewCounterVec("c", "help", "worker")
+
+	const goroutines, increments = 50, 100
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				vec.WithLabelValues("shared").Inc()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := vec.WithLabelValues("shared").Value(); got != goroutines*increments {
+		t.Errorf("Value = %v, want %d", got, goroutines*increments)
+	}
+}
+
+func Test_Registry_Handler_ServesTextFormat(t *testing.T) {
+	registry := metrics.NewRegistry()
+	registry.NewCounter("c", "help").Inc()
+
+	rec := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want Prometheus text format", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "c 1\n") {
+		t.Errorf("Body = %q, want sample line", rec.Body.String())
+	}
+}
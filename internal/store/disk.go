@@ -0,0 +1,26 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DiskSpaceCheck returns a health check that fails when the file system holding dir
+// has less than minFree bytes free for non-root users. It always passes on platforms
+// where FreeSpace is unsupported.
+func DiskSpaceCheck(dir string, minFree uint64) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		free, err := FreeSpace(dir)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if free < minFree {
+			return fmt.Errorf("%s has %d bytes free, want at least %d", dir, free, minFree)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package store
+
+import "errors"
+
+// FreeSpace is not implemented on this platform and returns errors.ErrUnsupported
+func FreeSpace(dir string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package store
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// FreeSpace returns the bytes available to non-root users on the file system holding dir
+func FreeSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, fmt.Errorf("statfs %s: %w", dir, err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
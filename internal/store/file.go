@@ -0,0 +1,344 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// FileChirpStore keeps chirps in memory and rewrites a JSON file after every change,
+// so saved chirps survive restarts
+type FileChirpStore struct {
+	mu     sync.Mutex
+	path   string
+	chirps map[string]Chirp
+	// saveErr is the error of the last save, nil after a successful one
+	saveErr error
+}
+
+// NewFileChirpStore opens the chirp file at path, creating it on first write
+func NewFileChirpStore(path string) (*FileChirpStore, error) {
+	var saved []Chirp
+	if err := readJSONFile(path, &saved); err != nil {
+		return nil, err
+	}
+
+	chirps := make(map[string]Chirp, len(saved))
+	for _, chirp := range saved {
+		chirps[chirp.ID] = chirp
+	}
+	return &FileChirpStore{path: path, chirps: chirps}, nil
+}
+
+func (s *FileChirpStore) Create(ctx context.Context, chirp Chirp) (Chirp, error) {
+	id, err := newID()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.ID = id
+	chirp.CreatedAt = now()
+	chirp.UpdatedAt = chirp.CreatedAt
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chirps[chirp.ID] = chirp
+	if err := s.save(); err != nil {
+		delete(s.chirps, chirp.ID)
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (s *FileChirpStore) Get(ctx context.Context, id string) (Chirp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chirp, ok := s.chirps[id]
+	if !ok {
+		return Chirp{}, ErrNotFound
+	}
+	return chirp, nil
+}
+
+func (s *FileChirpStore) List(ctx context.Context) ([]Chirp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedChirps(s.chirps), nil
+}
+
+func (s *FileChirpStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chirp, ok := s.chirps[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.chirps, id)
+	if err := s.save(); err != nil {
+		s.chirps[id] = chirp
+		return err
+	}
+	return nil
+}
+
+// save writes every chirp to disk; the caller must hold s.mu
+func (s *FileChirpStore) save() error {
+	s.saveErr = writeJSONFile(s.path, sortedChirps(s.chirps))
+	return s.saveErr
+}
+
+// Ping fails if the last save failed or the store's directory is gone
+func (s *FileChirpStore) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return pingFile(s.path, s.saveErr)
+}
+
+// FileUserStore keeps users in memory and rewrites a JSON file after every change,
+// so accounts survive restarts
+type FileUserStore struct {
+	mu      sync.Mutex
+	path    string
+	users   map[string]User
+	saveErr error
+}
+
+// NewFileUserStore opens the user file at path, creating it on first write
+func NewFileUserStore(path string) (*FileUserStore, error) {
+	var saved []User
+	if err := readJSONFile(path, &saved); err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]User, len(saved))
+	for _, user := range saved {
+		users[user.ID] = user
+	}
+	return &FileUserStore{path: path, users: users}, nil
+}
+
+func (s *FileUserStore) Create(ctx context.Context, user User) (User, error) {
+	id, err := newID()
+	if err != nil {
+		return User{}, err
+	}
+	user.ID = id
+	user.CreatedAt = now()
+	user.UpdatedAt = user.CreatedAt
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := findUserByEmail(s.users, user.Email); ok {
+		return User{}, ErrEmailTaken
+	}
+	s.users[user.ID] = user
+	if err := s.save(); err != nil {
+		delete(s.users, user.ID)
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (s *FileUserStore) Get(ctx context.Context, id string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *FileUserStore) GetByEmail(ctx context.Context, email string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := findUserByEmail(s.users, email)
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+// save writes every user to disk; the caller must hold s.mu
+func (s *FileUserStore) save() error {
+	s.saveErr = writeJSONFile(s.path, sortedUsers(s.users))
+	return s.saveErr
+}
+
+// Ping fails if the last save failed or the store's directory is gone
+func (s *FileUserStore) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return pingFile(s.path, s.saveErr)
+}
+
+// FileRefreshTokenStore keeps refresh tokens in memory and rewrites a JSON file
+// after every change, so sessions survive restarts
+type FileRefreshTokenStore struct {
+	mu      sync.Mutex
+	path    string
+	tokens  map[string]RefreshToken
+	saveErr error
+}
+
+// NewFileRefreshTokenStore opens the refresh token file at path, creating it on first write
+func NewFileRefreshTokenStore(path string) (*FileRefreshTokenStore, error) {
+	var saved []RefreshToken
+	if err := readJSONFile(path, &saved); err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[string]RefreshToken, len(saved))
+	for _, rt := range saved {
+		tokens[rt.Token] = rt
+	}
+	return &FileRefreshTokenStore{path: path, tokens: tokens}, nil
+}
+
+func (s *FileRefreshTokenStore) Create(ctx context.Context, token RefreshToken) (RefreshToken, error) {
+	token.CreatedAt = now()
+	token.UpdatedAt = token.CreatedAt
+	token.RevokedAt = nil
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token.Token] = token
+	if err := s.save(); err != nil {
+		delete(s.tokens, token.Token)
+		return RefreshToken{}, err
+	}
+	return token, nil
+}
+
+func (s *FileRefreshTokenStore) Get(ctx context.Context, token string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, ok := s.tokens[token]
+	if !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	return rt, nil
+}
+
+func (s *FileRefreshTokenStore) Revoke(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, ok := s.tokens[token]
+	if !ok {
+		return ErrNotFound
+	}
+	s.tokens[token] = revoked(rt)
+	if err := s.save(); err != nil {
+		s.tokens[token] = rt
+		return err
+	}
+	return nil
+}
+
+func (s *FileRefreshTokenStore) Rotate(ctx context.Context, token string, t time.Time) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, ok := s.tokens[token]
+	if !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	if !rt.Usable(t) {
+		return RefreshToken{}, ErrTokenUnusable
+	}
+	s.tokens[token] = revoked(rt)
+	if err := s.save(); err != nil {
+		s.tokens[token] = rt
+		return RefreshToken{}, err
+	}
+	return rt, nil
+}
+
+// save writes every refresh token to disk; the caller must hold s.mu
+func (s *FileRefreshTokenStore) save() error {
+	tokens := make([]RefreshToken, 0, len(s.tokens))
+	for _, rt := range s.tokens {
+		tokens = append(tokens, rt)
+	}
+	slices.SortFunc(tokens, func(a, b RefreshToken) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.Token, b.Token))
+	})
+	s.saveErr = writeJSONFile(s.path, tokens)
+	return s.saveErr
+}
+
+// Ping fails if the last save failed or the store's directory is gone
+func (s *FileRefreshTokenStore) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return pingFile(s.path, s.saveErr)
+}
+
+// pingFile reports saveErr, or an error if the directory path is saved in no longer exists
+func pingFile(path string, saveErr error) error {
+	if saveErr != nil {
+		return saveErr
+	}
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// readJSONFile decodes the file at path into v, leaving v untouched if the file doesn't exist yet
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSONFile atomically replaces the file at path with v encoded as JSON.
+// The data is written to a temporary file in the same directory, synced and then
+// renamed over the old file, so a crash never leaves a half-written store behind.
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", path, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replace %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package store
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxHitPaths bounds the distinct paths HitStats tracks; later paths are counted
+// under OtherPaths so requests for random missing files can't grow it without limit
+const maxHitPaths = 1000
+
+// OtherPaths is the path hits are counted under once maxHitPaths paths are tracked
+const OtherPaths = "(other)"
+
+// visitorDays is how many days of unique visitor counts HitStats keeps
+const visitorDays = 30
+
+// maxDailyVisitors bounds the visitors HitStats tells apart each day; later visitors
+// that day are counted on every hit so random addresses can't grow it without limit
+const maxDailyVisitors = 100_000
+
+// Hit is one request to the file server
+type Hit struct {
+	Path   string
+	Status int
+	// Visitor identifies the client, usually its IP address; only a keyed hash of it
+	// is kept, in memory, for the day
+	Visitor string
+	At      time.Time
+}
+
+// HitCount is one row of a HitSummary breakdown
+type HitCount struct {
+	Key   string
+	Count int64
+}
+
+// HitSummary is a point-in-time view of HitStats. Paths and Statuses are ordered by
+// count, most hits first; Visitors holds unique visitors per UTC day, newest first.
+type HitSummary struct {
+	Total    int64
+	Paths    []HitCount
+	Statuses []HitCount
+	Visitors []HitCount
+}
+
+// HitStats counts file server requests by path and status, and unique visitors per
+// day. Counts live in memory; Save writes them to a snapshot file that OpenHitStats
+// restores, so they survive restarts.
+//
+// Visitors are told apart by a keyed hash that is only kept for the current day. The
+// key is never saved and is replaced when the day changes, so neither the snapshot
+// nor old hashes can be matched against addresses. After a restart, visitors already
+// counted that day are counted again.
+type HitStats struct {
+	mu       sync.Mutex
+	path     string
+	paths    map[string]int64
+	statuses map[int]int64
+	// visitors is the number of unique visitors per day
+	visitors map[string]int64
+	// today is the day key and seen hash visitors for
+	today string
+	key   []byte
+	seen  map[string]bool
+	dirty bool
+}
+
+// hitSnapshot is the JSON form of HitStats
+type hitSnapshot struct {
+	Paths         map[string]int64 `json:"paths"`
+	Statuses      map[string]int64 `json:"statuses"`
+	VisitorCounts map[string]int64 `json:"visitor_counts"`
+}
+
+// NewHitStats creates empty stats that are never saved
+func NewHitStats() *HitStats {
+	return &HitStats{
+		paths:    make(map[string]int64),
+		statuses: make(map[int]int64),
+		visitors: make(map[string]int64),
+		seen:     make(map[string]bool),
+	}
+}
+
+// OpenHitStats restores the stats saved at path, starting empty if there is no file yet
+func OpenHitStats(path string) (*HitStats, error) {
+	var saved hitSnapshot
+	if err := readJSONFile(path, &saved); err != nil {
+		return nil, err
+	}
+
+	s := NewHitStats()
+	s.path = path
+	for p, count := range saved.Paths {
+		s.paths[p] = count
+	}
+	for code, count := range saved.Statuses {
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			return nil, fmt.Errorf("decode %s: invalid status %q", path, code)
+		}
+		s.statuses[status] = count
+	}
+	for day, count := range saved.VisitorCounts {
+		s.visitors[day] = count
+	}
+	return s, nil
+}
+
+// Record counts hit
+func (s *HitStats) Record(hit Hit) {
+	day := hit.At.UTC().Format(time.DateOnly)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := hit.Path
+	if _, ok := s.paths[p]; !ok && len(s.paths) >= maxHitPaths {
+		p = OtherPaths
+	}
+	s.paths[p]++
+	s.statuses[hit.Status]++
+	s.dirty = true
+
+	if day > s.today {
+		s.today, s.key = day, randomKey()
+		clear(s.seen)
+		s.dropOldVisitors(hit.At)
+	}
+	if day != s.today {
+		// A late hit for a day whose key is gone can't be told apart from earlier ones
+		return
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(hit.Visitor))
+	visitor := hex.EncodeToString(mac.Sum(nil)[:16])
+	if s.seen[visitor] {
+		return
+	}
+	if len(s.seen) < maxDailyVisitors {
+		s.seen[visitor] = true
+	}
+	s.visitors[day]++
+}
+
+// dropOldVisitors forgets visitor days more than visitorDays before now
+func (s *HitStats) dropOldVisitors(now time.Time) {
+	oldest := now.UTC().AddDate(0, 0, -visitorDays+1).Format(time.DateOnly)
+	for day := range s.visitors {
+		if day < oldest {
+			delete(s.visitors, day)
+		}
+	}
+}
+
+// Total returns the number of hits recorded
+func (s *HitStats) Total() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var total int64
+	for _, count := range s.statuses {
+		total += count
+	}
+	return total
+}
+
+// Reset forgets every hit and visitor
+func (s *HitStats) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.paths)
+	clear(s.statuses)
+	clear(s.visitors)
+	clear(s.seen)
+	s.dirty = true
+}
+
+// Summary returns the current counts
+func (s *HitStats) Summary() HitSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var summary HitSummary
+	for p, count := range s.paths {
+		summary.Paths = append(summary.Paths, HitCount{Key: p, Count: count})
+	}
+	for status, count := range s.statuses {
+		summary.Statuses = append(summary.Statuses, HitCount{Key: strconv.Itoa(status), Count: count})
+		summary.Total += count
+	}
+	for day, count := range s.visitors {
+		summary.Visitors = append(summary.Visitors, HitCount{Key: day, Count: count})
+	}
+
+	byCount := func(a, b HitCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Key, b.Key))
+	}
+	slices.SortFunc(summary.Paths, byCount)
+	slices.SortFunc(summary.Statuses, byCount)
+	slices.SortFunc(summary.Visitors, func(a, b HitCount) int { return cmp.Compare(b.Key, a.Key) })
+	return summary
+}
+
+// Save writes the stats to the file they were opened from if anything changed since
+// the last save. Stats from NewHitStats are never saved.
+func (s *HitStats) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" || !s.dirty {
+		return nil
+	}
+
+	snapshot := hitSnapshot{
+		Paths:         s.paths,
+		Statuses:      make(map[string]int64, len(s.statuses)),
+		VisitorCounts: s.visitors,
+	}
+	for status, count := range s.statuses {
+		snapshot.Statuses[strconv.Itoa(status)] = count
+	}
+
+	if err := writeJSONFile(s.path, snapshot); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// randomKey returns a new key for hashing visitors
+func randomKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
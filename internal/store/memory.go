@@ -0,0 +1,212 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryChirpStore keeps chirps in memory; everything is lost on restart
+type MemoryChirpStore struct {
+	mu     sync.RWMutex
+	chirps map[string]Chirp
+}
+
+// NewMemoryChirpStore creates an empty in-memory chirp store
+func NewMemoryChirpStore() *MemoryChirpStore {
+	return &MemoryChirpStore{chirps: make(map[string]Chirp)}
+}
+
+func (s *MemoryChirpStore) Create(ctx context.Context, chirp Chirp) (Chirp, error) {
+	id, err := newID()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.ID = id
+	chirp.CreatedAt = now()
+	chirp.UpdatedAt = chirp.CreatedAt
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chirps[chirp.ID] = chirp
+	return chirp, nil
+}
+
+func (s *MemoryChirpStore) Get(ctx context.Context, id string) (Chirp, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chirp, ok := s.chirps[id]
+	if !ok {
+		return Chirp{}, ErrNotFound
+	}
+	return chirp, nil
+}
+
+func (s *MemoryChirpStore) List(ctx context.Context) ([]Chirp, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedChirps(s.chirps), nil
+}
+
+func (s *MemoryChirpStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.chirps[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.chirps, id)
+	return nil
+}
+
+// sortedChirps returns the chirps in m ordered by creation time, then ID
+func sortedChirps(m map[string]Chirp) []Chirp {
+	chirps := make([]Chirp, 0, len(m))
+	for _, chirp := range m {
+		chirps = append(chirps, chirp)
+	}
+	slices.SortFunc(chirps, func(a, b Chirp) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return chirps
+}
+
+// MemoryUserStore keeps users in memory; everything is lost on restart
+type MemoryUserStore struct {
+	mu    sync.RWMutex
+	users map[string]User
+}
+
+// NewMemoryUserStore creates an empty in-memory user store
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: make(map[string]User)}
+}
+
+func (s *MemoryUserStore) Create(ctx context.Context, user User) (User, error) {
+	id, err := newID()
+	if err != nil {
+		return User{}, err
+	}
+	user.ID = id
+	user.CreatedAt = now()
+	user.UpdatedAt = user.CreatedAt
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := findUserByEmail(s.users, user.Email); ok {
+		return User{}, ErrEmailTaken
+	}
+	s.users[user.ID] = user
+	return user, nil
+}
+
+func (s *MemoryUserStore) Get(ctx context.Context, id string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *MemoryUserStore) GetByEmail(ctx context.Context, email string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := findUserByEmail(s.users, email)
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+// findUserByEmail scans m for the user registered with email
+func findUserByEmail(m map[string]User, email string) (User, bool) {
+	for _, user := range m {
+		if user.Email == email {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// sortedUsers returns the users in m ordered by creation time, then ID
+func sortedUsers(m map[string]User) []User {
+	users := make([]User, 0, len(m))
+	for _, user := range m {
+		users = append(users, user)
+	}
+	slices.SortFunc(users, func(a, b User) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return users
+}
+
+// MemoryRefreshTokenStore keeps refresh tokens in memory; everything is lost on restart
+type MemoryRefreshTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]RefreshToken
+}
+
+// NewMemoryRefreshTokenStore creates an empty in-memory refresh token store
+func NewMemoryRefreshTokenStore() *MemoryRefreshTokenStore {
+	return &MemoryRefreshTokenStore{tokens: make(map[string]RefreshToken)}
+}
+
+func (s *MemoryRefreshTokenStore) Create(ctx context.Context, token RefreshToken) (RefreshToken, error) {
+	token.CreatedAt = now()
+	token.UpdatedAt = token.CreatedAt
+	token.RevokedAt = nil
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token.Token] = token
+	return token, nil
+}
+
+func (s *MemoryRefreshTokenStore) Get(ctx context.Context, token string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, ok := s.tokens[token]
+	if !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	return rt, nil
+}
+
+func (s *MemoryRefreshTokenStore) Revoke(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, ok := s.tokens[token]
+	if !ok {
+		return ErrNotFound
+	}
+	s.tokens[token] = revoked(rt)
+	return nil
+}
+
+func (s *MemoryRefreshTokenStore) Rotate(ctx context.Context, token string, t time.Time) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, ok := s.tokens[token]
+	if !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	if !rt.Usable(t) {
+		return RefreshToken{}, ErrTokenUnusable
+	}
+	s.tokens[token] = revoked(rt)
+	return rt, nil
+}
+
+// revoked returns rt marked as revoked now, keeping the first revocation time
+func revoked(rt RefreshToken) RefreshToken {
+	if rt.RevokedAt != nil {
+		return rt
+	}
+	t := now()
+	rt.RevokedAt = &t
+	rt.UpdatedAt = t
+	return rt
+}
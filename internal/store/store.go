@@ -0,0 +1,107 @@
+// Package store persists Chirpy data behind small, swappable interfaces
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is returned when the requested record does not exist
+var ErrNotFound = errors.New("store: not found")
+
+// ErrEmailTaken is returned when creating a user whose email is already registered
+var ErrEmailTaken = errors.New("store: email already registered")
+
+// ErrTokenUnusable is returned by RefreshTokenStore.Rotate for a revoked or expired token
+var ErrTokenUnusable = errors.New("store: refresh token revoked or expired")
+
+// Chirp is a single saved post
+type Chirp struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Body      string    `json:"body"`
+	UserID    string    `json:"user_id"`
+}
+
+// ChirpStore saves and retrieves chirps.
+// Create assigns the ID and timestamps; callers only fill in Body and UserID.
+// List returns chirps ordered from oldest to newest.
+type ChirpStore interface {
+	Create(ctx context.Context, chirp Chirp) (Chirp, error)
+	Get(ctx context.Context, id string) (Chirp, error)
+	List(ctx context.Context) ([]Chirp, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// User is a registered account.
+// HashedPassword is persisted by the store but must never be sent to clients.
+type User struct {
+	ID             string    `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	Email          string    `json:"email"`
+	HashedPassword string    `json:"hashed_password"`
+}
+
+// UserStore saves and retrieves users.
+// Create assigns the ID and timestamps and rejects duplicate emails with ErrEmailTaken;
+// emails are compared exactly, so callers should normalize them first.
+type UserStore interface {
+	Create(ctx context.Context, user User) (User, error)
+	Get(ctx context.Context, id string) (User, error)
+	GetByEmail(ctx context.Context, email string) (User, error)
+}
+
+// RefreshToken is an opaque, server-side session token that can be exchanged for
+// access tokens until it expires or is revoked
+type RefreshToken struct {
+	Token     string     `json:"token"`
+	UserID    string     `json:"user_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Usable reports whether the token is neither revoked nor expired at t
+func (rt RefreshToken) Usable(t time.Time) bool {
+	return rt.RevokedAt == nil && t.Before(rt.ExpiresAt)
+}
+
+// RefreshTokenStore saves and revokes refresh tokens.
+// Create sets the timestamps; callers fill in Token, UserID and ExpiresAt.
+// Revoke marks a token as revoked and keeps it so reuse can be detected.
+// Rotate revokes a token only if it is still usable at t, as one step, and returns
+// it as it was; of two concurrent calls for the same token only one succeeds.
+type RefreshTokenStore interface {
+	Create(ctx context.Context, token RefreshToken) (RefreshToken, error)
+	Get(ctx context.Context, token string) (RefreshToken, error)
+	Revoke(ctx context.Context, token string) error
+	Rotate(ctx context.Context, token string, t time.Time) (RefreshToken, error)
+}
+
+// Pinger is implemented by stores that can report whether they are still able to
+// save; health checks use it
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// newID returns a random RFC 4122 version 4 UUID
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generate id: %w", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// now returns the current time truncated for stable JSON round trips
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
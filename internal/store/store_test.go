@@ -0,0 +1,542 @@
+package store_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShepBook/chirpy/internal/store"
+)
+
+// newChirpStores returns one of each ChirpStore implementation so the
+// shared behaviour tests run against all of them
+func newChirpStores(t *testing.T) map[string]store.ChirpStore {
+	t.Helper()
+
+	fileStore, err := store.NewFileChirpStore(filepath.Join(t.TempDir(), "chirps.json"))
+	if err != nil {
+		t.Fatalf("NewFileChirpStore error: %v", err)
+	}
+
+	return map[string]store.ChirpStore{
+		"memory": store.NewMemoryChirpStore(),
+		"file":   fileStore,
+	}
+}
+
+// Phase 1: ChirpStore Behaviour Testing
+
+func Test_ChirpStore_Create_AssignsIDAndTimestamps(t *testing.T) {
+	for name, chirps := range newChirpStores(t) {
+		t.Run(name, func(t *testing.T) {
+			chirp, err := chirps.Create(context.Background(), store.Chirp{Body: "hello", UserID: "user-1"})
+			if err != nil {
+				t.Fatalf("Create error: %v", err)
+			}
+
+			if chirp.ID == "" {
+				t.Error("Expected ID to be assigned")
+			}
+			if chirp.CreatedAt.IsZero() {
+				t.Error("Expected CreatedAt to be set")
+			}
+			if !chirp.UpdatedAt.Equal(chirp.CreatedAt) {
+				t.Errorf("UpdatedAt = %v, want %v", chirp.UpdatedAt, chirp.CreatedAt)
+			}
+			if chirp.Body != "hello" || chirp.UserID != "user-1" {
+				t.Errorf("Chirp = %+v, want body %q and user %q", chirp, "hello", "user-1")
+			}
+		})
+	}
+}
+
+func Test_ChirpStore_Get_ReturnsCreatedChirp(t *testing.T) {
+	for name, chirps := range newChirpStores(t) {
+		t.Run(name, func(t *testing.T) {
+			created, err := chirps.Create(context.Background(), store.Chirp{Body: "hello"})
+			if err != nil {
+				t.Fatalf("Create error: %v", err)
+			}
+
+			got, err := chirps.Get(context.Background(), created.ID)
+			if err != nil {
+				t.Fatalf("Get error: %v", err)
+			}
+			if got != created {
+				t.Errorf("Get = %+v, want %+v", got, created)
+			}
+		})
+	}
+}
+
+func Test_ChirpStore_Get_UnknownID_ReturnsErrNotFound(t *testing.T) {
+	for name, chirps := range newChirpStores(t) {
+		t.Run(name, func(t *testing.T) {
+			_, err := chirps.Get(context.Background(), "missing")
+			if !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("Get error = %v, want %v", err, store.ErrNotFound)
+			}
+		})
+	}
+}
+
+func Test_ChirpStore_List_ReturnsChirpsInCreationOrder(t *testing.T) {
+	for name, chirps := range newChirpStores(t) {
+		t.Run(name, func(t *testing.T) {
+			bodies := []string{"first", "second", "third"}
+			for _, body := range bodies {
+				if _, err := chirps.Create(context.Background(), store.Chirp{Body: body}); err != nil {
+					t.Fatalf("Create error: %v", err)
+				}
+			}
+
+			got, err := chirps.List(context.Background())
+			if err != nil {
+				t.Fatalf("List error: %v", err)
+			}
+			if len(got) != len(bodies) {
+				t.Fatalf("List returned %d chirps, want %d", len(got), len(bodies))
+			}
+			for i := 1; i < len(got); i++ {
+				if got[i].CreatedAt.Before(got[i-1].CreatedAt) {
+					t.Errorf("Chirp %d created before chirp %d", i, i-1)
+				}
+			}
+		})
+	}
+}
+
+func Test_ChirpStore_Delete_RemovesChirp(t *testing.T) {
+	for name, chirps := range newChirpStores(t) {
+		t.Run(name, func(t *testing.T) {
+			created, err := chirps.Create(context.Background(), store.Chirp{Body: "bye"})
+			if err != nil {
+				t.Fatalf("Create error: %v", err)
+			}
+
+			if err := chirps.Delete(context.Background(), created.ID); err != nil {
+				t.Fatalf("Delete error: %v", err)
+			}
+			if _, err := chirps.Get(context.Background(), created.ID); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("Get after delete error = %v, want %v", err, store.ErrNotFound)
+			}
+			if err := chirps.Delete(context.Background(), created.ID); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("Second delete error = %v, want %v", err, store.ErrNotFound)
+			}
+		})
+	}
+}
+
+// Phase 2: FileChirpStore Durability Testing
+
+func Test_FileChirpStore_Reopen_RestoresChirps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chirps.json")
+
+	first, err := store.NewFileChirpStore(path)
+	if err != nil {
+		t.Fatalf("NewFileChirpStore error: %v", err)
+	}
+	kept, err := first.Create(context.Background(), store.Chirp{Body: "kept", UserID: "user-1"})
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+	deleted, err := first.Create(context.Background(), store.Chirp{Body: "deleted"})
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+	if err := first.Delete(context.Background(), deleted.ID); err != nil {
+		t.Fatalf("Delete error: %v", err)
+	}
+
+	second, err := store.NewFileChirpStore(path)
+	if err != nil {
+		t.Fatalf("Reopen error: %v", err)
+	}
+	got, err := second.List(context.Background())
+	if err != nil {
+		t.Fatalf("List error: %v", err)
+	}
+	if len(got) != 1 || got[0] != kept {
+		t.Errorf("List after reopen = %+v, want [%+v]", got, kept)
+	}
+}
+
+func Test_FileChirpStore_CorruptFile_ReturnsError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chirps.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+
+	if _, err := store.NewFileChirpStore(path); err == nil {
+		t.Error("Expected error opening corrupt store, got nil")
+	}
+}
+
+// Phase 3: UserStore Behaviour Testing
+
+// newUserStores returns one of each UserStore implementation
+func newUserStores(t *testing.T) map[string]store.UserStore {
+	t.Helper()
+
+	fileStore, err := store.NewFileUserStore(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatalf("NewFileUserStore error: %v", err)
+	}
+
+	return map[string]store.UserStore{
+		"memory": store.NewMemoryUserStore(),
+		"file":   fileStore,
+	}
+}
+
+func Test_UserStore_Create_ThenLookup(t *testing.T) {
+	for name, users := range newUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			created, err := users.Create(context.Background(), store.User{Email: "a@example.com", HashedPassword: "hash"})
+			if err != nil {
+				t.Fatalf("Create error: %v", err)
+			}
+			if created.ID == "" || created.CreatedAt.IsZero() {
+				t.Errorf("Expected ID and CreatedAt to be assigned, got %+v", created)
+			}
+
+			byID, err := users.Get(context.Background(), created.ID)
+			if err != nil || byID != created {
+				t.Errorf("Get = %+v, %v; want %+v", byID, err, created)
+			}
+			byEmail, err := users.GetByEmail(context.Background(), "a@example.com")
+			if err != nil || byEmail != created {
+				t.Errorf("GetByEmail = %+v, %v; want %+v", byEmail, err, created)
+			}
+			if _, err := users.GetByEmail(context.Background(), "b@example.com"); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("GetByEmail unknown error = %v, want %v", err, store.ErrNotFound)
+			}
+		})
+	}
+}
+
+func Test_UserStore_Create_DuplicateEmail_ReturnsErrEmailTaken(t *testing.T) {
+	for name, users := range newUserStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := users.Create(context.Background(), store.User{Email: "a@example.com"}); err != nil {
+				t.Fatalf("Create error: %v", err)
+			}
+
+			_, err := users.Create(context.Background(), store.User{Email: "a@example.com"})
+			if !errors.Is(err, store.ErrEmailTaken) {
+				t.Errorf("Duplicate create error = %v, want %v", err, store.ErrEmailTaken)
+			}
+		})
+	}
+}
+
+func Test_FileUserStore_Reopen_RestoresUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+
+	first, err := store.NewFileUserStore(path)
+	if err != nil {
+		t.Fatalf("NewFileUserStore error: %v", err)
+	}
+	created, err := first.Create(context.Background(), store.User{Email: "a@example.com", HashedPassword: "hash"})
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+
+	second, err := store.NewFileUserStore(path)
+	if err != nil {
+		t.Fatalf("Reopen error: %v", err)
+	}
+	got, err := second.GetByEmail(context.Background(), "a@example.com")
+	if err != nil || got != created {
+		t.Errorf("GetByEmail after reopen = %+v, %v; want %+v", got, err, created)
+	}
+}
+
+// Phase 4: RefreshTokenStore Behaviour Testing
+
+func Test_RefreshTokenStore_CreateAndRevoke(t *testing.T) {
+	fileStore, err := store.NewFileRefreshTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatalf("NewFileRefreshTokenStore error: %v", err)
+	}
+	stores := map[string]store.RefreshTokenStore{
+		"memory": store.NewMemoryRefreshTokenStore(),
+		"file":   fileStore,
+	}
+
+	for name, tokens := range stores {
+		t.Run(name, func(t *testing.T) {
+			expiresAt := time.Now().Add(time.Hour)
+			created, err := tokens.Create(context.Background(), store.RefreshToken{Token: "abc", UserID: "user-1", ExpiresAt: expiresAt})
+			if err != nil {
+				t.Fatalf("Create error: %v", err)
+			}
+			if !created.Usable(time.Now()) {
+				t.Error("Expected new token to be usable")
+			}
+			if created.Usable(expiresAt) {
+				t.Error("Expected token to be unusable at its expiry")
+			}
+
+			if err := tokens.Revoke(context.Background(), "abc"); err != nil {
+				t.Fatalf("Revoke error: %v", err)
+			}
+			got, err := tokens.Get(context.Background(), "abc")
+			if err != nil {
+				t.Fatalf("Get error: %v", err)
+			}
+			if got.RevokedAt == nil || got.Usable(time.Now()) {
+				t.Errorf("Expected revoked token to be unusable, got %+v", got)
+			}
+
+			if err := tokens.Revoke(context.Background(), "missing"); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("Revoke unknown error = %v, want %v", err, store.ErrNotFound)
+			}
+		})
+	}
+}
+
+func Test_RefreshTokenStore_Rotate_RevokesOnlyUsableTokens(t *testing.T) {
+	fileStore, err := store.NewFileRefreshTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatalf("NewFileRefreshTokenStore error: %v", err)
+	}
+	stores := map[string]store.RefreshTokenStore{
+		"memory": store.NewMemoryRefreshTokenStore(),
+		"file":   fileStore,
+	}
+
+	for name, tokens := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			tokens.Create(ctx, store.RefreshToken{Token: "live", UserID: "user-1", ExpiresAt: now.Add(time.Hour)})
+			tokens.Create(ctx, store.RefreshToken{Token: "expired", UserID: "user-1", ExpiresAt: now.Add(-time.Second)})
+
+			rt, err := tokens.Rotate(ctx, "live", now)
+			if err != nil || rt.UserID != "user-1" || rt.RevokedAt != nil {
+				t.Fatalf("Rotate = %+v, %v; want the token as it was before revoking", rt, err)
+			}
+			if got, _ := tokens.Get(ctx, "live"); got.RevokedAt == nil {
+				t.Error("Expected Rotate to revoke the token")
+			}
+
+			for _, tt := range []struct {
+				token string
+				want  error
+			}{
+				{"live", store.ErrTokenUnusable},
+				{"expired", store.ErrTokenUnusable},
+				{"missing", store.ErrNotFound},
+			} {
+				if _, err := tokens.Rotate(ctx, tt.token, now); !errors.Is(err, tt.want) {
+					t.Errorf("Rotate %s error = %v, want %v", tt.token, err, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// Phase 5: HitStats Testing
+
+func Test_HitStats_Record_CountsPathsStatusesAndVisitors(t *testing.T) {
+	hits := store.NewHitStats()
+	day := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	hits.Record(store.Hit{Path: "/app/", Status: 200, Visitor: "192.0.2.1", At: day})
+	hits.Record(store.Hit{Path: "/app/", Status: 304, Visitor: "192.0.2.1", At: day.Add(time.Hour)})
+	hits.Record(store.Hit{Path: "/app/logo.png", Status: 200, Visitor: "192.0.2.2", At: day})
+	hits.Record(store.Hit{Path: "/app/missing", Status: 404, Visitor: "192.0.2.1", At: day.AddDate(0, 0, 1)})
+
+	got := hits.Summary()
+	want := store.HitSummary{
+		Total:    4,
+		Paths:    []store.HitCount{{Key: "/app/", Count: 2}, {Key: "/app/logo.png", Count: 1}, {Key: "/app/missing", Count: 1}},
+		Statuses: []store.HitCount{{Key: "200", Count: 2}, {Key: "304", Count: 1}, {Key: "404", Count: 1}},
+		Visitors: []store.HitCount{{Key: "2026-10-17", Count: 1}, {Key: "2026-10-16", Count: 2}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Summary() = %+v, want %+v", got, want)
+	}
+	if hits.Total() != 4 {
+		t.Errorf("Total() = %d, want 4", hits.Total())
+	}
+
+	hits.Reset()
+	if got := hits.Summary(); got.Total != 0 || len(got.Paths) != 0 || len(got.Visitors) != 0 {
+		t.Errorf("Summary() after Reset = %+v, want empty", got)
+	}
+}
+
+func Test_HitStats_ManyPaths_CountedAsOther(t *testing.T) {
+	hits := store.NewHitStats()
+	for i := range 1005 {
+		hits.Record(store.Hit{Path: fmt.Sprintf("/app/%d", i), Status: 404, At: time.Now()})
+	}
+
+	summary := hits.Summary()
+	if len(summary.Paths) != 1001 {
+		t.Errorf("Tracked %d paths, want 1000 plus %s", len(summary.Paths), store.OtherPaths)
+	}
+	if summary.Paths[0] != (store.HitCount{Key: store.OtherPaths, Count: 5}) {
+		t.Errorf("Top path = %+v, want %s with 5 hits", summary.Paths[0], store.OtherPaths)
+	}
+}
+
+func Test_HitStats_OldVisitorDays_Dropped(t *testing.T) {
+	hits := store.NewHitStats()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for day := range 40 {
+		hits.Record(store.Hit{Path: "/app/", Status: 200, Visitor: "192.0.2.1", At: start.AddDate(0, 0, day)})
+	}
+
+	visitors := hits.Summary().Visitors
+	if len(visitors) != 30 || visitors[len(visitors)-1].Key != "2026-01-11" {
+		t.Errorf("Kept %d visitor days ending %v, want the last 30 from 2026-01-11", len(visitors), visitors[len(visitors)-1])
+	}
+}
+
+func Test_HitStats_SaveAndOpen_RestoresCountsWithoutRawIPs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hits.json")
+	hits, err := store.OpenHitStats(path)
+	if err != nil {
+		t.Fatalf("OpenHitStats error: %v", err)
+	}
+	now := time.Now()
+	hits.Record(store.Hit{Path: "/app/", Status: 200, Visitor: "192.0.2.1", At: now})
+	if err := hits.Save(); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile error: %v", err)
+	}
+	// Neither the address nor anything it could be matched against is saved
+	var snapshot map[string]any
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if strings.Contains(string(data), "192.0.2.1") || snapshot["key"] != nil || snapshot["visitors"] != nil {
+		t.Errorf("Snapshot holds visitor data beyond counts:\n%s", data)
+	}
+
+	restored, err := store.OpenHitStats(path)
+	if err != nil {
+		t.Fatalf("OpenHitStats error: %v", err)
+	}
+	if !reflect.DeepEqual(restored.Summary(), hits.Summary()) {
+		t.Errorf("Restored %+v, want %+v", restored.Summary(), hits.Summary())
+	}
+}
+
+func Test_HitStats_ManyVisitors_StopsTellingThemApart(t *testing.T) {
+	hits := store.NewHitStats()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for i := range 100_000 {
+		hits.Record(store.Hit{Path: "/app/", Status: 200, Visitor: strconv.Itoa(i), At: now})
+	}
+	// A known visitor is still recognised, but a new one is counted on every hit
+	hits.Record(store.Hit{Path: "/app/", Status: 200, Visitor: "0", At: now})
+	hits.Record(store.Hit{Path: "/app/", Status: 200, Visitor: "new", At: now})
+	hits.Record(store.Hit{Path: "/app/", Status: 200, Visitor: "new", At: now})
+
+	if visitors := hits.Summary().Visitors; visitors[0].Count != 100_002 {
+		t.Errorf("Visitors = %+v, want 100002", visitors)
+	}
+
+	// The next day starts over
+	hits.Record(store.Hit{Path: "/app/", Status: 200, Visitor: "new", At: now.AddDate(0, 0, 1)})
+	hits.Record(store.Hit{Path: "/app/", Status: 200, Visitor: "new", At: now.AddDate(0, 0, 1)})
+	if visitors := hits.Summary().Visitors; visitors[0].Count != 1 {
+		t.Errorf("Visitors the next day = %+v, want 1", visitors[0])
+	}
+}
+
+func Test_HitStats_NewHitStats_SaveWritesNothing(t *testing.T) {
+	hits := store.NewHitStats()
+	hits.Record(store.Hit{Path: "/app/", Status: 200, At: time.Now()})
+	if err := hits.Save(); err != nil {
+		t.Errorf("Save error: %v", err)
+	}
+}
+
+// Phase 6: Health Testing
+
+func Test_FileStores_Ping_ReportsSaveFailures(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatalf("Mkdir error: %v", err)
+	}
+	chirps, err := store.NewFileChirpStore(filepath.Join(dir, "chirps.json"))
+	if err != nil {
+		t.Fatalf("NewFileChirpStore error: %v", err)
+	}
+	users, err := store.NewFileUserStore(filepath.Join(dir, "users.json"))
+	if err != nil {
+		t.Fatalf("NewFileUserStore error: %v", err)
+	}
+	tokens, err := store.NewFileRefreshTokenStore(filepath.Join(dir, "refresh_tokens.json"))
+	if err != nil {
+		t.Fatalf("NewFileRefreshTokenStore error: %v", err)
+	}
+	pingers := map[string]store.Pinger{"chirps": chirps, "users": users, "refresh_tokens": tokens}
+
+	for name, p := range pingers {
+		if err := p.Ping(context.Background()); err != nil {
+			t.Errorf("%s: Ping error = %v, want nil", name, err)
+		}
+	}
+
+	os.RemoveAll(dir)
+	for name, p := range pingers {
+		if err := p.Ping(context.Background()); err == nil {
+			t.Errorf("%s: Ping error = nil after the directory was removed", name)
+		}
+	}
+
+	// A failed save keeps the store unhealthy even once the directory is back
+	chirps.Create(context.Background(), store.Chirp{Body: "lost", UserID: "user-1"})
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatalf("Mkdir error: %v", err)
+	}
+	if err := chirps.Ping(context.Background()); err == nil {
+		t.Error("chirps: Ping error = nil after a failed save")
+	}
+	if _, err := chirps.Create(context.Background(), store.Chirp{Body: "kept", UserID: "user-1"}); err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+	if err := chirps.Ping(context.Background()); err != nil {
+		t.Errorf("chirps: Ping error = %v after a successful save", err)
+	}
+}
+
+func Test_DiskSpaceCheck_ComparesFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	free, err := store.FreeSpace(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("FreeSpace is not supported on this platform")
+	}
+	if err != nil || free == 0 {
+		t.Fatalf("FreeSpace = %d, %v; want free bytes", free, err)
+	}
+
+	if err := store.DiskSpaceCheck(dir, 1)(context.Background()); err != nil {
+		t.Errorf("Check for 1 byte: error = %v", err)
+	}
+	if err := store.DiskSpaceCheck(dir, math.MaxUint64)(context.Background()); err == nil {
+		t.Error("Check for MaxUint64 bytes: expected an error")
+	}
+	if err := store.DiskSpaceCheck(filepath.Join(dir, "missing"), 1)(context.Background()); err == nil {
+		t.Error("Check for a missing directory: expected an error")
+	}
+}
@@ -2,92 +2,533 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"html/template"
+	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/ShepBook/chirpy/internal/audit"
+	"github.com/ShepBook/chirpy/internal/config"
+	"github.com/ShepBook/chirpy/internal/handoff"
 	httpserver "github.com/ShepBook/chirpy/internal/http"
+	"github.com/ShepBook/chirpy/internal/store"
+	"github.com/ShepBook/chirpy/web"
 )
 
+// platformDev is the platform setting that enables destructive admin endpoints
+const platformDev = "dev"
+
 type apiConfig struct {
-	fileserverHits atomic.Int32
+	fileserverHits atomic.Int64
+	// platform is the environment the server runs in; /admin/reset only works in platformDev
+	platform string
+	// hits breaks fileserverHits down by path, status and visitor; nil keeps only the total
+	hits *store.HitStats
+	// clientIP identifies the visitor behind a request; the connection's address when nil
+	clientIP func(*http.Request) string
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cfg.fileserverHits.Add(1)
-		next.ServeHTTP(w, r)
+		if cfg.hits == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		cfg.hits.Record(store.Hit{Path: r.URL.Path, Status: rec.status, Visitor: cfg.visitor(r), At: time.Now()})
 	})
 }
 
-func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html")
-	w.WriteHeader(http.StatusOK)
-	html := fmt.Sprintf(`<html>
+// visitor returns the client address used to count unique visitors
+func (cfg *apiConfig) visitor(r *http.Request) string {
+	if cfg.clientIP != nil {
+		return cfg.clientIP(r)
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// statusWriter remembers the status code the file server responded with
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// metricsPage is the admin hit count page. The breakdown tables only appear when
+// per-path stats are kept.
+var metricsPage = template.Must(template.New("metrics").Parse(`<html>
   <body>
     <h1>Welcome, Chirpy Admin</h1>
-    <p>Chirpy has been visited %d times!</p>
+    <p>Chirpy has been visited {{.Total}} times!</p>
+{{- with .Breakdown}}
+    <h2>Hits by path</h2>
+    <table>
+{{- range .Paths}}
+      <tr><td>{{.Key}}</td><td>{{.Count}}</td></tr>
+{{- end}}
+    </table>
+    <h2>Hits by status</h2>
+    <table>
+{{- range .Statuses}}
+      <tr><td>{{.Key}}</td><td>{{.Count}}</td></tr>
+{{- end}}
+    </table>
+    <h2>Unique visitors by day (UTC)</h2>
+    <table>
+{{- range .Visitors}}
+      <tr><td>{{.Key}}</td><td>{{.Count}}</td></tr>
+{{- end}}
+    </table>
+{{- end}}
   </body>
-</html>`, cfg.fileserverHits.Load())
-	fmt.Fprint(w, html)
+</html>`))
+
+func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Total     int64
+		Breakdown *store.HitSummary
+	}{Total: cfg.fileserverHits.Load()}
+	if cfg.hits != nil {
+		summary := cfg.hits.Summary()
+		data.Breakdown = &summary
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+	metricsPage.Execute(w, data)
 }
 
 func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
+	if cfg.platform != platformDev {
+		httpserver.RespondWithError(w, r, http.StatusForbidden, httpserver.CodeForbidden,
+			"Reset is only allowed when platform is "+platformDev)
+		return
+	}
 	cfg.fileserverHits.Store(0)
+	if cfg.hits != nil {
+		cfg.hits.Reset()
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
-// MethodRestriction returns a handler that validates the request method
-// and returns HTTP 405 with Allow header if the method doesn't match
-func MethodRestriction(method string, next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != method {
-			w.Header().Set("Allow", method)
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
-		next(w, r)
+// registerAdminRoutes adds the fileserver metrics and reset handlers to router.
+// The server only lets admins reach them.
+func registerAdminRoutes(router *httpserver.Router, cfg *apiConfig) {
+	router.HandleFunc("GET /admin/metrics", cfg.handlerMetrics, httpserver.RouteDoc{
+		Summary: "Fileserver hit count",
+		Tags:    []string{"admin"},
+		Responses: []httpserver.Response{
+			{Status: http.StatusOK, Description: "HTML page with the hit count", ContentType: "text/html", Body: ""},
+		},
+		Errors: []int{http.StatusMethodNotAllowed},
+	})
+	router.HandleFunc("POST /admin/reset", cfg.handlerReset, httpserver.RouteDoc{
+		Summary:     "Reset the fileserver hit count",
+		Description: "Only available when the platform setting is dev; otherwise answers 403.",
+		Tags:        []string{"admin"},
+		Responses:   []httpserver.Response{{Status: http.StatusOK, Description: "The count is reset"}},
+		Errors:      []int{http.StatusForbidden, http.StatusMethodNotAllowed},
+	})
+}
+
+// profanityLoader picks where the profanity list comes from: the configured file,
+// then CHIRPY_PROFANITY_WORDS, then the built-in words
+func profanityLoader(path string) httpserver.ProfanityLoader {
+	if path != "" {
+		return httpserver.ProfanityFile(path)
+	}
+	if _, ok := os.LookupEnv("CHIRPY_PROFANITY_WORDS"); ok {
+		return httpserver.ProfanityEnv("CHIRPY_PROFANITY_WORDS")
 	}
+	return httpserver.StaticProfanityWords(httpserver.DefaultProfanityWords)
+}
+
+// verifyAudit checks the hash chain of the audit log named by the settings in args,
+// reporting to stdout and stderr, and returns the exit code
+func verifyAudit(args []string, stdout, stderr io.Writer) int {
+	conf, err := config.Load(args, os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		config.Usage(stdout)
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	n, err := audit.Verify(conf.AuditLogPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v (%d entries verified before it)\n", err, n)
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s: %d entries, chain intact\n", conf.AuditLogPath, n)
+	return 0
 }
 
 func main() {
-	const filepathRoot = "."
+	if len(os.Args) > 1 && os.Args[1] == "verify-audit" {
+		os.Exit(verifyAudit(os.Args[2:], os.Stdout, os.Stderr))
+	}
 
-	// Instantiate apiConfig
-	cfg := &apiConfig{}
+	conf, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		config.Usage(os.Stdout)
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		config.Usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	// Structured logs for requests and for the standard log package
+	logger, err := httpserver.NewLogger(os.Stderr, conf.LogLevel, conf.LogFormat)
+	if err != nil {
+		log.Fatalf("Logger error: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	// Serve on the sockets handed over by systemd or by the process being replaced, if
+	// any, so connections keep being accepted across restarts. The first one serves the
+	// API and a second one the HTTP redirect.
+	listeners, err := handoff.Inherited()
+	if err != nil {
+		log.Fatalf("Listener error: %v", err)
+	}
+	if len(listeners) > 0 {
+		log.Printf("Using %d inherited listener(s); listen_addr is ignored", len(listeners))
+	} else {
+		ln, err := net.Listen("tcp", conf.ListenAddr)
+		if err != nil {
+			log.Fatalf("Listener error: %v", err)
+		}
+		listeners = append(listeners, ln)
+	}
+	if conf.HTTPRedirectAddr != "" && len(listeners) < 2 {
+		ln, err := net.Listen("tcp", conf.HTTPRedirectAddr)
+		if err != nil {
+			log.Fatalf("Listener error: %v", err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	var profanityOpts []httpserver.ProfanityOption
+	if conf.ProfanityStrict {
+		profanityOpts = append(profanityOpts, httpserver.StrictProfanityMatching())
+	}
+	profanity, err := httpserver.NewProfanityFilter(profanityLoader(conf.ProfanityFile), profanityOpts...)
+	if err != nil {
+		log.Fatalf("Profanity list error: %v", err)
+	}
+
+	// A process started by a restart waits here until the one it replaces has stopped
+	// writing the data files opened below. Whatever can fail without them is done first,
+	// so a broken config leaves the old process serving.
+	if err := handoff.WaitForRelease(); err != nil {
+		log.Fatalf("Restart error: %v", err)
+	}
+
+	// Restore the fileserver hit counts saved before the last shutdown
+	hits, err := store.OpenHitStats(conf.HitsPath)
+	if err != nil {
+		log.Fatalf("Hit stats error: %v", err)
+	}
+	cfg := &apiConfig{hits: hits, platform: conf.Platform}
+	cfg.fileserverHits.Store(hits.Total())
+
+	// Serve the embedded site, or the static_root directory while developing it
+	static := web.FS(conf.StaticRoot)
+	if conf.StaticRoot != "" {
+		log.Printf("Serving static files from %s", conf.StaticRoot)
+	}
 
 	// Create file server and wrap with metrics middleware
-	fileServer := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
+	fileServerOpts := []httpserver.FileServerOption{httpserver.CachePolicies(conf.CacheControl)}
+	if conf.StaticListings {
+		fileServerOpts = append(fileServerOpts, httpserver.DirectoryListings())
+	}
+	if conf.SPAFallback {
+		fileServerOpts = append(fileServerOpts, httpserver.SPAFallback())
+	}
+	fileServer := http.StripPrefix("/app", httpserver.FileServer(static, fileServerOpts...))
 	wrappedFileServer := cfg.middlewareMetricsInc(fileServer)
 
+	// Open the file-backed stores so chirps and users survive restarts
+	chirps, err := store.NewFileChirpStore(conf.ChirpsPath)
+	if err != nil {
+		log.Fatalf("Store error: %v", err)
+	}
+	users, err := store.NewFileUserStore(conf.UsersPath)
+	if err != nil {
+		log.Fatalf("Store error: %v", err)
+	}
+	refreshTokens, err := store.NewFileRefreshTokenStore(conf.RefreshTokensPath)
+	if err != nil {
+		log.Fatalf("Store error: %v", err)
+	}
+
+	// Access tokens are signed with the configured secret so they stay valid across restarts
+	if conf.JWTSecret == "" {
+		log.Println("jwt_secret is not set; access tokens will not survive a restart")
+	}
+
+	// Admin requests and moderation are recorded in their own hash-chained log
+	auditLog, err := audit.Open(conf.AuditLogPath,
+		audit.WithMaxSize(int64(conf.AuditLogMaxSize)),
+		audit.WithMaxFiles(conf.AuditLogMaxFiles),
+	)
+	if err != nil {
+		log.Fatalf("Audit log error: %v", err)
+	}
+	if conf.AdminAPIKey == "" && len(conf.AdminUserIDs) == 0 {
+		log.Println("Neither admin_api_key nor admin_user_ids is set; /admin routes are unreachable")
+	}
+
 	// Create server with wrapped file server
-	server := httpserver.NewWithConfig(wrappedFileServer)
+	opts := []httpserver.Option{
+		httpserver.WithAddr(conf.ListenAddr),
+		httpserver.WithTimeouts(conf.ReadTimeout, conf.WriteTimeout, conf.IdleTimeout),
+		httpserver.WithMaxChirpLength(conf.MaxChirpLength),
+		httpserver.WithChirpStream(conf.StreamBuffer, conf.StreamHeartbeat),
+		httpserver.WithStreamLimits(conf.MaxStreams, conf.MaxClientStreams),
+		httpserver.WithStaticFS(static),
+		httpserver.WithCacheControl(conf.CacheControl),
+		httpserver.WithLogger(logger),
+		httpserver.WithProfanityFilter(profanity),
+		httpserver.WithChirpStore(chirps),
+		httpserver.WithUserStore(users),
+		httpserver.WithRefreshTokenStore(refreshTokens),
+		httpserver.WithAdmins(conf.AdminUserIDs...),
+		httpserver.WithAdminAPIKey(conf.AdminAPIKey),
+		httpserver.WithAuditLog(auditLog),
+		httpserver.WithDrainPeriod(conf.DrainPeriod),
+	}
+	if conf.JWTSecret != "" {
+		opts = append(opts, httpserver.WithJWTSecret(conf.JWTSecret))
+	}
+	switch {
+	case conf.TLSCertFile != "":
+		opts = append(opts, httpserver.WithTLS(conf.TLSCertFile, conf.TLSKeyFile))
+	case conf.TLSSelfSigned:
+		opts = append(opts, httpserver.WithSelfSignedTLS(conf.TLSCacheDir))
+	}
+	if conf.HTTPRedirectAddr != "" {
+		opts = append(opts,
+			httpserver.WithHTTPRedirect(conf.HTTPRedirectAddr),
+			httpserver.WithRedirectListener(listeners[1]),
+		)
+	}
+	for group, limit := range map[string]config.RateLimit{
+		httpserver.RateLimitAuth:  conf.RateLimitAuth,
+		httpserver.RateLimitWrite: conf.RateLimitWrite,
+		httpserver.RateLimitRead:  conf.RateLimitRead,
+	} {
+		opts = append(opts, httpserver.WithRateLimit(group, httpserver.RateLimit(limit)))
+	}
+	opts = append(opts, httpserver.WithTrustedProxies(conf.TrustedProxies...))
+	if conf.HSTSMaxAge > 0 {
+		opts = append(opts, httpserver.WithHSTS(conf.HSTSMaxAge))
+	}
+	server := httpserver.NewWithConfig(wrappedFileServer, opts...)
+
+	// Expose the fileserver hit count alongside the server's own metrics
+	server.Metrics().NewGaugeFunc("chirpy_fileserver_hits",
+		"Requests served by the /app file server since the last reset.",
+		func() float64 { return float64(cfg.fileserverHits.Load()) })
+
+	// Stop taking traffic before the data files can no longer be written
+	if conf.MinFreeDisk > 0 {
+		seen := make(map[string]bool)
+		for _, path := range []string{conf.ChirpsPath, conf.UsersPath, conf.RefreshTokensPath, conf.HitsPath, conf.AuditLogPath} {
+			dir := filepath.Dir(path)
+			if !seen[dir] {
+				seen[dir] = true
+				server.Health().AddReadinessCheck("disk_space:"+dir, store.DiskSpaceCheck(dir, uint64(conf.MinFreeDisk)))
+			}
+		}
+	}
 
-	// Register metrics and reset handlers
-	mux := server.Mux()
-	mux.HandleFunc("/admin/metrics", MethodRestriction("GET", cfg.handlerMetrics))
-	mux.HandleFunc("/admin/reset", MethodRestriction("POST", cfg.handlerReset))
+	cfg.clientIP = server.ClientIP
+	registerAdminRoutes(server.Mux(), cfg)
 
 	go func() {
-		log.Println("Starting server on :8080")
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		scheme := "http"
+		if conf.TLSEnabled() {
+			scheme = "https"
+		}
+		log.Printf("Starting %s server on %s", scheme, listeners[0].Addr())
+		if err := server.Serve(listeners[0]); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server error: %v", err)
 		}
 	}()
+	// Let the process that started this one, if any, stop serving
+	if err := handoff.Ready(); err != nil {
+		log.Printf("Readiness notification failed: %v", err)
+	}
+
+	// Save the hit counts now and then so a crash loses at most one interval of them
+	stopSaving := make(chan struct{})
+	savingStopped := make(chan struct{})
+	go func() {
+		defer close(savingStopped)
+		ticker := time.NewTicker(conf.HitsSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := hits.Save(); err != nil {
+					log.Printf("Saving hit stats failed: %v", err)
+				}
+			case <-stopSaving:
+				return
+			}
+		}
+	}()
+
+	// Once requests have drained: stop the background saver, flush the hit counts and
+	// close the audit log, in that order
+	server.OnShutdown("stop hit saver", conf.ShutdownHookTimeout, func(ctx context.Context) error {
+		close(stopSaving)
+		select {
+		case <-savingStopped:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	server.OnShutdown("save hit stats", conf.ShutdownHookTimeout, func(ctx context.Context) error {
+		return hits.Save()
+	})
+	server.OnShutdown("close audit log", conf.ShutdownHookTimeout, func(ctx context.Context) error {
+		return auditLog.Close()
+	})
+
+	// Reload the profanity list on SIGHUP without restarting
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := profanity.Reload(); err != nil {
+				log.Printf("Profanity list reload failed, keeping previous list: %v", err)
+				continue
+			}
+			log.Println("Profanity list reloaded")
+		}
+	}()
 
-	stop := make(chan os.Signal, 1)
+	stop := make(chan os.Signal, 2)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-	<-stop
+	restart := make(chan os.Signal, 1)
+	handoff.NotifyRestart(restart)
+	for {
+		select {
+		case <-stop:
+			// The drain period comes on top of the time requests get to finish
+			os.Exit(shutdown(server, stop, conf.DrainPeriod+conf.ShutdownTimeout))
+		case <-restart:
+			// The new process starts up to opening the data files, then waits while this
+			// one stops taking requests, finishes the ones in flight and flushes and
+			// closes its files. New connections queue on the shared listeners meanwhile.
+			log.Println("Restarting: starting a new process on the same listeners")
+			ctx, cancel := context.WithTimeout(context.Background(), restartTimeout)
+			child, err := handoff.Reexec(ctx, listeners...)
+			cancel()
+			if err != nil {
+				log.Printf("Restart failed, still serving: %v", err)
+				continue
+			}
+			log.Printf("Process %d is waiting to take over the listeners", child.Process.Pid)
+			code := shutdown(handingOff{server}, stop, conf.ShutdownTimeout)
+			ctx, cancel = context.WithTimeout(context.Background(), restartTimeout)
+			err = child.Release(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("Restart failed after this process stopped serving: %v", err)
+				os.Exit(exitUnclean)
+			}
+			log.Printf("Process %d took over the listeners", child.Process.Pid)
+			os.Exit(code)
+		}
+	}
+}
+
+// restartTimeout is how long a restarted process gets to reach WaitForRelease, and
+// then to start serving once released, before it is killed
+const restartTimeout = 30 * time.Second
+
+// handingOff stops a server with HandOff instead of Shutdown
+type handingOff struct{ *httpserver.Server }
+
+func (h handingOff) Shutdown(ctx context.Context) error { return h.HandOff(ctx) }
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// Exit codes of the server process
+const (
+	exitClean = 0
+	// exitUnclean means requests were cut off at shutdown_timeout or a shutdown hook failed
+	exitUnclean = 1
+	// exitForced means a second signal cut the shutdown short
+	exitForced = 3
+)
+
+// stoppable is the part of the server shutdown needs
+type stoppable interface {
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+// shutdown stops server gracefully, giving in-flight requests timeout to finish, and
+// returns the exit code. Another signal on stop closes every connection at once.
+func shutdown(server stoppable, stop <-chan os.Signal, timeout time.Duration) int {
+	log.Println("Shutting down; signal again to force quit")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Shutdown error: %v", err)
+
+	done := make(chan error, 1)
+	go func() { done <- server.Shutdown(ctx) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Printf("Shutdown error: %v", err)
+			return exitUnclean
+		}
+		log.Println("Server stopped")
+		return exitClean
+	case <-stop:
+		log.Println("Forcing quit")
+		server.Close()
+		return exitForced
 	}
-	log.Println("Server stopped")
 }
@@ -1,10 +1,21 @@
 package main
 
 import (
+	"context"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/ShepBook/chirpy/internal/audit"
+	httpserver "github.com/ShepBook/chirpy/internal/http"
+	"github.com/ShepBook/chirpy/internal/store"
 )
 
 // Test_apiConfig_Initialization verifies that apiConfig can be created
@@ -13,7 +24,7 @@ func Test_apiConfig_Initialization(t *testing.T) {
 	cfg := apiConfig{}
 
 	got := cfg.fileserverHits.Load()
-	want := int32(0)
+	want := int64(0)
 
 	if got != want {
 		t.Errorf("fileserverHits initial value = %d, want %d", got, want)
@@ -38,7 +49,7 @@ func Test_middlewareMetricsInc_IncrementsCounter(t *testing.T) {
 	wrappedHandler.ServeHTTP(rec1, req1)
 
 	got := cfg.fileserverHits.Load()
-	want := int32(1)
+	want := int64(1)
 	if got != want {
 		t.Errorf("After 1 request: fileserverHits = %d, want %d", got, want)
 	}
@@ -49,7 +60,7 @@ func Test_middlewareMetricsInc_IncrementsCounter(t *testing.T) {
 	wrappedHandler.ServeHTTP(rec2, req2)
 
 	got = cfg.fileserverHits.Load()
-	want = int32(2)
+	want = int64(2)
 	if got != want {
 		t.Errorf("After 2 requests: fileserverHits = %d, want %d", got, want)
 	}
@@ -112,7 +123,7 @@ func Test_middlewareMetricsInc_ConcurrentRequests(t *testing.T) {
 	wg.Wait()
 
 	got := cfg.fileserverHits.Load()
-	want := int32(numRequests)
+	want := int64(numRequests)
 	if got != want {
 		t.Errorf("After %d concurrent requests: fileserverHits = %d, want %d", numRequests, got, want)
 	}
@@ -163,7 +174,7 @@ func Test_handlerMetrics_ReturnsCorrectFormat(t *testing.T) {
 func Test_handlerMetrics_ReflectsActualCount(t *testing.T) {
 	testCases := []struct {
 		name  string
-		count int32
+		count int64
 		want  string
 	}{
 		{"zero hits", 0, `<html>
@@ -217,7 +228,7 @@ func Test_handlerMetrics_ReflectsActualCount(t *testing.T) {
 
 // Test_handlerReset_ResetsCounter verifies reset endpoint sets counter to 0 using Store(0)
 func Test_handlerReset_ResetsCounter(t *testing.T) {
-	cfg := apiConfig{}
+	cfg := apiConfig{platform: platformDev}
 	cfg.fileserverHits.Store(42)
 
 	req := httptest.NewRequest(http.MethodPost, "/admin/reset", nil)
@@ -226,7 +237,7 @@ func Test_handlerReset_ResetsCounter(t *testing.T) {
 	cfg.handlerReset(rec, req)
 
 	got := cfg.fileserverHits.Load()
-	want := int32(0)
+	want := int64(0)
 	if got != want {
 		t.Errorf("After reset: fileserverHits = %d, want %d", got, want)
 	}
@@ -234,7 +245,7 @@ func Test_handlerReset_ResetsCounter(t *testing.T) {
 
 // Test_handlerReset_ReturnsSuccess verifies reset endpoint returns HTTP 200
 func Test_handlerReset_ReturnsSuccess(t *testing.T) {
-	cfg := apiConfig{}
+	cfg := apiConfig{platform: platformDev}
 	cfg.fileserverHits.Store(100)
 
 	req := httptest.NewRequest(http.MethodPost, "/admin/reset", nil)
@@ -249,7 +260,7 @@ func Test_handlerReset_ReturnsSuccess(t *testing.T) {
 
 // Test_Integration_MetricsWorkflow tests end-to-end workflow: requests -> metrics increment -> reset -> verify zero
 func Test_Integration_MetricsWorkflow(t *testing.T) {
-	cfg := &apiConfig{}
+	cfg := &apiConfig{platform: platformDev}
 
 	// Create a test file server handler
 	fileServerHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -314,87 +325,16 @@ func Test_Integration_MetricsWorkflow(t *testing.T) {
 	}
 }
 
-// Test_methodRestriction_AllowedMethod_CallsHandler verifies that when request method matches allowed method, the wrapped handler is called
-func Test_methodRestriction_AllowedMethod_CallsHandler(t *testing.T) {
-	handlerCalled := false
-	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handlerCalled = true
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("success"))
-	})
-
-	restrictedHandler := MethodRestriction("GET", testHandler)
+// serveAdmin sends an admin request for method and path to a server with cfg's admin routes
+func serveAdmin(cfg *apiConfig, method, path string) *httptest.ResponseRecorder {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithAdminAPIKey("key"))
+	registerAdminRoutes(server.Mux(), cfg)
 
-	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "ApiKey key")
 	rec := httptest.NewRecorder()
-	restrictedHandler(rec, req)
-
-	if !handlerCalled {
-		t.Error("Expected wrapped handler to be called for allowed method")
-	}
-
-	if rec.Code != http.StatusOK {
-		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusOK)
-	}
-
-	if rec.Body.String() != "success" {
-		t.Errorf("Response body = %q, want %q", rec.Body.String(), "success")
-	}
-}
-
-// Test_methodRestriction_DisallowedMethod_Returns405 verifies that when request method doesn't match, returns HTTP 405
-func Test_methodRestriction_DisallowedMethod_Returns405(t *testing.T) {
-	handlerCalled := false
-	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handlerCalled = true
-		w.WriteHeader(http.StatusOK)
-	})
-
-	restrictedHandler := MethodRestriction("POST", testHandler)
-
-	req := httptest.NewRequest(http.MethodGet, "/test", nil)
-	rec := httptest.NewRecorder()
-	restrictedHandler(rec, req)
-
-	if handlerCalled {
-		t.Error("Expected wrapped handler NOT to be called for disallowed method")
-	}
-
-	if rec.Code != http.StatusMethodNotAllowed {
-		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
-	}
-}
-
-// Test_methodRestriction_DisallowedMethod_IncludesAllowHeader verifies that 405 responses include Allow header per RFC 7231
-func Test_methodRestriction_DisallowedMethod_IncludesAllowHeader(t *testing.T) {
-	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
-
-	testCases := []struct {
-		name          string
-		allowedMethod string
-		requestMethod string
-	}{
-		{"GET allowed, POST attempted", "GET", http.MethodPost},
-		{"POST allowed, GET attempted", "POST", http.MethodGet},
-		{"DELETE allowed, PUT attempted", "DELETE", http.MethodPut},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			restrictedHandler := MethodRestriction(tc.allowedMethod, testHandler)
-
-			req := httptest.NewRequest(tc.requestMethod, "/test", nil)
-			rec := httptest.NewRecorder()
-			restrictedHandler(rec, req)
-
-			allowHeader := rec.Header().Get("Allow")
-			if allowHeader != tc.allowedMethod {
-				t.Errorf("Allow header = %q, want %q", allowHeader, tc.allowedMethod)
-			}
-		})
-	}
+	server.ServeHTTP(rec, req)
+	return rec
 }
 
 // Test_handlerMetrics_GetRequest_Returns200 verifies that GET request to /metrics returns 200 with metrics data
@@ -402,12 +342,7 @@ func Test_handlerMetrics_GetRequest_Returns200(t *testing.T) {
 	cfg := &apiConfig{}
 	cfg.fileserverHits.Store(5)
 
-	// Create a wrapped handler with method restriction
-	wrappedHandler := MethodRestriction("GET", cfg.handlerMetrics)
-
-	req := httptest.NewRequest(http.MethodGet, "/admin/metrics", nil)
-	rec := httptest.NewRecorder()
-	wrappedHandler(rec, req)
+	rec := serveAdmin(cfg, http.MethodGet, "/admin/metrics")
 
 	if rec.Code != http.StatusOK {
 		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusOK)
@@ -429,59 +364,49 @@ func Test_handlerMetrics_GetRequest_Returns200(t *testing.T) {
 	}
 }
 
-// Test_handlerMetrics_PostRequest_Returns405 verifies that POST request to /metrics returns 405 with Allow header
-func Test_handlerMetrics_PostRequest_Returns405(t *testing.T) {
-	cfg := &apiConfig{}
-
-	// Create a wrapped handler with method restriction
-	wrappedHandler := MethodRestriction("GET", cfg.handlerMetrics)
-
-	req := httptest.NewRequest(http.MethodPost, "/admin/metrics", nil)
-	rec := httptest.NewRecorder()
-	wrappedHandler(rec, req)
-
-	if rec.Code != http.StatusMethodNotAllowed {
-		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
-	}
-
-	allowHeader := rec.Header().Get("Allow")
-	if allowHeader != "GET" {
-		t.Errorf("Allow header = %q, want %q", allowHeader, "GET")
+// Test_registerAdminRoutes_WrongMethod_Returns405 verifies that the admin routes answer other
+// methods with 405 and an Allow header, leaving the count alone
+func Test_registerAdminRoutes_WrongMethod_Returns405(t *testing.T) {
+	testCases := []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{http.MethodPost, "/admin/metrics", "GET, HEAD"},
+		{http.MethodPut, "/admin/metrics", "GET, HEAD"},
+		{http.MethodGet, "/admin/reset", "POST"},
+		{http.MethodDelete, "/admin/reset", "POST"},
 	}
-}
-
-// Test_handlerMetrics_PutRequest_Returns405 verifies that PUT request to /metrics returns 405 with Allow header
-func Test_handlerMetrics_PutRequest_Returns405(t *testing.T) {
-	cfg := &apiConfig{}
-
-	// Create a wrapped handler with method restriction
-	wrappedHandler := MethodRestriction("GET", cfg.handlerMetrics)
 
-	req := httptest.NewRequest(http.MethodPut, "/admin/metrics", nil)
-	rec := httptest.NewRecorder()
-	wrappedHandler(rec, req)
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			cfg := &apiConfig{platform: platformDev}
+			cfg.fileserverHits.Store(10)
 
-	if rec.Code != http.StatusMethodNotAllowed {
-		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
-	}
+			rec := serveAdmin(cfg, tc.method, tc.path)
 
-	allowHeader := rec.Header().Get("Allow")
-	if allowHeader != "GET" {
-		t.Errorf("Allow header = %q, want %q", allowHeader, "GET")
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Errorf("Status code = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+			}
+			if allow := rec.Header().Get("Allow"); allow != tc.allow {
+				t.Errorf("Allow header = %q, want %q", allow, tc.allow)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("Content-Type = %q, want %q", ct, "application/problem+json")
+			}
+			if got := cfg.fileserverHits.Load(); got != 10 {
+				t.Errorf("Counter should not change: fileserverHits = %d, want 10", got)
+			}
+		})
 	}
 }
 
 // Test_handlerReset_PostRequest_Returns200AndResetsCount verifies that POST request to /admin/reset returns 200 and resets counter to 0
 func Test_handlerReset_PostRequest_Returns200AndResetsCount(t *testing.T) {
-	cfg := &apiConfig{}
+	cfg := &apiConfig{platform: platformDev}
 	cfg.fileserverHits.Store(42)
 
-	// Create a wrapped handler with method restriction
-	wrappedHandler := MethodRestriction("POST", cfg.handlerReset)
-
-	req := httptest.NewRequest(http.MethodPost, "/admin/reset", nil)
-	rec := httptest.NewRecorder()
-	wrappedHandler(rec, req)
+	rec := serveAdmin(cfg, http.MethodPost, "/admin/reset")
 
 	if rec.Code != http.StatusOK {
 		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusOK)
@@ -489,66 +414,190 @@ func Test_handlerReset_PostRequest_Returns200AndResetsCount(t *testing.T) {
 
 	// Verify counter was reset to 0
 	got := cfg.fileserverHits.Load()
-	want := int32(0)
+	want := int64(0)
 	if got != want {
 		t.Errorf("After reset: fileserverHits = %d, want %d", got, want)
 	}
 }
 
-// Test_handlerReset_GetRequest_Returns405 verifies that GET request to /admin/reset returns 405 with Allow header
-func Test_handlerReset_GetRequest_Returns405(t *testing.T) {
-	cfg := &apiConfig{}
-	cfg.fileserverHits.Store(10)
+// Test_registerAdminRoutes_AllDocumented fails when an admin route is added without a RouteDoc
+func Test_registerAdminRoutes_AllDocumented(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler())
+	registerAdminRoutes(server.Mux(), &apiConfig{})
 
-	// Create a wrapped handler with method restriction
-	wrappedHandler := MethodRestriction("POST", cfg.handlerReset)
+	if undocumented := server.Mux().Undocumented(); len(undocumented) > 0 {
+		t.Errorf("Routes registered without documentation: %v", undocumented)
+	}
 
-	req := httptest.NewRequest(http.MethodGet, "/admin/reset", nil)
 	rec := httptest.NewRecorder()
-	wrappedHandler(rec, req)
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	for _, want := range []string{`"/admin/metrics":{"get"`, `"/admin/reset":{"post"`} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("OpenAPI document missing %s", want)
+		}
+	}
+}
+
+// Test_fileserverHits_PastInt32_DoesNotOverflow verifies the counter keeps counting past 2^31-1
+func Test_fileserverHits_PastInt32_DoesNotOverflow(t *testing.T) {
+	cfg := &apiConfig{}
+	cfg.fileserverHits.Store(math.MaxInt32)
 
-	if rec.Code != http.StatusMethodNotAllowed {
-		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	cfg.middlewareMetricsInc(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/app/", nil))
+
+	if got, want := cfg.fileserverHits.Load(), int64(math.MaxInt32)+1; got != want {
+		t.Errorf("fileserverHits = %d, want %d", got, want)
 	}
+}
 
-	allowHeader := rec.Header().Get("Allow")
-	if allowHeader != "POST" {
-		t.Errorf("Allow header = %q, want %q", allowHeader, "POST")
+// Test_handlerMetrics_WithHitStats_RendersBreakdown verifies per-path, per-status and visitor tables, with paths escaped
+func Test_handlerMetrics_WithHitStats_RendersBreakdown(t *testing.T) {
+	cfg := &apiConfig{hits: store.NewHitStats(), platform: platformDev}
+	fileServer := cfg.middlewareMetricsInc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app/" {
+			http.NotFound(w, r)
+		}
+	}))
+	for _, path := range []string{"/app/", "/app/", "/app/<script>"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = path
+		fileServer.ServeHTTP(httptest.NewRecorder(), req)
 	}
 
-	// Verify counter was NOT reset
-	got := cfg.fileserverHits.Load()
-	want := int32(10)
-	if got != want {
-		t.Errorf("Counter should not change: fileserverHits = %d, want %d", got, want)
+	rec := httptest.NewRecorder()
+	cfg.handlerMetrics(rec, httptest.NewRequest(http.MethodGet, "/admin/metrics", nil))
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"<p>Chirpy has been visited 3 times!</p>",
+		"<tr><td>/app/</td><td>2</td></tr>",
+		"<tr><td>/app/&lt;script&gt;</td><td>1</td></tr>",
+		"<tr><td>200</td><td>2</td></tr>",
+		"<tr><td>404</td><td>1</td></tr>",
+		"<tr><td>" + time.Now().UTC().Format(time.DateOnly) + "</td><td>1</td></tr>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Metrics page missing %q\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "<script>") {
+		t.Errorf("Metrics page contains an unescaped path\n%s", body)
+	}
+
+	cfg.handlerReset(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/admin/reset", nil))
+	if total := cfg.hits.Total(); total != 0 {
+		t.Errorf("Hit stats total after reset = %d, want 0", total)
 	}
 }
 
-// Test_handlerReset_DeleteRequest_Returns405 verifies that DELETE request to /admin/reset returns 405 with Allow header
-func Test_handlerReset_DeleteRequest_Returns405(t *testing.T) {
-	cfg := &apiConfig{}
-	cfg.fileserverHits.Store(20)
+// Test_handlerReset_NotDevPlatform_Returns403 verifies reset is refused outside the dev platform
+func Test_handlerReset_NotDevPlatform_Returns403(t *testing.T) {
+	for _, platform := range []string{"", "prod"} {
+		cfg := &apiConfig{platform: platform}
+		cfg.fileserverHits.Store(7)
+
+		rec := httptest.NewRecorder()
+		cfg.handlerReset(rec, httptest.NewRequest(http.MethodPost, "/admin/reset", nil))
 
-	// Create a wrapped handler with method restriction
-	wrappedHandler := MethodRestriction("POST", cfg.handlerReset)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Platform %q: status code = %d, want %d", platform, rec.Code, http.StatusForbidden)
+		}
+		if got := cfg.fileserverHits.Load(); got != 7 {
+			t.Errorf("Platform %q: fileserverHits = %d, want 7", platform, got)
+		}
+	}
+}
+
+// Test_registerAdminRoutes_RequireAdmin verifies the admin routes are unreachable without credentials
+func Test_registerAdminRoutes_RequireAdmin(t *testing.T) {
+	server := httpserver.NewWithConfig(http.NotFoundHandler(), httpserver.WithAdminAPIKey("key"))
+	cfg := &apiConfig{platform: platformDev}
+	cfg.fileserverHits.Store(3)
+	registerAdminRoutes(server.Mux(), cfg)
 
-	req := httptest.NewRequest(http.MethodDelete, "/admin/reset", nil)
 	rec := httptest.NewRecorder()
-	wrappedHandler(rec, req)
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/reset", nil))
+	if rec.Code != http.StatusUnauthorized || cfg.fileserverHits.Load() != 3 {
+		t.Errorf("Without credentials: status code = %d and %d hits, want %d and 3", rec.Code, cfg.fileserverHits.Load(), http.StatusUnauthorized)
+	}
 
-	if rec.Code != http.StatusMethodNotAllowed {
-		t.Errorf("Status code = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	req := httptest.NewRequest(http.MethodPost, "/admin/reset", nil)
+	req.Header.Set("Authorization", "ApiKey key")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || cfg.fileserverHits.Load() != 0 {
+		t.Errorf("With API key: status code = %d and %d hits, want %d and 0", rec.Code, cfg.fileserverHits.Load(), http.StatusOK)
 	}
+}
 
-	allowHeader := rec.Header().Get("Allow")
-	if allowHeader != "POST" {
-		t.Errorf("Allow header = %q, want %q", allowHeader, "POST")
+func Test_verifyAudit_ReportsChainState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := audit.Open(path)
+	if err != nil {
+		t.Fatalf("audit.Open error: %v", err)
 	}
+	for _, actor := range []string{"alice", "bob"} {
+		if _, err := log.Record(audit.Entry{Actor: actor, Action: audit.ActionChirpDelete}); err != nil {
+			t.Fatalf("Record error: %v", err)
+		}
+	}
+	log.Close()
 
-	// Verify counter was NOT reset
-	got := cfg.fileserverHits.Load()
-	want := int32(20)
-	if got != want {
-		t.Errorf("Counter should not change: fileserverHits = %d, want %d", got, want)
+	var stdout, stderr strings.Builder
+	if code := verifyAudit([]string{"-audit-log-path", path}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Exit code = %d, want 0; stderr: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "2 entries, chain intact") {
+		t.Errorf("stdout = %q, want the entry count", stdout.String())
+	}
+
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, []byte(strings.Replace(string(data), `"actor":"bob"`, `"actor":"eve"`, 1)), 0o600)
+	stdout.Reset()
+	if code := verifyAudit([]string{"-audit-log-path", path}, &stdout, &stderr); code != 1 {
+		t.Errorf("Tampered log: exit code = %d, want 1", code)
+	}
+	if !strings.Contains(stderr.String(), "entry 2 has been modified") {
+		t.Errorf("stderr = %q, want the modified entry", stderr.String())
+	}
+}
+
+// fakeServer is a stoppable whose Shutdown waits for release and returns err
+type fakeServer struct {
+	release chan struct{}
+	err     error
+	closed  bool
+}
+
+func (f *fakeServer) Shutdown(ctx context.Context) error {
+	if f.release != nil {
+		<-f.release
+	}
+	return f.err
+}
+
+func (f *fakeServer) Close() error {
+	f.closed = true
+	return nil
+}
+
+func Test_shutdown_ExitCodes(t *testing.T) {
+	if code := shutdown(&fakeServer{}, make(chan os.Signal), time.Second); code != exitClean {
+		t.Errorf("Clean shutdown: exit code = %d, want %d", code, exitClean)
+	}
+	if code := shutdown(&fakeServer{err: context.DeadlineExceeded}, make(chan os.Signal), time.Second); code != exitUnclean {
+		t.Errorf("Failed shutdown: exit code = %d, want %d", code, exitUnclean)
+	}
+
+	// A second signal while Shutdown is still waiting forces the quit
+	server := &fakeServer{release: make(chan struct{})}
+	defer close(server.release)
+	stop := make(chan os.Signal, 1)
+	stop <- syscall.SIGINT
+	if code := shutdown(server, stop, time.Second); code != exitForced {
+		t.Errorf("Second signal: exit code = %d, want %d", code, exitForced)
+	}
+	if !server.closed {
+		t.Error("Expected a forced quit to close the server")
 	}
 }
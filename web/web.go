@@ -0,0 +1,39 @@
+// Package web holds Chirpy's static site: the home page and the files served under /app
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// Assets is the static site compiled into the binary. Only index.html and the
+// assets directory are included, so nothing else in the repository can be served.
+//
+//go:embed index.html assets
+var Assets embed.FS
+
+// FS returns the embedded Assets, or the files under dir when dir is set so the
+// site can be edited without rebuilding. Dot files are never served from disk.
+func FS(dir string) fs.FS {
+	if dir == "" {
+		return Assets
+	}
+	return hideDotfiles{os.DirFS(dir)}
+}
+
+// hideDotfiles reports files and directories whose name starts with "." as missing,
+// so a directory like .git can't be served by mistake
+type hideDotfiles struct {
+	fsys fs.FS
+}
+
+func (h hideDotfiles) Open(name string) (fs.File, error) {
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") && elem != "." {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+	}
+	return h.fsys.Open(name)
+}
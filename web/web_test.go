@@ -0,0 +1,64 @@
+package web_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ShepBook/chirpy/web"
+)
+
+func Test_Assets_ContainsOnlySiteFiles(t *testing.T) {
+	var files []string
+	err := fs.WalkDir(web.Assets, ".", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WalkDir error: %v", err)
+	}
+
+	want := map[string]bool{"index.html": true, "assets/index.html": true, "assets/logo.png": true}
+	for _, file := range files {
+		if !want[file] {
+			t.Errorf("Unexpected embedded file %s", file)
+		}
+		delete(want, file)
+	}
+	for file := range want {
+		t.Errorf("Missing embedded file %s", file)
+	}
+}
+
+func Test_FS_EmptyDir_ReturnsEmbeddedAssets(t *testing.T) {
+	if _, err := fs.Stat(web.FS(""), "assets/logo.png"); err != nil {
+		t.Errorf("Expected embedded logo, got %v", err)
+	}
+}
+
+func Test_FS_Dir_HidesDotfiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{"index.html": "dev", ".env": "SECRET=1", ".git/config": "[core]"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsys := web.FS(dir)
+	if data, err := fs.ReadFile(fsys, "index.html"); err != nil || string(data) != "dev" {
+		t.Errorf("index.html = %q, %v; want the file from disk", data, err)
+	}
+	for _, name := range []string{".env", ".git/config"} {
+		if _, err := fs.ReadFile(fsys, name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: expected fs.ErrNotExist, got %v", name, err)
+		}
+	}
+}